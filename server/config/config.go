@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"time"
 
 	"kefu-server/utils/logger"
 
@@ -11,11 +12,57 @@ import (
 
 type Config struct {
 	Admin AdminConfig `yaml:"admin"`
+	Auth  AuthConfig  `yaml:"auth"`
+	OIDC  OIDCConfig  `yaml:"oidc"`
 }
 
 type AdminConfig struct {
 	Address  string `yaml:"address"`
 	Database string `yaml:"database"`
+	Store    string `yaml:"store"` // badger 数据目录（会话、消息、临时凭据）
+
+	// 允许连接客服 WebSocket 的来源（host 模式，如 "admin.example.com"、"*.example.com"、"localhost:5173"）
+	// 与服务同源的请求始终允许，为空则只允许同源
+	AgentOrigins []string `yaml:"agent_origins"`
+
+	// 客服连接全部断开后保持原在线状态的时间，超时后置为离线，如 "60s"
+	PresenceGrace time.Duration `yaml:"presence_grace"`
+}
+
+type AuthConfig struct {
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl"`  // 访问令牌有效期，如 "15m"
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"` // 刷新令牌有效期，如 "168h"
+	SigningKey      string        `yaml:"signing_key"`       // 当前用于签发令牌的密钥 kid，缺省取 keys 中第一个
+	KeyFile         string        `yaml:"key_file"`          // 未配置 keys 时自动生成并持久化的 HS256 密钥文件
+	Keys            []JWTKey      `yaml:"keys"`              // 全部有效密钥，轮换期间新旧密钥同时保留
+
+	VisitorTokenTTL         time.Duration `yaml:"visitor_token_ttl"`          // 匿名访客令牌有效期，如 "24h"
+	VisitorTokenRenewWindow time.Duration `yaml:"visitor_token_renew_window"` // 访客令牌过期后仍可续期的时间，如 "720h"
+}
+
+// JWTKey 令牌签名密钥
+type JWTKey struct {
+	Kid            string `yaml:"kid"`
+	Alg            string `yaml:"alg"`              // HS256 / RS256 / EdDSA
+	Secret         string `yaml:"secret"`           // HS256 密钥
+	SecretFile     string `yaml:"secret_file"`      // HS256 密钥文件
+	PrivateKeyFile string `yaml:"private_key_file"` // RS256/EdDSA 私钥（PEM），可签发和验证
+	PublicKeyFile  string `yaml:"public_key_file"`  // RS256/EdDSA 公钥（PEM），仅验证
+}
+
+// OIDCConfig 员工单点登录（OpenID Connect 授权码 + PKCE）
+type OIDCConfig struct {
+	Enabled       bool              `yaml:"enabled"`
+	Issuer        string            `yaml:"issuer"`         // IdP issuer，用于发现 /.well-known/openid-configuration
+	ClientID      string            `yaml:"client_id"`      //
+	ClientSecret  string            `yaml:"client_secret"`  // 公共客户端可为空
+	RedirectURL   string            `yaml:"redirect_url"`   // 本服务回调地址 /api/v1/oidc/callback
+	FrontendURL   string            `yaml:"frontend_url"`   // 登录完成后跳转的管理后台地址
+	Scopes        []string          `yaml:"scopes"`         // 缺省 openid profile email
+	UsernameClaim string            `yaml:"username_claim"` // 缺省 preferred_username
+	RoleClaim     string            `yaml:"role_claim"`     // 如 groups 或 roles，值可为字符串或数组
+	RoleMapping   map[string]string `yaml:"role_mapping"`   // IdP 角色/组 => 本地角色
+	DefaultRole   string            `yaml:"default_role"`   // 未匹配到映射时的角色，为空则拒绝登录
 }
 
 var AppConfig *Config
@@ -38,8 +85,37 @@ func LoadConfig(configPath string) (*Config, error) {
 		return nil, err
 	}
 
+	// 缺省值
+	if config.Admin.Store == "" {
+		config.Admin.Store = "data/kv"
+	}
+	if config.Admin.PresenceGrace <= 0 {
+		config.Admin.PresenceGrace = 60 * time.Second
+	}
+	if config.Auth.AccessTokenTTL <= 0 {
+		config.Auth.AccessTokenTTL = 15 * time.Minute
+	}
+	if config.Auth.RefreshTokenTTL <= 0 {
+		config.Auth.RefreshTokenTTL = 7 * 24 * time.Hour
+	}
+	if config.Auth.VisitorTokenTTL <= 0 {
+		config.Auth.VisitorTokenTTL = 24 * time.Hour
+	}
+	if config.Auth.VisitorTokenRenewWindow <= 0 {
+		config.Auth.VisitorTokenRenewWindow = 30 * 24 * time.Hour
+	}
+	if config.Auth.KeyFile == "" {
+		config.Auth.KeyFile = "data/jwt.key"
+	}
+	if len(config.OIDC.Scopes) == 0 {
+		config.OIDC.Scopes = []string{"openid", "profile", "email"}
+	}
+	if config.OIDC.UsernameClaim == "" {
+		config.OIDC.UsernameClaim = "preferred_username"
+	}
+
 	AppConfig = &config
-	logger.Infof("config loaded successfully: %+v", config)
+	logger.Infof("config loaded successfully: %+v", config.Admin) // 不输出密钥等敏感配置
 	return &config, nil
 }
 
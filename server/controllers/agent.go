@@ -2,7 +2,7 @@ package controllers
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"net/http"
 	"sync"
 	"time"
@@ -10,63 +10,171 @@ import (
 	"github.com/coder/websocket"
 	"github.com/gin-gonic/gin"
 
+	"kefu-server/config"
 	"kefu-server/models"
+	"kefu-server/protocol"
 	"kefu-server/service"
+	"kefu-server/utils"
 	"kefu-server/utils/logger"
-)
-
-const (
-	MessageTypeRsp = "message.rsp"
+	"kefu-server/utils/response"
 )
 
 // AgentConn 表示一个客服的 WebSocket 连接
 type AgentConn struct {
-	Conn     *websocket.Conn
-	AgentID  string
-	SendChan chan []byte
-	Done     chan struct{}
+	Conn        *websocket.Conn
+	AgentID     string
+	Role        string
+	WorkspaceID uint
+	Version     int // 连接时协商的协议版本
+	SendChan    chan []byte
+	Done        chan struct{}
 }
 
-// 全局客服连接池：agent_id => *AgentConn
+// 全局客服连接池：同一客服可以同时打开多个连接（多个标签页或设备）
 var (
-	agentConns sync.Map // map[string]*AgentConn
+	agentConnsMu sync.RWMutex
+	agentConns   = make(map[string]map[*AgentConn]struct{}) // agent_id => 连接集合
 )
 
+func init() {
+	// 客服被禁用或删除时断开其 WebSocket 连接
+	service.OnUserDisabled(KickAgent)
+	// 在线状态变化时通知主管和客服本人
+	service.OnPresenceChange(broadcastPresence)
+	// 客服上线时为排队中的会话分配客服
+	service.OnPresenceChange(func(agentID string, status int) {
+		go routeQueuedSessions(agentID, status)
+	})
+}
+
 // 注册客服连接
 func registerAgentConn(agentID string, conn *AgentConn) {
-	agentConns.Store(agentID, conn)
+	agentConnsMu.Lock()
+	defer agentConnsMu.Unlock()
+	if agentConns[agentID] == nil {
+		agentConns[agentID] = make(map[*AgentConn]struct{})
+	}
+	agentConns[agentID][conn] = struct{}{}
 }
 
-// 注销客服连接
-func unregisterAgentConn(agentID string) {
-	agentConns.Delete(agentID)
+// 注销客服连接，保留同一客服的其他连接
+func unregisterAgentConn(agentID string, conn *AgentConn) {
+	agentConnsMu.Lock()
+	defer agentConnsMu.Unlock()
+	delete(agentConns[agentID], conn)
+	if len(agentConns[agentID]) == 0 {
+		delete(agentConns, agentID)
+	}
 }
 
-// 向客服推送消息（供系统调用）
-func PushMessageToAgent(agentID, sessionID string, msg *models.Message) {
-	if v, ok := agentConns.Load(agentID); ok {
-		conn := v.(*AgentConn)
-		payload, _ := json.Marshal(struct {
-			Type      string          `json:"type"`
-			SessionID string          `json:"session_id"`
-			Message   *models.Message `json:"message"`
-		}{
-			Type:      "message.req",
-			SessionID: sessionID,
-			Message:   msg,
-		})
+// agentConnsOf 客服当前所有连接的快照
+func agentConnsOf(agentID string) []*AgentConn {
+	agentConnsMu.RLock()
+	defer agentConnsMu.RUnlock()
+	conns := make([]*AgentConn, 0, len(agentConns[agentID]))
+	for conn := range agentConns[agentID] {
+		conns = append(conns, conn)
+	}
+	return conns
+}
 
-		select {
-		case conn.SendChan <- payload:
-		default:
-			logger.Warnf("Agent %s send buffer full", agentID)
+// sendToAgent 向客服连接投递消息
+func sendToAgent(conn *AgentConn, payload []byte) {
+	select {
+	case conn.SendChan <- payload:
+	default:
+		logger.Warnf("Agent %s send buffer full", conn.AgentID)
+	}
+}
+
+// presencePayload 在线状态变化消息
+func presencePayload(agentID string, status int) []byte {
+	return protocol.Encode(protocol.TypePresence, "", protocol.Presence{
+		AgentID: agentID,
+		Status:  models.UserStatusName(status),
+	})
+}
+
+// pushEventToAgent 向在线的客服推送 {type, session_id, payload}
+func pushEventToAgent(agentID, msgType, sessionID string, payload any) {
+	conns := agentConnsOf(agentID)
+	if len(conns) == 0 {
+		return
+	}
+	data := protocol.Encode(msgType, sessionID, payload)
+	for _, conn := range conns {
+		sendToAgent(conn, data)
+	}
+}
+
+// broadcastPresence 向同一工作区在线的主管（可查看客服）和客服本人推送在线状态变化
+func broadcastPresence(agentID string, status int) {
+	agent, err := service.GetUserService().GetUser(agentID)
+	if err != nil || agent == nil {
+		return
+	}
+	payload := presencePayload(agentID, status)
+	agentConnsMu.RLock()
+	defer agentConnsMu.RUnlock()
+	for _, conns := range agentConns {
+		for conn := range conns {
+			if conn.AgentID == agentID ||
+				(conn.WorkspaceID == agent.WorkspaceID && models.HasPermission(conn.Role, models.PermUserRead)) {
+				sendToAgent(conn, payload)
+			}
 		}
 	}
 }
 
+// KickAgent 断开客服的所有 WebSocket 连接
+func KickAgent(agentID string) {
+	conns := agentConnsOf(agentID)
+	for _, conn := range conns {
+		go conn.Conn.Close(websocket.StatusPolicyViolation, "account disabled")
+	}
+	if len(conns) > 0 {
+		logger.Infof("Agent %s kicked (%d connections)", agentID, len(conns))
+	}
+}
+
+// 向客服推送消息（供系统调用），附带访客提交的咨询前表单和已验证的身份
+func PushMessageToAgent(agentID string, session *models.Session, msg *models.Message) {
+	pushEventToAgent(agentID, protocol.TypeMessageReq, session.SID, protocol.AgentMessage{
+		Message:  protocol.NewMessage(msg),
+		PreChat:  session.PreChat,
+		Identity: session.Identity,
+	})
+}
+
 // AgentController 客服控制器
 type AgentController struct{}
 
+// WSTicket 签发一次性 WebSocket 票据，浏览器连接 /ws/agent?ticket=xxx 时使用
+func (ac *AgentController) WSTicket(c *gin.Context) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		logger.Errorf("failed get token claims")
+		response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeUnauthorized)
+		return
+	}
+	ts := service.GetTokenService()
+	if ts == nil {
+		logger.Errorf("token service not initialed")
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	ticket, err := ts.IssueWSTicket(claims.(*utils.Claims))
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	response.ResponseSuccess(c, gin.H{
+		"ticket":     ticket,
+		"expires_in": int(service.WSTicketTTL.Seconds()),
+	})
+}
+
 // WSHandler 处理客服 WebSocket 连接
 func (ac *AgentController) WSHandler(c *gin.Context) {
 	// 从上下文中获取认证后的用户信息（由 auth 中间件注入）
@@ -93,34 +201,59 @@ func (ac *AgentController) WSHandler(c *gin.Context) {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
-	if agent.Role != "agent" || agent.Active != true {
-		logger.Errorf("Agent %s does not have agent role or is not active", agentID)
+	if !models.HasPermission(agent.Role, models.PermChat) || agent.Active != true || !canUseWorkspace(agent) {
+		logger.Errorf("Agent %s does not have chat permission or is not active", agentID)
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
-	// 升级 WebSocket
+	version, perr := protocol.Negotiate(c.Request)
+	if perr != nil {
+		logger.Errorf("Agent %s protocol negotiation failed: %v", agentID, perr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, perr)
+		return
+	}
+
+	// 升级 WebSocket，只允许同源或配置的来源，防止跨站 WebSocket 劫持
 	conn, err := websocket.Accept(c.Writer, c.Request, &websocket.AcceptOptions{
-		InsecureSkipVerify: true,
+		OriginPatterns: config.AppConfig.Admin.AgentOrigins,
+		Subprotocols:   []string{protocol.Subprotocol(version)},
 	})
 	if err != nil {
-		logger.Errorf("WebSocket upgrade failed: %v", err)
-		c.AbortWithStatus(http.StatusInternalServerError)
+		// Accept 已写入响应（来源不允许时为 403）
+		logger.Errorf("WebSocket upgrade failed: %v, origin: %s", err, c.GetHeader("Origin"))
 		return
 	}
 	defer conn.CloseNow()
+	conn.SetReadLimit(protocol.MaxFrameSize)
 
 	// 创建连接对象
 	agentConn := &AgentConn{
-		Conn:     conn,
-		AgentID:  agentID,
-		SendChan: make(chan []byte, 256),
-		Done:     make(chan struct{}),
+		Conn:        conn,
+		AgentID:     agentID,
+		Role:        agent.Role,
+		WorkspaceID: agent.WorkspaceID,
+		Version:     version,
+		SendChan:    make(chan []byte, 256),
+		Done:        make(chan struct{}),
 	}
 
 	// 注册到连接池
 	registerAgentConn(agentID, agentConn)
-	defer unregisterAgentConn(agentID)
+	defer unregisterAgentConn(agentID, agentConn)
+	sendToAgent(agentConn, protocol.Encode(protocol.TypeHello, "", protocol.Hello{Version: version, AgentID: agentID}))
+
+	// 在线状态随连接变化，断开后经过宽限期置为离线
+	presence := service.GetPresenceService()
+	status, changed, err := presence.Connect(agentID)
+	if err != nil {
+		logger.Errorf("Agent %s presence connect failed: %v", agentID, err)
+	}
+	defer presence.Disconnect(agentID)
+	if !changed {
+		// 状态未变化时不会广播，单独告知本人当前状态
+		sendToAgent(agentConn, presencePayload(agentID, status))
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -148,20 +281,19 @@ func (ac *AgentController) readLoop(ctx context.Context, conn *AgentConn) {
 			return
 		}
 
-		var req struct {
-			Type    string `json:"type"`
-			Session string `json:"session_id"`
-			Payload string `json:"payload"`
-		}
-		if err := json.Unmarshal(data, &req); err != nil {
+		frame, perr := protocol.Decode(protocol.EndpointAgent, data)
+		if perr != nil {
+			logger.Warnf("Agent %s frame rejected: %v", conn.AgentID, perr)
+			sendToAgent(conn, protocol.Encode(protocol.TypeError, "", perr))
 			continue
 		}
 
-		if req.Session == "" {
+		if frame.Type == protocol.TypePresenceSet {
+			ac.handlePresence(conn, frame)
 			continue
 		}
 
-		ac.handleMessage(conn.AgentID, req.Session, req.Type, req.Payload)
+		ac.handleMessage(conn, frame)
 	}
 }
 
@@ -189,38 +321,41 @@ func (ac *AgentController) writeLoop(ctx context.Context, conn *AgentConn) {
 	}
 }
 
-func (ac *AgentController) handleMessage(agentID, sessionID, actionType, payload string) {
+func (ac *AgentController) handleMessage(conn *AgentConn, frame *protocol.Frame) {
+	agentID := conn.AgentID
+	sessionID := frame.SessionID
 	ss := service.GetSessionService()
-	session, err := ss.GetSession(sessionID)
+	session, err := ss.GetWorkspaceSession(conn.WorkspaceID, sessionID)
 	if err != nil || session == nil {
 		logger.Errorf("Session %s does not exist", sessionID)
+		sendToAgent(conn, protocol.Encode(protocol.TypeError, sessionID, protocol.NewError(protocol.ErrCodeSessionNotFound, frame.ID, "session not found")))
 		return
 	}
 
-	// 权限校验
+	// 权限校验，未分配给自己的会话按不存在处理
 	if session.CurAgentID != agentID {
 		logger.Errorf("Agent %s not assigned to session %s", agentID, sessionID)
+		sendToAgent(conn, protocol.Encode(protocol.TypeError, sessionID, protocol.NewError(protocol.ErrCodeSessionNotFound, frame.ID, "session not assigned to you")))
 		return
 	}
 
 	now := time.Now().Unix()
 
-	switch actionType {
-	case "message.rsp":
+	switch frame.Type {
+	case protocol.TypeMessageRsp:
 		// 保存客服回复
 		ms := service.GetMsgService()
 		if ms == nil { // 单例
 			logger.Errorf("msg service is not initialized")
 			return
 		}
-		msg := models.Message{
-			Content:   payload,
-			MsgType:   "message.rsp",
-			Timestamp: now,
-		}
+		msg := frame.Payload.(*protocol.MessagePayload).ToModel(models.MessageFromAgent, now)
+		msg.AgentID = agentID
 		msgID, err := ms.SaveMessage(session.VisitorID(), session.AppID(), session.SessionSeq(), &msg)
 		if err != nil {
 			logger.Errorf("Save message failed: %v", err)
+			sendToAgent(conn, protocol.Encode(protocol.TypeError, sessionID, protocol.NewError(protocol.ErrCodeInternal, frame.ID, "failed to save message")))
+			return
 		}
 		msg.MsgID = msgID
 
@@ -230,15 +365,81 @@ func (ac *AgentController) handleMessage(agentID, sessionID, actionType, payload
 
 		PushMessageToVisitor(session.VisitorID(), sessionID, &msg)
 
-	case "close_session":
+	case protocol.TypeTyping:
+		pushEventToVisitor(sessionID, protocol.TypeTyping, protocol.Typing{From: models.MessageFromAgent})
+
+	case protocol.TypeSessionClose:
 		session.Close()
 		ss.SaveSession(session)
+		update := protocol.SessionUpdate{Status: session.Status(), AgentID: agentID}
+		pushEventToVisitor(sessionID, protocol.TypeSessionUpdate, update)
+		sendToAgent(conn, protocol.Encode(protocol.TypeSessionUpdate, sessionID, update))
 
-	case "mark_follow_up":
+	case protocol.TypeSessionFollowUp:
 		session.MarkFollowUp()
 		ss.SaveSession(session)
+		sendToAgent(conn, protocol.Encode(protocol.TypeSessionUpdate, sessionID, protocol.SessionUpdate{Status: session.Status(), AgentID: agentID}))
+	}
+}
 
-	default:
-		logger.Debugf("Unhandled agent action: %s", actionType)
+// handlePresence 客服通过 WebSocket 切换在线状态，离线由断开连接决定
+func (ac *AgentController) handlePresence(conn *AgentConn, frame *protocol.Frame) {
+	name := frame.Payload.(*protocol.PresencePayload).Status
+	status, _ := models.ParseUserStatus(name)
+	if err := service.GetPresenceService().SetStatus(conn.AgentID, status); err != nil {
+		logger.Errorf("Agent %s set presence failed: %v", conn.AgentID, err)
+		sendToAgent(conn, protocol.Encode(protocol.TypeError, "", protocol.NewError(protocol.ErrCodeInternal, frame.ID, "failed to set presence")))
+		return
+	}
+	logger.Infof("Agent %s presence: %s", conn.AgentID, name)
+}
+
+type SetPresenceRequest struct {
+	Status string `json:"status" binding:"required,oneof=online away busy offline"`
+}
+
+// SetPresence 切换自己的在线状态，online / away / busy 需要已建立客服连接
+func (ac *AgentController) SetPresence(c *gin.Context) {
+	var req SetPresenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("set presence request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	user := currentUser(c)
+	if user == nil {
+		return
+	}
+
+	status, _ := models.ParseUserStatus(req.Status)
+	if err := service.GetPresenceService().SetStatus(user.Username, status); err != nil {
+		if errors.Is(err, service.ErrAgentOffline) {
+			response.ResponseError(c, http.StatusConflict, response.ErrCodeAgentOffline)
+			return
+		}
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	logger.Infof("set presence successful: %s %s", user.Username, req.Status)
+	response.ResponseSuccess(c, gin.H{"status": req.Status})
+}
+
+// ListPresence 获取客服在线状态列表
+func (ac *AgentController) ListPresence(c *gin.Context) {
+	appID := c.Query("app_id")
+	// API Key 只能查看负责其所属业务的客服
+	if key := requestAPIKey(c); key != nil {
+		appID = key.AppID
 	}
+
+	list, err := service.GetPresenceService().ListPresence(requestWorkspace(c), appID)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	response.ResponseSuccess(c, gin.H{
+		"data":  list,
+		"total": len(list),
+	})
 }
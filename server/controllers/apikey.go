@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"kefu-server/models"
+	"kefu-server/service"
+	"kefu-server/utils/logger"
+	"kefu-server/utils/response"
+)
+
+// APIKeyController 业务 API Key 管理控制器
+type APIKeyController struct{}
+
+type CreateAPIKeyRequest struct {
+	AppID       string              `json:"app_id" binding:"required"`
+	Name        string              `json:"name" binding:"required,max=100"`
+	Permissions []models.Permission `json:"permissions" binding:"required,min=1"`
+}
+
+type APIKeyIDRequest struct {
+	ID uint `json:"id" binding:"required"`
+}
+
+// APIKeySecretResponse 创建或轮换后返回的密钥明文，只显示这一次
+type APIKeySecretResponse struct {
+	Key    *models.APIKey `json:"key"`
+	Secret string         `json:"secret"`
+}
+
+// requestAPIKey 当前请求使用的 API Key，员工令牌认证时返回 nil
+func requestAPIKey(c *gin.Context) *models.APIKey {
+	value, exists := c.Get("apiKey")
+	if !exists {
+		return nil
+	}
+	key, _ := value.(*models.APIKey)
+	return key
+}
+
+// requestAppScope 当前请求可访问的业务，API Key 只能访问其所属业务；失败时直接写入错误响应
+func requestAppScope(c *gin.Context) (apps []string, all bool, ok bool) {
+	if key := requestAPIKey(c); key != nil {
+		return []string{key.AppID}, false, true
+	}
+	user := currentUser(c)
+	if user == nil {
+		return nil, false, false
+	}
+	apps, all = user.AppScope()
+	return apps, all, true
+}
+
+// apiKeyAllowsUser API Key 只能管理仅负责其所属业务的非管理员账号
+func apiKeyAllowsUser(key *models.APIKey, role string, apps []string) bool {
+	return !models.IsAdminRole(role) && len(apps) == 1 && apps[0] == key.AppID
+}
+
+// keyInWorkspace API Key 所属业务是否在当前工作区
+func keyInWorkspace(c *gin.Context, key *models.APIKey) bool {
+	workspaceID, ok := models.GetAppWorkspace(key.AppID)
+	return ok && workspaceID == requestWorkspace(c)
+}
+
+// ListKeys 获取 API Key 列表，含最近使用时间和吊销状态
+func (kc *APIKeyController) ListKeys(c *gin.Context) {
+	keys, err := service.GetAPIKeyService().ListKeys(requestWorkspace(c), c.Query("app_id"))
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	response.ResponseSuccess(c, gin.H{
+		"data":  keys,
+		"total": len(keys),
+	})
+}
+
+// CreateKey 创建 API Key
+func (kc *APIKeyController) CreateKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("create api key request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	for _, perm := range req.Permissions {
+		if !slices.Contains(models.APIKeyPermissions, perm) {
+			logger.Errorf("permission not allowed for api key: %s", perm)
+			response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+			return
+		}
+	}
+	slices.Sort(req.Permissions)
+	req.Permissions = slices.Compact(req.Permissions)
+
+	var count int64
+	if err := workspaceDB(c).Model(&models.App{}).Where("app_id = ?", req.AppID).Count(&count).Error; err != nil || count == 0 {
+		logger.Errorf("app not found: %s", req.AppID)
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+
+	userName, _ := c.Get("userName")
+	createdBy, _ := userName.(string)
+	key, secret, err := service.GetAPIKeyService().CreateKey(req.AppID, req.Name, req.Permissions, createdBy)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	recordAudit(c, "apikey.create", "apikey", strconv.FormatUint(uint64(key.ID), 10), nil, key)
+	logger.Infof("create api key successful: %s (%s) for app %s", key.Name, key.Prefix, key.AppID)
+	response.ResponseSuccess(c, APIKeySecretResponse{Key: key, Secret: secret})
+}
+
+// RotateKey 轮换 API Key，旧密钥立即失效
+func (kc *APIKeyController) RotateKey(c *gin.Context) {
+	var req APIKeyIDRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("rotate api key request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	as := service.GetAPIKeyService()
+	before, err := as.GetKey(req.ID)
+	if err != nil || !keyInWorkspace(c, before) {
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+	key, secret, err := as.RotateKey(req.ID)
+	if err == service.ErrAPIKeyInvalid {
+		logger.Errorf("cannot rotate revoked api key: %d", req.ID)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	} else if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	recordAudit(c, "apikey.rotate", "apikey", strconv.FormatUint(uint64(req.ID), 10), before, key)
+	logger.Infof("rotate api key successful: %d", req.ID)
+	response.ResponseSuccess(c, APIKeySecretResponse{Key: key, Secret: secret})
+}
+
+// RevokeKey 吊销 API Key
+func (kc *APIKeyController) RevokeKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Query("id"), 10, 64)
+	if err != nil || id == 0 {
+		logger.Errorf("invalid api key id: %s", c.Query("id"))
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	as := service.GetAPIKeyService()
+	before, err := as.GetKey(uint(id))
+	if err != nil || !keyInWorkspace(c, before) {
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+	if err := as.RevokeKey(uint(id)); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	after, _ := as.GetKey(uint(id))
+	recordAudit(c, "apikey.revoke", "apikey", c.Query("id"), before, after)
+
+	logger.Infof("revoke api key successful: %d", id)
+	response.ResponseSuccess(c, gin.H{"message": "revoke successful"})
+}
@@ -1,12 +1,20 @@
 package controllers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"slices"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 
 	"kefu-server/models"
+	"kefu-server/service"
 	"kefu-server/store"
 	"kefu-server/utils/logger"
 	"kefu-server/utils/response"
@@ -22,6 +30,46 @@ type AppRequest struct {
 	WelcomeMsg  string `json:"welcome_msg"`
 	Contact     string `json:"contact"`
 	Status      int    `json:"status" binding:"required,oneof=0 1"`
+
+	Widget        *models.WidgetConfig    `json:"widget"`         // 为空时使用缺省设置
+	BusinessHours *models.BusinessHours   `json:"business_hours"` // 为空时全天营业
+	PreChatForm   *models.PreChatForm     `json:"pre_chat_form"`  // 为空时不需要填写表单
+	Retention     *models.RetentionPolicy `json:"retention"`      // 为空时保留 30 天
+
+	IdentityRequired bool `json:"identity_required"` // 只允许签名验证过的访客
+	AllowLocalhost   bool `json:"allow_localhost"`   // 本地开发模式
+
+	Template string `json:"template"` // 从指定名称的模板创建
+}
+
+// UpdateAppRequest 更新应用，设置项为空时保持不变
+type UpdateAppRequest struct {
+	AppID       string `json:"app_id" binding:"required"`
+	Name        string `json:"name" binding:"required"`
+	Logo        string `json:"logo"`
+	AllowDomain string `json:"allow_domain"`
+	WelcomeMsg  string `json:"welcome_msg"`
+	Contact     string `json:"contact"`
+	Status      int    `json:"status" binding:"required,oneof=0 1"`
+
+	Widget        *models.WidgetConfig    `json:"widget"`         // 为空时保持不变
+	BusinessHours *models.BusinessHours   `json:"business_hours"` // 为空时保持不变
+	PreChatForm   *models.PreChatForm     `json:"pre_chat_form"`  // 为空时保持不变
+	Retention     *models.RetentionPolicy `json:"retention"`      // 为空时保持不变
+
+	IdentityRequired *bool `json:"identity_required"` // 为空时保持不变
+	AllowLocalhost   *bool `json:"allow_localhost"`   // 为空时保持不变
+}
+
+// AppIDRequest 只需要 app_id 的请求
+type AppIDRequest struct {
+	AppID string `json:"app_id" binding:"required"`
+}
+
+// PurgeAppRequest 清除已归档业务的数据，confirm 必须再次填写 app_id
+type PurgeAppRequest struct {
+	AppID   string `json:"app_id" binding:"required"`
+	Confirm string `json:"confirm" binding:"required"`
 }
 
 // GetApps 获取应用列表
@@ -31,9 +79,22 @@ func (ac *AppController) GetApps(c *gin.Context) {
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
 	keyword := c.Query("keyword")
 	statusStr := c.Query("status")
+	archived := c.Query("archived") == "true"
 
-	// 构建查询
-	query := store.DB.Model(&models.App{})
+	// 构建查询，archived=true 时只列出已归档的业务
+	query := workspaceDB(c).Model(&models.App{})
+	if archived {
+		query = query.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+
+	// 非全部业务权限的用户只能看到分配给自己的业务，API Key 只能看到所属业务
+	scope, all, ok := requestAppScope(c)
+	if !ok {
+		return
+	}
+	if !all {
+		query = query.Where("app_id IN ?", scope)
+	}
 
 	// 关键词搜索
 	if keyword != "" {
@@ -72,15 +133,8 @@ func (ac *AppController) GetApps(c *gin.Context) {
 	})
 }
 
-// CreateApp 创建应用
+// CreateApp 创建应用，指定 template 时未填写的设置取模板的值
 func (ac *AppController) CreateApp(c *gin.Context) {
-	// 检查管理员权限
-	if !IsAdmin(c) {
-		logger.Errorf("permission denied, not admin")
-		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
-		return
-	}
-
 	var req AppRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		logger.Errorf("create app request parameter error: %v", err)
@@ -88,111 +142,424 @@ func (ac *AppController) CreateApp(c *gin.Context) {
 		return
 	}
 
+	var agents []string
+	if req.Template != "" {
+		template := findTemplate(c, req.Template)
+		if template == nil {
+			return
+		}
+		agents = mergeAppConfig(&req, &template.Config)
+	}
+
+	app := createApp(c, &req, "app.create")
+	if app == nil {
+		return
+	}
+	for _, warning := range assignAppAgents(c, app.AppID, agents) {
+		logger.Warnf("create app %s from template %s: %s", app.AppID, req.Template, warning)
+	}
+	response.ResponseSuccess(c, app)
+}
+
+// createApp 校验并创建应用，失败时直接写入错误响应并返回 nil
+func createApp(c *gin.Context, req *AppRequest, action string) *models.App {
+	allowDomain, err := models.NormalizeAllowDomain(req.AllowDomain)
+	if err != nil {
+		logger.Errorf("create app allow domain invalid: %v", err)
+		response.ResponseErrorWithMsg(c, http.StatusBadRequest, response.ErrCodeInvalidParams, err.Error())
+		return nil
+	}
+	widget := models.DefaultWidgetConfig()
+	if req.Widget != nil {
+		widget = *req.Widget
+	}
+	if err := widget.Normalize(); err != nil {
+		logger.Errorf("create app widget config invalid: %v", err)
+		response.ResponseErrorWithMsg(c, http.StatusBadRequest, response.ErrCodeInvalidParams, err.Error())
+		return nil
+	}
+	var hours models.BusinessHours
+	if req.BusinessHours != nil {
+		hours = *req.BusinessHours
+	}
+	if err := hours.Validate(); err != nil {
+		logger.Errorf("create app business hours invalid: %v", err)
+		response.ResponseErrorWithMsg(c, http.StatusBadRequest, response.ErrCodeInvalidParams, err.Error())
+		return nil
+	}
+	var form models.PreChatForm
+	if req.PreChatForm != nil {
+		form = *req.PreChatForm
+	}
+	if err := form.Validate(); err != nil {
+		logger.Errorf("create app pre-chat form invalid: %v", err)
+		response.ResponseErrorWithMsg(c, http.StatusBadRequest, response.ErrCodeInvalidParams, err.Error())
+		return nil
+	}
+
+	var retention models.RetentionPolicy
+	if req.Retention != nil {
+		retention = *req.Retention
+	}
+	retention.Normalize()
+
 	// 生成 AppID（如果未提供）
 	appID := req.AppID
 	if appID == "" {
 		appID = models.GenAppID()
 	}
 
-	// 检查 AppID 是否已存在
+	// 检查 AppID 是否已存在（含已归档的业务），访客接入时不带工作区，AppID 需全局唯一
 	var existingApp models.App
-	if err := store.DB.Where("app_id = ?", appID).First(&existingApp).Error; err == nil {
+	if err := store.DB.Unscoped().Where("app_id = ?", appID).First(&existingApp).Error; err == nil {
 		logger.Errorf("app id already exists: %s", appID)
 		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
-		return
+		return nil
 	}
 
 	// 创建应用
 	app := models.App{
-		Name:        req.Name,
-		AppID:       appID,
-		Logo:        req.Logo,
-		AllowDomain: req.AllowDomain,
-		WelcomeMsg:  req.WelcomeMsg,
-		Contact:     req.Contact,
-		Status:      req.Status,
+		Name:          req.Name,
+		AppID:         appID,
+		Logo:          req.Logo,
+		AllowDomain:   allowDomain,
+		WelcomeMsg:    req.WelcomeMsg,
+		Contact:       req.Contact,
+		Status:        req.Status,
+		Widget:        widget,
+		BusinessHours: hours,
+		PreChatForm:   form,
+		Retention:     retention,
+
+		IdentitySecret:   models.GenIdentitySecret(),
+		IdentityRequired: req.IdentityRequired,
+		AllowLocalhost:   req.AllowLocalhost,
+
+		WorkspaceID: requestWorkspace(c),
 	}
 
 	if err := store.DB.Create(&app).Error; err != nil {
 		logger.Errorf("create app failed: %v", err)
 		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
-		return
+		return nil
 	}
 
+	recordAudit(c, action, "app", app.AppID, nil, app)
 	logger.Infof("create app successful: %s", app.Name)
-	response.ResponseSuccess(c, app)
+	return &app
 }
 
 // UpdateApp 更新应用
 func (ac *AppController) UpdateApp(c *gin.Context) {
-	// 检查管理员权限
-	if !IsAdmin(c) {
-		logger.Errorf("permission denied, not admin")
-		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+	var req UpdateAppRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("update app request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
 		return
 	}
 
-	var req struct {
-		AppID       string `json:"app_id" binding:"required"`
-		Name        string `json:"name" binding:"required"`
-		Logo        string `json:"logo"`
-		AllowDomain string `json:"allow_domain"`
-		WelcomeMsg  string `json:"welcome_msg"`
-		Contact     string `json:"contact"`
-		Status      int    `json:"status" binding:"required,oneof=0 1"`
+	if app := updateApp(c, &req, "app.update"); app != nil {
+		response.ResponseSuccess(c, app)
 	}
+}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		logger.Errorf("update app request parameter error: %v", err)
-		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
-		return
+// updateApp 校验并更新应用，失败时直接写入错误响应并返回 nil
+func updateApp(c *gin.Context, req *UpdateAppRequest, action string) *models.App {
+	allowDomain, err := models.NormalizeAllowDomain(req.AllowDomain)
+	if err != nil {
+		logger.Errorf("update app allow domain invalid: %v", err)
+		response.ResponseErrorWithMsg(c, http.StatusBadRequest, response.ErrCodeInvalidParams, err.Error())
+		return nil
+	}
+	if req.Widget != nil {
+		if err := req.Widget.Normalize(); err != nil {
+			logger.Errorf("update app widget config invalid: %v", err)
+			response.ResponseErrorWithMsg(c, http.StatusBadRequest, response.ErrCodeInvalidParams, err.Error())
+			return nil
+		}
+	}
+	if req.BusinessHours != nil {
+		if err := req.BusinessHours.Validate(); err != nil {
+			logger.Errorf("update app business hours invalid: %v", err)
+			response.ResponseErrorWithMsg(c, http.StatusBadRequest, response.ErrCodeInvalidParams, err.Error())
+			return nil
+		}
+	}
+	if req.PreChatForm != nil {
+		if err := req.PreChatForm.Validate(); err != nil {
+			logger.Errorf("update app pre-chat form invalid: %v", err)
+			response.ResponseErrorWithMsg(c, http.StatusBadRequest, response.ErrCodeInvalidParams, err.Error())
+			return nil
+		}
 	}
 
 	// 检查应用是否存在
 	var app models.App
-	if err := store.DB.Where("app_id = ?", req.AppID).First(&app).Error; err != nil {
+	if err := workspaceDB(c).Where("app_id = ?", req.AppID).First(&app).Error; err != nil {
 		logger.Errorf("app not found: %s", req.AppID)
 		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
-		return
+		return nil
+	}
+
+	before := app
+
+	// 保留策略变化时需要对已有数据重新应用
+	retentionChanged := false
+	if req.Retention != nil {
+		req.Retention.Normalize()
+		current := app.Retention
+		current.Normalize()
+		retentionChanged = *req.Retention != current
 	}
 
 	// 更新应用
 	updates := map[string]interface{}{
 		"Name":        req.Name,
 		"Logo":        req.Logo,
-		"AllowDomain": req.AllowDomain,
+		"AllowDomain": allowDomain,
 		"WelcomeMsg":  req.WelcomeMsg,
 		"Contact":     req.Contact,
 		"Status":      req.Status,
+		// 配置变化后组件通过新的版本号和 ETag 获取最新配置
+		"ConfigVersion": gorm.Expr("config_version + 1"),
+	}
+	if req.Widget != nil {
+		updates["Widget"] = *req.Widget
+	}
+	if req.BusinessHours != nil {
+		updates["BusinessHours"] = *req.BusinessHours
+	}
+	if req.PreChatForm != nil {
+		updates["PreChatForm"] = *req.PreChatForm
+	}
+	if retentionChanged {
+		updates["Retention"] = *req.Retention
+	}
+	if req.AllowLocalhost != nil {
+		updates["AllowLocalhost"] = *req.AllowLocalhost
+	}
+	if req.IdentityRequired != nil {
+		updates["IdentityRequired"] = *req.IdentityRequired
+		// 早期创建的业务没有身份密钥，开启验证时补发
+		if *req.IdentityRequired && app.IdentitySecret == "" {
+			updates["IdentitySecret"] = models.GenIdentitySecret()
+		}
 	}
 
 	if err := store.DB.Model(&app).Updates(updates).Error; err != nil {
 		logger.Errorf("update app failed: %v", err)
 		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
-		return
+		return nil
 	}
 
 	// 重新获取更新后的数据
-	if err := store.DB.Where("app_id = ?", req.AppID).First(&app).Error; err != nil {
+	if err := workspaceDB(c).Where("app_id = ?", req.AppID).First(&app).Error; err != nil {
 		logger.Errorf("get updated app failed: %v", err)
 		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
-		return
+		return nil
+	}
+
+	if retentionChanged {
+		models.InvalidateRetention(req.AppID)
+		if _, err := service.GetRetentionService().Apply(req.AppID, *req.Retention, c.GetString("userName")); err != nil {
+			logger.Errorf("apply retention policy to app %s failed: %v", req.AppID, err)
+		}
 	}
 
+	recordAudit(c, action, "app", req.AppID, before, app)
 	logger.Infof("update app successful: %s", req.AppID)
-	response.ResponseSuccess(c, app)
+	return &app
 }
 
-// DeleteApp 删除应用
+// DeleteApp 归档应用：软删除并停用接入组件，会话和消息保留，可恢复或清除
 func (ac *AppController) DeleteApp(c *gin.Context) {
-	// 检查管理员权限
-	if !IsAdmin(c) {
-		logger.Errorf("permission denied, not admin")
+	// 获取查询参数
+	appID := c.Query("app_id")
+	if appID == "" {
+		logger.Errorf("app_id is required")
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	// 检查应用是否存在
+	var app models.App
+	if err := workspaceDB(c).Where("app_id = ?", appID).First(&app).Error; err != nil {
+		logger.Errorf("app not found: %s", appID)
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+
+	before := app
+
+	// 停用后归档
+	if err := store.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&app).Update("status", 0).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&app).Error
+	}); err != nil {
+		logger.Errorf("archive app failed: %v", err)
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	recordAudit(c, "app.archive", "app", appID, before, app)
+	logger.Infof("archive app successful: %s", appID)
+	response.ResponseSuccess(c, gin.H{"message": "archive successful"})
+}
+
+// findArchivedApp 查找已归档的应用，失败时直接写入错误响应
+func findArchivedApp(c *gin.Context, appID string) *models.App {
+	var app models.App
+	if err := workspaceDB(c).Unscoped().Where("app_id = ? AND deleted_at IS NOT NULL", appID).First(&app).Error; err != nil {
+		logger.Errorf("archived app not found: %s", appID)
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return nil
+	}
+	return &app
+}
+
+// RestoreApp 恢复已归档的应用，恢复后保持停用状态，需手动启用
+func (ac *AppController) RestoreApp(c *gin.Context) {
+	appID := c.Query("app_id")
+	if appID == "" {
+		logger.Errorf("app_id is required")
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	app := findArchivedApp(c, appID)
+	if app == nil {
+		return
+	}
+	// 正在清除数据的应用不能恢复
+	if job, err := service.GetPurgeService().GetJob(appID); err == nil && job != nil && job.State == service.PurgeStateRunning {
+		response.ResponseError(c, http.StatusConflict, response.ErrCodePurgeRunning)
+		return
+	}
+
+	before := *app
+	if err := store.DB.Unscoped().Model(app).Update("deleted_at", nil).Error; err != nil {
+		logger.Errorf("restore app failed: %v", err)
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	app.DeletedAt = gorm.DeletedAt{}
+
+	recordAudit(c, "app.restore", "app", appID, before, app)
+	logger.Infof("restore app successful: %s", appID)
+	response.ResponseSuccess(c, app)
+}
+
+// PurgeApp 后台清除已归档应用的全部会话和消息，完成后永久删除应用，进度通过 GetPurgeStatus 查询
+func (ac *AppController) PurgeApp(c *gin.Context) {
+	var req PurgeAppRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("purge app request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	if req.Confirm != req.AppID {
+		logger.Errorf("purge app not confirmed: %s", req.AppID)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodePurgeNotConfirmed)
+		return
+	}
+	app := findArchivedApp(c, req.AppID)
+	if app == nil {
+		return
+	}
+	ps := service.GetPurgeService()
+	if ps == nil {
+		logger.Errorf("purge service not initialized")
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	job, err := ps.StartPurge(requestWorkspace(c), req.AppID, c.GetString("userName"))
+	if err != nil {
+		if errors.Is(err, service.ErrPurgeRunning) {
+			response.ResponseError(c, http.StatusConflict, response.ErrCodePurgeRunning)
+			return
+		}
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	recordAudit(c, "app.purge", "app", req.AppID, app, nil)
+	logger.Infof("purge app started: %s", req.AppID)
+	response.ResponseSuccess(c, job)
+}
+
+// GetPurgeStatus 查询清除进度
+func (ac *AppController) GetPurgeStatus(c *gin.Context) {
+	appID := c.Query("app_id")
+	if appID == "" {
+		logger.Errorf("app_id is required")
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	ps := service.GetPurgeService()
+	if ps == nil {
+		logger.Errorf("purge service not initialized")
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	job, err := ps.GetJob(appID)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	// 升级前的任务没有工作区，属于缺省工作区
+	if job == nil || max(job.WorkspaceID, models.DefaultWorkspaceID) != requestWorkspace(c) {
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+	response.ResponseSuccess(c, job)
+}
+
+// GetRetentionStatus 查询保留策略重新应用的进度
+func (ac *AppController) GetRetentionStatus(c *gin.Context) {
+	appID := c.Query("app_id")
+	if appID == "" {
+		logger.Errorf("app_id is required")
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	if workspaceID, ok := models.GetAppWorkspace(appID); !ok || workspaceID != requestWorkspace(c) {
+		logger.Errorf("app not found: %s", appID)
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+	scope, all, ok := requestAppScope(c)
+	if !ok {
+		return
+	}
+	if !all && !slices.Contains(scope, appID) {
+		logger.Errorf("retention status of app %s out of scope", appID)
 		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
 		return
 	}
+	rs := service.GetRetentionService()
+	if rs == nil {
+		logger.Errorf("retention service not initialized")
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
 
-	// 获取查询参数
+	job, err := rs.GetJob(appID)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	if job == nil {
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+	response.ResponseSuccess(c, job)
+}
+
+// GetIdentitySecret 查看访客身份签名密钥，供接入方服务端计算 user_hash 或签发 identity_token
+func (ac *AppController) GetIdentitySecret(c *gin.Context) {
 	appID := c.Query("app_id")
 	if appID == "" {
 		logger.Errorf("app_id is required")
@@ -200,23 +567,48 @@ func (ac *AppController) DeleteApp(c *gin.Context) {
 		return
 	}
 
-	// 检查应用是否存在
 	var app models.App
-	if err := store.DB.Where("app_id = ?", appID).First(&app).Error; err != nil {
+	if err := workspaceDB(c).Where("app_id = ?", appID).First(&app).Error; err != nil {
 		logger.Errorf("app not found: %s", appID)
 		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
 		return
 	}
 
-	// 删除应用
-	if err := store.DB.Unscoped().Delete(&app).Error; err != nil {
-		logger.Errorf("delete app failed: %v", err)
+	// 早期创建的业务没有密钥，需先轮换生成
+	response.ResponseSuccess(c, gin.H{
+		"app_id":            app.AppID,
+		"identity_required": app.IdentityRequired,
+		"secret":            app.IdentitySecret,
+	})
+}
+
+// RotateIdentitySecret 轮换访客身份签名密钥，旧密钥签发的凭证立即失效
+func (ac *AppController) RotateIdentitySecret(c *gin.Context) {
+	var req AppIDRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("rotate identity secret request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	var app models.App
+	if err := workspaceDB(c).Where("app_id = ?", req.AppID).First(&app).Error; err != nil {
+		logger.Errorf("app not found: %s", req.AppID)
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+
+	secret := models.GenIdentitySecret()
+	if err := store.DB.Model(&app).Update("identity_secret", secret).Error; err != nil {
+		logger.Errorf("rotate identity secret failed: %v", err)
 		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
 		return
 	}
 
-	logger.Infof("delete app successful: %s", appID)
-	response.ResponseSuccess(c, gin.H{"message": "delete successful"})
+	// 审计记录不包含密钥
+	recordAudit(c, "app.identity_rotate", "app", req.AppID, nil, nil)
+	logger.Infof("rotate identity secret successful: %s", req.AppID)
+	response.ResponseSuccess(c, gin.H{"app_id": req.AppID, "secret": secret})
 }
 
 // GetConfig 获取应用配置（前端 widget 接入接口）
@@ -240,25 +632,59 @@ func (ac *AppController) GetConfig(c *gin.Context) {
 		return
 	}
 
-	// 获取应用信息
-	var app models.App
-	if err := store.DB.Where("app_id = ? AND status = ?", appID, 1).First(&app).Error; err != nil {
-		logger.Errorf("app not found or disabled: %s", appID)
+	// 获取应用信息，应用或其工作区停用时不下发配置
+	app := models.GetApp(appID)
+	if app == nil {
 		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
 		return
 	}
 
 	// 检查域名是否在允许列表中
-	if !models.IsDomainAllowed(origin, referer, app.AllowDomain) {
+	if !app.IsOriginAllowed(origin, referer) {
 		logger.Errorf("domain not allowed: origin=%s, referer=%s", origin, referer)
 		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
 		return
 	}
 
-	// 返回配置信息
-	response.ResponseSuccess(c, gin.H{
-		"name":        app.Name,
-		"logo":        app.Logo,
-		"welcome_msg": app.WelcomeMsg,
-	})
+	// 历史业务没有组件设置，补齐缺省值
+	app.Widget.Normalize()
+
+	// 当前是否营业，非营业时间告知下次营业时间
+	now := time.Now()
+	open := app.BusinessHours.IsOpen(now)
+	hours := gin.H{
+		"enabled": app.BusinessHours.Enabled,
+		"open":    open,
+	}
+	if app.BusinessHours.Enabled {
+		hours["time_zone"] = app.BusinessHours.TimeZone
+		hours["out_of_hours_msg"] = app.BusinessHours.OutOfHoursMsg
+		if next := app.BusinessHours.NextOpen(now); !open && !next.IsZero() {
+			hours["next_open"] = next.Format(time.RFC3339)
+		}
+	}
+
+	config := gin.H{
+		"version":        app.ConfigVersion,
+		"name":           app.Name,
+		"logo":           app.Logo,
+		"welcome_msg":    app.WelcomeMsg,
+		"widget":         app.Widget,
+		"business_hours": hours,
+		"pre_chat_form":  app.PreChatForm,
+		// 开启后接入方需传入 user_hash 或 identity_token
+		"identity_required": app.IdentityRequired,
+	}
+
+	// 按内容计算 ETag，组件携带 If-None-Match 重新验证，未变化时返回 304
+	data, _ := json.Marshal(config)
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	response.ResponseSuccess(c, config)
 }
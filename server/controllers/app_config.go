@@ -0,0 +1,387 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"gorm.io/gorm"
+
+	"kefu-server/models"
+	"kefu-server/service"
+	"kefu-server/store"
+	"kefu-server/utils/logger"
+	"kefu-server/utils/response"
+)
+
+const maxAppConfigSize = 1 << 20 // 导入的配置文件大小上限
+
+// 导入方式
+const (
+	importModeCreate = "create"
+	importModeUpdate = "update"
+)
+
+// CreateTemplateRequest 创建模板，config 与 from_app_id 二选一
+type CreateTemplateRequest struct {
+	Name        string            `json:"name" binding:"required,max=64"`
+	Description string            `json:"description" binding:"max=255"`
+	Config      *models.AppConfig `json:"config"`
+	FromAppID   string            `json:"from_app_id"` // 以已有业务的当前配置为模板
+}
+
+// UpdateTemplateRequest 更新模板，config 为空时只修改描述
+type UpdateTemplateRequest struct {
+	Name        string            `json:"name" binding:"required"`
+	Description string            `json:"description" binding:"max=255"`
+	Config      *models.AppConfig `json:"config"`
+}
+
+// exportAppConfig 读取业务配置及接待分配，失败时直接写入错误响应
+func exportAppConfig(c *gin.Context, appID string) *models.AppConfig {
+	var app models.App
+	if err := workspaceDB(c).Where("app_id = ?", appID).First(&app).Error; err != nil {
+		logger.Errorf("app not found: %s", appID)
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return nil
+	}
+	agents, err := service.GetUserService().AppAgents(requestWorkspace(c), appID)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return nil
+	}
+	config := models.ExportAppConfig(&app, agents)
+	return &config
+}
+
+// validateAppConfig 校验导入或模板中的配置，失败时直接写入错误响应
+func validateAppConfig(c *gin.Context, config *models.AppConfig) bool {
+	err := binding.Validator.ValidateStruct(config)
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		logger.Errorf("app config invalid: %v", err)
+		response.ResponseErrorWithMsg(c, http.StatusBadRequest, response.ErrCodeInvalidParams, err.Error())
+		return false
+	}
+	return true
+}
+
+// findTemplate 按名称查找模板，失败时直接写入错误响应
+func findTemplate(c *gin.Context, name string) *models.AppTemplate {
+	template, err := models.GetAppTemplate(requestWorkspace(c), name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Errorf("app template not found: %s", name)
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return nil
+	} else if err != nil {
+		logger.Errorf("get app template %s failed: %v", name, err)
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return nil
+	}
+	return template
+}
+
+// mergeAppConfig 请求中未填写的设置取模板的值，返回模板的接待分配
+func mergeAppConfig(req *AppRequest, config *models.AppConfig) []string {
+	for _, field := range []struct {
+		dst *string
+		src string
+	}{
+		{&req.Logo, config.Logo},
+		{&req.AllowDomain, config.AllowDomain},
+		{&req.WelcomeMsg, config.WelcomeMsg},
+		{&req.Contact, config.Contact},
+	} {
+		if *field.dst == "" {
+			*field.dst = field.src
+		}
+	}
+	if req.Widget == nil {
+		req.Widget = &config.Widget
+	}
+	if req.BusinessHours == nil {
+		req.BusinessHours = &config.BusinessHours
+	}
+	if req.PreChatForm == nil {
+		req.PreChatForm = &config.PreChatForm
+	}
+	if req.Retention == nil {
+		req.Retention = &config.Retention
+	}
+	req.IdentityRequired = req.IdentityRequired || config.IdentityRequired
+	req.AllowLocalhost = req.AllowLocalhost || config.AllowLocalhost
+	return config.Routing.Agents
+}
+
+// assignAppAgents 按配置设置业务的接待分配，agents 为 nil 时保持不变
+// 修改客服负责的业务需要客服管理权限，没有权限或部分客服不存在时返回提示而不中断
+func assignAppAgents(c *gin.Context, appID string, agents []string) []string {
+	if agents == nil {
+		return nil
+	}
+	if key := requestAPIKey(c); key != nil {
+		return []string{"routing skipped: api key cannot assign agents"}
+	}
+	if role := c.GetString("role"); !models.HasPermission(role, models.PermUserWrite) {
+		return []string{"routing skipped: permission denied"}
+	}
+
+	us := service.GetUserService()
+	before, err := us.AppAgents(requestWorkspace(c), appID)
+	if err != nil {
+		return []string{"routing skipped: " + err.Error()}
+	}
+	missing, err := us.SetAppAgents(requestWorkspace(c), appID, agents)
+	if err != nil {
+		return []string{"routing skipped: " + err.Error()}
+	}
+	var warnings []string
+	for _, username := range missing {
+		warnings = append(warnings, "agent not found: "+username)
+	}
+	after, _ := us.AppAgents(requestWorkspace(c), appID)
+	if !slices.Equal(before, after) {
+		recordAudit(c, "app.routing", "app", appID, gin.H{"agents": before}, gin.H{"agents": after})
+	}
+	return warnings
+}
+
+// ExportApp 导出业务配置，format 为 json（缺省）或 yaml
+func (ac *AppController) ExportApp(c *gin.Context) {
+	appID := c.Query("app_id")
+	format := c.DefaultQuery("format", models.AppConfigFormatJSON)
+	if appID == "" || (format != models.AppConfigFormatJSON && format != models.AppConfigFormatYAML) {
+		logger.Errorf("export app request parameter error: app_id=%s, format=%s", appID, format)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	scope, all, ok := requestAppScope(c)
+	if !ok {
+		return
+	}
+	if !all && !slices.Contains(scope, appID) {
+		logger.Errorf("export app %s out of scope", appID)
+		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+		return
+	}
+
+	config := exportAppConfig(c, appID)
+	if config == nil {
+		return
+	}
+	data, err := models.MarshalAppConfig(config, format)
+	if err != nil {
+		logger.Errorf("marshal app config %s failed: %v", appID, err)
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	contentType := "application/json"
+	if format == models.AppConfigFormatYAML {
+		contentType = "application/yaml"
+	}
+	c.Header("Content-Disposition", "attachment; filename=\""+appID+"."+format+"\"")
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// ImportApp 导入业务配置，mode=create 新建业务（可指定 app_id），mode=update 覆盖 app_id 对应业务的配置
+// 请求体为导出的 json 或 yaml 文件，format 为空时按内容判断
+func (ac *AppController) ImportApp(c *gin.Context) {
+	mode := c.DefaultQuery("mode", importModeCreate)
+	appID := c.Query("app_id")
+	if (mode != importModeCreate && mode != importModeUpdate) || (mode == importModeUpdate && appID == "") {
+		logger.Errorf("import app request parameter error: mode=%s, app_id=%s", mode, appID)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxAppConfigSize+1))
+	if err != nil || len(data) > maxAppConfigSize {
+		logger.Errorf("read app config failed: %v, size: %d", err, len(data))
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	config, err := models.ParseAppConfig(data, c.Query("format"))
+	if err != nil {
+		logger.Errorf("parse app config failed: %v", err)
+		response.ResponseErrorWithMsg(c, http.StatusBadRequest, response.ErrCodeInvalidParams, err.Error())
+		return
+	}
+	if !validateAppConfig(c, config) {
+		return
+	}
+	if config.Name == "" {
+		response.ResponseErrorWithMsg(c, http.StatusBadRequest, response.ErrCodeInvalidParams, "name is required")
+		return
+	}
+
+	var app *models.App
+	if mode == importModeCreate {
+		app = createApp(c, &AppRequest{
+			Name:             config.Name,
+			AppID:            appID,
+			Logo:             config.Logo,
+			AllowDomain:      config.AllowDomain,
+			WelcomeMsg:       config.WelcomeMsg,
+			Contact:          config.Contact,
+			Status:           config.Status,
+			Widget:           &config.Widget,
+			BusinessHours:    &config.BusinessHours,
+			PreChatForm:      &config.PreChatForm,
+			Retention:        &config.Retention,
+			IdentityRequired: config.IdentityRequired,
+			AllowLocalhost:   config.AllowLocalhost,
+		}, "app.import")
+	} else {
+		app = updateApp(c, &UpdateAppRequest{
+			AppID:            appID,
+			Name:             config.Name,
+			Logo:             config.Logo,
+			AllowDomain:      config.AllowDomain,
+			WelcomeMsg:       config.WelcomeMsg,
+			Contact:          config.Contact,
+			Status:           config.Status,
+			Widget:           &config.Widget,
+			BusinessHours:    &config.BusinessHours,
+			PreChatForm:      &config.PreChatForm,
+			Retention:        &config.Retention,
+			IdentityRequired: &config.IdentityRequired,
+			AllowLocalhost:   &config.AllowLocalhost,
+		}, "app.import")
+	}
+	if app == nil {
+		return
+	}
+
+	warnings := assignAppAgents(c, app.AppID, config.Routing.Agents)
+	if warnings == nil {
+		warnings = []string{}
+	}
+	logger.Infof("import app successful: %s, mode: %s", app.AppID, mode)
+	response.ResponseSuccess(c, gin.H{
+		"app":      app,
+		"mode":     mode,
+		"warnings": warnings,
+	})
+}
+
+// ListTemplates 获取业务模板列表
+func (ac *AppController) ListTemplates(c *gin.Context) {
+	var templates []models.AppTemplate
+	if err := workspaceDB(c).Order("name").Find(&templates).Error; err != nil {
+		logger.Errorf("list app templates failed: %v", err)
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	response.ResponseSuccess(c, gin.H{
+		"data":  templates,
+		"total": len(templates),
+	})
+}
+
+// CreateTemplate 创建业务模板
+func (ac *AppController) CreateTemplate(c *gin.Context) {
+	var req CreateTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Config == nil) == (req.FromAppID == "") {
+		logger.Errorf("create app template request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	config := req.Config
+	if req.FromAppID != "" {
+		if config = exportAppConfig(c, req.FromAppID); config == nil {
+			return
+		}
+	} else if config.Version == 0 {
+		config.Version = models.AppConfigVersion
+	}
+	if !validateAppConfig(c, config) {
+		return
+	}
+
+	if _, err := models.GetAppTemplate(requestWorkspace(c), req.Name); err == nil {
+		logger.Errorf("app template already exists: %s", req.Name)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	template := models.AppTemplate{
+		Name:        req.Name,
+		Description: req.Description,
+		Config:      *config,
+		CreatedBy:   c.GetString("userName"),
+		WorkspaceID: requestWorkspace(c),
+	}
+	if err := store.DB.Create(&template).Error; err != nil {
+		logger.Errorf("create app template failed: %v", err)
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	recordAudit(c, "app_template.create", "app_template", template.Name, nil, template)
+	logger.Infof("create app template successful: %s", template.Name)
+	response.ResponseSuccess(c, template)
+}
+
+// UpdateTemplate 更新业务模板
+func (ac *AppController) UpdateTemplate(c *gin.Context) {
+	var req UpdateTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("update app template request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	template := findTemplate(c, req.Name)
+	if template == nil {
+		return
+	}
+
+	before := *template
+	template.Description = req.Description
+	if req.Config != nil {
+		if req.Config.Version == 0 {
+			req.Config.Version = models.AppConfigVersion
+		}
+		if !validateAppConfig(c, req.Config) {
+			return
+		}
+		template.Config = *req.Config
+	}
+	if err := store.DB.Save(template).Error; err != nil {
+		logger.Errorf("update app template failed: %v", err)
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	recordAudit(c, "app_template.update", "app_template", template.Name, before, template)
+	logger.Infof("update app template successful: %s", template.Name)
+	response.ResponseSuccess(c, template)
+}
+
+// DeleteTemplate 删除业务模板，已基于模板创建的业务不受影响
+func (ac *AppController) DeleteTemplate(c *gin.Context) {
+	name := c.Query("name")
+	if name == "" {
+		logger.Errorf("name is required")
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	template := findTemplate(c, name)
+	if template == nil {
+		return
+	}
+	if err := store.DB.Delete(template).Error; err != nil {
+		logger.Errorf("delete app template failed: %v", err)
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	recordAudit(c, "app_template.delete", "app_template", name, template, nil)
+	logger.Infof("delete app template successful: %s", name)
+	response.ResponseSuccess(c, gin.H{"message": "delete successful"})
+}
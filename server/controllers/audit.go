@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"kefu-server/models"
+	"kefu-server/service"
+	"kefu-server/utils/logger"
+	"kefu-server/utils/response"
+)
+
+// AuditController 审计日志控制器
+type AuditController struct{}
+
+type AuditRetentionRequest struct {
+	RetentionDays *int `json:"retention_days" binding:"required,min=0,max=3650"` // 0 表示永久保留
+}
+
+// recordAudit 记录当前请求的操作人、来源和目标对象的前后快照
+func recordAudit(c *gin.Context, action, targetType, targetID string, before, after interface{}) {
+	entry := service.AuditEntry{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     before,
+		After:      after,
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+
+		WorkspaceID: requestWorkspace(c),
+	}
+	if key := requestAPIKey(c); key != nil {
+		entry.Actor, entry.ActorType = key.Prefix, models.AuditActorAPIKey
+	} else if userName, ok := c.Get("userName"); ok {
+		entry.Actor, entry.ActorType = userName.(string), models.AuditActorUser
+	} else {
+		entry.ActorType = models.AuditActorSystem
+	}
+	service.GetAuditService().Record(entry)
+}
+
+// parseAuditTime 解析 RFC3339 或 unix 秒时间
+func parseAuditTime(value string) (*time.Time, bool) {
+	if value == "" {
+		return nil, true
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, true
+	}
+	if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+		t := time.Unix(sec, 0)
+		return &t, true
+	}
+	return nil, false
+}
+
+// ListLogs 分页查询审计日志
+func (ac *AuditController) ListLogs(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	from, okFrom := parseAuditTime(c.Query("from"))
+	to, okTo := parseAuditTime(c.Query("to"))
+	if !okFrom || !okTo {
+		logger.Errorf("invalid audit time range: from=%s, to=%s", c.Query("from"), c.Query("to"))
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	filter := service.AuditFilter{
+		Actor:      c.Query("actor"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+		From:       from,
+		To:         to,
+	}
+	logs, total, err := service.GetAuditService().ListLogs(requestWorkspace(c), filter, page, pageSize)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	response.ResponseSuccess(c, gin.H{
+		"data":  logs,
+		"total": total,
+	})
+}
+
+// GetRetention 获取审计日志保留天数
+func (ac *AuditController) GetRetention(c *gin.Context) {
+	response.ResponseSuccess(c, gin.H{"retention_days": service.GetAuditService().RetentionDays()})
+}
+
+// SetRetention 设置审计日志保留天数
+func (ac *AuditController) SetRetention(c *gin.Context) {
+	var req AuditRetentionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("audit retention request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	as := service.GetAuditService()
+	before := gin.H{"retention_days": as.RetentionDays()}
+	if err := as.SetRetentionDays(*req.RetentionDays); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	after := gin.H{"retention_days": *req.RetentionDays}
+	recordAudit(c, "setting.update", "setting", models.SettingAuditRetentionDays, before, after)
+
+	logger.Infof("audit retention updated: %d days", *req.RetentionDays)
+	response.ResponseSuccess(c, after)
+}
@@ -0,0 +1,292 @@
+package controllers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"kefu-server/models"
+	"kefu-server/service"
+	"kefu-server/utils/logger"
+	"kefu-server/utils/response"
+)
+
+// MFAController 两步验证控制器
+type MFAController struct{}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code"` // TOTP 验证码或恢复码，绑定阶段调用 setup 时可为空
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type MFAResetRequest struct {
+	Username string `json:"username" binding:"required"`
+}
+
+type MFAPolicyRequest struct {
+	Roles []string `json:"roles" binding:"dive,oneof=admin supervisor agent analyst"`
+}
+
+// MFASetupResponse 绑定验证器所需信息
+type MFASetupResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth:// URI，前端生成二维码
+}
+
+// MFALoginResponse 两步验证通过后的登录响应，首次绑定时附带恢复码
+type MFALoginResponse struct {
+	*LoginResponse
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// LoginSetup 登录过程中为被强制要求两步验证的用户生成密钥
+func (mc *MFAController) LoginSetup(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("mfa login setup request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	ms := service.GetMFAService()
+	if ms == nil {
+		logger.Errorf("mfa service not initialed")
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	challenge, err := ms.GetChallenge(req.MFAToken)
+	if err != nil {
+		logger.Errorf("mfa login setup failed: %v", err)
+		response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeTokenInvalid)
+		return
+	}
+
+	user, err := service.GetUserService().GetUserByID(challenge.UserID)
+	if err != nil || !user.Active {
+		response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeTokenInvalid)
+		return
+	}
+	if user.TOTPEnabled {
+		logger.Errorf("mfa already enabled for user: %s", user.Username)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	secret, uri, err := ms.BeginSetup(user)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	response.ResponseSuccess(c, MFASetupResponse{Secret: secret, URI: uri})
+}
+
+// LoginVerify 登录第二步：校验验证码（或完成首次绑定）后签发令牌
+func (mc *MFAController) LoginVerify(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		logger.Errorf("mfa login request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	ms := service.GetMFAService()
+	ls := service.GetLockoutService()
+	if ms == nil || ls == nil {
+		logger.Errorf("mfa service not initialed")
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	challenge, err := ms.GetChallenge(req.MFAToken)
+	if err != nil {
+		logger.Errorf("mfa login failed: %v", err)
+		response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeTokenInvalid)
+		return
+	}
+
+	user, err := service.GetUserService().GetUserByID(challenge.UserID)
+	if err != nil || !user.Active {
+		response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeTokenInvalid)
+		return
+	}
+
+	// 验证码错误与密码错误一样计入锁定，锁定期间不再校验验证码
+	ip := c.ClientIP()
+	if remaining, err := ls.CheckLocked(user.Username, ip); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	} else if remaining > 0 {
+		logger.Errorf("mfa login locked: user=%s ip=%s remaining=%s", user.Username, ip, remaining)
+		c.Header("Retry-After", strconv.Itoa(int(remaining.Seconds())+1))
+		response.ResponseError(c, http.StatusTooManyRequests, response.ErrCodeLoginLocked)
+		return
+	}
+
+	var recoveryCodes []string
+	if user.TOTPEnabled {
+		if !ms.Verify(user, req.Code) {
+			logger.Errorf("invalid mfa code for user: %s", user.Username)
+			ms.FailChallenge(req.MFAToken)
+			ls.RecordFailure(user.Username, ip)
+			response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeMFACodeInvalid)
+			return
+		}
+	} else {
+		// 首次绑定：确认密钥并启用
+		recoveryCodes, err = ms.ConfirmSetup(user, req.Code)
+		if err != nil {
+			logger.Errorf("mfa enrollment failed for user %s: %v", user.Username, err)
+			ms.FailChallenge(req.MFAToken)
+			ls.RecordFailure(user.Username, ip)
+			response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeMFACodeInvalid)
+			return
+		}
+	}
+	ms.CompleteChallenge(req.MFAToken)
+	// 两步验证完成后才清除失败计数
+	ls.RecordSuccess(user.Username)
+
+	if requirePasswordChange(c, user, recoveryCodes) {
+		return
+	}
+	if resp, ok := issueLoginTokens(c, user); ok {
+		logger.Infof("user login successful with mfa: %s", user.Username)
+		response.ResponseSuccess(c, MFALoginResponse{LoginResponse: resp, RecoveryCodes: recoveryCodes})
+	}
+}
+
+// Setup 已登录用户开始绑定验证器
+func (mc *MFAController) Setup(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		return
+	}
+	if user.TOTPEnabled {
+		logger.Errorf("mfa already enabled for user: %s", user.Username)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	secret, uri, err := service.GetMFAService().BeginSetup(user)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	response.ResponseSuccess(c, MFASetupResponse{Secret: secret, URI: uri})
+}
+
+// Confirm 已登录用户确认绑定，返回恢复码
+func (mc *MFAController) Confirm(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("mfa confirm request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	user := currentUser(c)
+	if user == nil {
+		return
+	}
+
+	codes, err := service.GetMFAService().ConfirmSetup(user, req.Code)
+	if err != nil {
+		logger.Errorf("mfa confirm failed for user %s: %v", user.Username, err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeMFACodeInvalid)
+		return
+	}
+	recordAudit(c, "user.2fa_enable", "user", user.Username, gin.H{"totp_enabled": false}, gin.H{"totp_enabled": true})
+	response.ResponseSuccess(c, gin.H{"recovery_codes": codes})
+}
+
+// Disable 已登录用户关闭两步验证（需提供验证码，且角色未被强制要求）
+func (mc *MFAController) Disable(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("mfa disable request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	user := currentUser(c)
+	if user == nil {
+		return
+	}
+
+	ms := service.GetMFAService()
+	if slices.Contains(ms.RequiredRoles(user.WorkspaceID), user.Role) {
+		logger.Errorf("mfa is required for role %s", user.Role)
+		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+		return
+	}
+	if !ms.Verify(user, req.Code) {
+		logger.Errorf("invalid mfa code for user: %s", user.Username)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeMFACodeInvalid)
+		return
+	}
+	if err := ms.Disable(user); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	recordAudit(c, "user.2fa_disable", "user", user.Username, gin.H{"totp_enabled": true}, gin.H{"totp_enabled": false})
+	response.ResponseSuccess(c, gin.H{"message": "disable successful"})
+}
+
+// ResetUser 管理员重置某用户的两步验证（如丢失设备）
+func (mc *MFAController) ResetUser(c *gin.Context) {
+	var req MFAResetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("mfa reset request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	user, err := service.GetUserService().GetUser(req.Username)
+	if err != nil || user == nil {
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+	if !checkUserTarget(c, user) {
+		return
+	}
+	if err := service.GetMFAService().Disable(user); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	recordAudit(c, "user.2fa_reset", "user", req.Username, gin.H{"totp_enabled": user.TOTPEnabled}, gin.H{"totp_enabled": false})
+	logger.Infof("mfa reset for user %s", req.Username)
+	response.ResponseSuccess(c, gin.H{"message": "reset successful"})
+}
+
+// GetPolicy 获取强制两步验证的角色
+func (mc *MFAController) GetPolicy(c *gin.Context) {
+	response.ResponseSuccess(c, gin.H{"roles": service.GetMFAService().RequiredRoles(requestWorkspace(c))})
+}
+
+// SetPolicy 设置强制两步验证的角色
+func (mc *MFAController) SetPolicy(c *gin.Context) {
+	var req MFAPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("mfa policy request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	if req.Roles == nil {
+		req.Roles = []string{}
+	}
+
+	ms := service.GetMFAService()
+	before := gin.H{"roles": ms.RequiredRoles(requestWorkspace(c))}
+	if err := ms.SetRequiredRoles(requestWorkspace(c), req.Roles); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	recordAudit(c, "setting.update", "setting", models.SettingRequire2FARoles, before, gin.H{"roles": req.Roles})
+
+	logger.Infof("mfa required roles updated: %v", req.Roles)
+	response.ResponseSuccess(c, gin.H{"roles": req.Roles})
+}
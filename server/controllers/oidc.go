@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/gin-gonic/gin"
+
+	"kefu-server/service"
+	"kefu-server/utils/logger"
+	"kefu-server/utils/response"
+)
+
+// OIDCController 单点登录控制器
+type OIDCController struct{}
+
+// oidcStateCookie 保存 state 绑定值的 Cookie，仅在 /oidc 路径下发送
+const oidcStateCookie = "kefu_oidc_state"
+
+type OIDCExchangeRequest struct {
+	Code string `json:"code" binding:"required,max=128"`
+}
+
+// LoginOptions 登录页可用的登录方式
+func (oc *OIDCController) LoginOptions(c *gin.Context) {
+	response.ResponseSuccess(c, gin.H{"password": true, "oidc": service.GetOIDCService() != nil})
+}
+
+// Login 跳转到 IdP 授权页
+func (oc *OIDCController) Login(c *gin.Context) {
+	oidc := service.GetOIDCService()
+	if oidc == nil {
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+
+	authURL, binding, err := oidc.AuthURL(c.Request.Context())
+	if err != nil {
+		logger.Errorf("build oidc auth url failed: %v", err)
+		response.ResponseError(c, http.StatusBadGateway, response.ErrCodeInternalError)
+		return
+	}
+	// IdP 跳转回来是顶级 GET 导航，SameSite=Lax 的 Cookie 会随回调发送
+	setOIDCStateCookie(c, binding, int(service.OIDCStateTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback IdP 回调，完成后带一次性登录码跳转回管理后台
+// 单点登录用户的两步验证由 IdP 负责，此处不再要求本地 TOTP
+func (oc *OIDCController) Callback(c *gin.Context) {
+	oidc := service.GetOIDCService()
+	if oidc == nil {
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+
+	if idpErr := c.Query("error"); idpErr != "" {
+		logger.Errorf("oidc callback error: %s %s", idpErr, c.Query("error_description"))
+		redirectOIDCResult(c, oidc.FrontendURL(), "oidc_error", "idp_error")
+		return
+	}
+	code, state := c.Query("code"), c.Query("state")
+	if code == "" || state == "" {
+		redirectOIDCResult(c, oidc.FrontendURL(), "oidc_error", "invalid_request")
+		return
+	}
+
+	binding, _ := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "", -1)
+	user, err := oidc.Callback(c.Request.Context(), code, state, binding)
+	if err != nil {
+		logger.Errorf("oidc login failed: %v", err)
+		reason := "login_failed"
+		switch {
+		case errors.Is(err, service.ErrOIDCRoleUnmapped):
+			reason = "no_role"
+		case errors.Is(err, service.ErrOIDCUserConflict):
+			reason = "user_conflict"
+		case errors.Is(err, service.ErrOIDCUserDisabled):
+			reason = "user_disabled"
+		case errors.Is(err, service.ErrOIDCStateInvalid):
+			reason = "expired"
+		}
+		redirectOIDCResult(c, oidc.FrontendURL(), "oidc_error", reason)
+		return
+	}
+
+	loginCode, err := oidc.IssueLoginCode(user.ID)
+	if err != nil {
+		redirectOIDCResult(c, oidc.FrontendURL(), "oidc_error", "login_failed")
+		return
+	}
+	logger.Infof("oidc login verified: %s", user.Username)
+	redirectOIDCResult(c, oidc.FrontendURL(), "oidc_code", loginCode)
+}
+
+// Exchange 前端用一次性登录码换取访问令牌和刷新令牌
+func (oc *OIDCController) Exchange(c *gin.Context) {
+	var req OIDCExchangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("oidc exchange request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	oidc := service.GetOIDCService()
+	if oidc == nil {
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+
+	userID, err := oidc.TakeLoginCode(req.Code)
+	if err != nil {
+		logger.Errorf("oidc exchange failed: %v", err)
+		response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeTokenInvalid)
+		return
+	}
+	user, err := service.GetUserService().GetUserByID(userID)
+	if err != nil || !user.Active {
+		response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeTokenInvalid)
+		return
+	}
+
+	if resp, ok := issueLoginTokens(c, user); ok {
+		logger.Infof("user login successful via oidc: %s", user.Username)
+		response.ResponseSuccess(c, resp)
+	}
+}
+
+// setOIDCStateCookie 写入或清除（maxAge < 0）state 绑定 Cookie，路径限定为 /oidc 下的登录和回调
+func setOIDCStateCookie(c *gin.Context, value string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, path.Dir(c.Request.URL.Path), "", secure, true)
+}
+
+// redirectOIDCResult 将结果以查询参数形式附加到前端地址后跳转
+func redirectOIDCResult(c *gin.Context, frontend, key, value string) {
+	target, err := url.Parse(frontend)
+	if err != nil || frontend == "" {
+		logger.Errorf("invalid oidc frontend url: %s", frontend)
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	q := target.Query()
+	q.Set(key, value)
+	target.RawQuery = q.Encode()
+	c.Redirect(http.StatusFound, target.String())
+}
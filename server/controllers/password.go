@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"kefu-server/models"
+	"kefu-server/service"
+	"kefu-server/utils/logger"
+	"kefu-server/utils/response"
+)
+
+type ChangePasswordRequest struct {
+	Current     PasswordProof `json:"current" binding:"required"` // 对登录挑战的签名，证明持有当前密码
+	NewPassword string        `json:"new_password" binding:"required,max=128"`
+}
+
+type CompletePasswordResetRequest struct {
+	Token       string `json:"token" binding:"required,max=128"`
+	NewPassword string `json:"new_password" binding:"required,max=128"`
+}
+
+type ResetPasswordRequest struct {
+	Username string `json:"username" binding:"required"`
+}
+
+// PasswordChangeResponse 登录时被要求修改密码，返回提交新密码所需的令牌而不是登录令牌
+type PasswordChangeResponse struct {
+	PasswordChangeRequired bool     `json:"password_change_required"`
+	PasswordToken          string   `json:"password_token"`
+	ExpiresIn              int      `json:"expires_in"`
+	RecoveryCodes          []string `json:"recovery_codes,omitempty"` // 本次登录中首次绑定两步验证时的恢复码
+}
+
+// requirePasswordChange 用户须修改密码时写入 PasswordChangeResponse，返回 true 表示已写入响应
+func requirePasswordChange(c *gin.Context, user *models.User, recoveryCodes []string) bool {
+	if !user.MustChangePassword {
+		return false
+	}
+	token, err := service.GetUserService().IssuePasswordToken(user.ID, service.PasswordChangeTTL)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return true
+	}
+	logger.Infof("user login verified, password change required: %s", user.Username)
+	response.ResponseSuccess(c, PasswordChangeResponse{
+		PasswordChangeRequired: true,
+		PasswordToken:          token,
+		ExpiresIn:              int(service.PasswordChangeTTL.Seconds()),
+		RecoveryCodes:          recoveryCodes,
+	})
+	return true
+}
+
+// responsePasswordError 将密码校验错误写入响应
+func responsePasswordError(c *gin.Context, username string, err error) {
+	logger.Errorf("password rejected for user %s: %v", username, err)
+	if errors.Is(err, service.ErrPasswordReused) {
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodePasswordReused)
+		return
+	}
+	response.ResponseErrorWithMsg(c, http.StatusBadRequest, response.ErrCodePasswordPolicy, err.Error())
+}
+
+// ChangePassword 已登录用户修改自己的密码（需验证当前密码），成功后需重新登录
+func (uc *UserController) ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("change password request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	user := currentUser(c)
+	if user == nil {
+		return
+	}
+	if user.AuthSource == models.AuthSourceOIDC {
+		logger.Errorf("password change not available for sso user: %s", user.Username)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	// 当前密码校验与登录共用失败计数，防止持有令牌者暴力猜测密码
+	ls := service.GetLockoutService()
+	if ls == nil {
+		logger.Errorf("lockout service not initialed")
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	if remaining, err := ls.CheckLocked(user.Username, c.ClientIP()); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	} else if remaining > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(remaining.Seconds())+1))
+		response.ResponseError(c, http.StatusTooManyRequests, response.ErrCodeLoginLocked)
+		return
+	}
+	challenge := takePasswordChallenge(c, user.Username, &req.Current)
+	if challenge == nil {
+		return
+	}
+	if !verifyPasswordProof(user, challenge, &req.Current) {
+		logger.Errorf("current password mismatch: %s", user.Username)
+		ls.RecordFailure(user.Username, c.ClientIP())
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidCredentials)
+		return
+	}
+
+	us := service.GetUserService()
+	if err := us.ValidateNewPassword(user, req.NewPassword); err != nil {
+		responsePasswordError(c, user.Username, err)
+		return
+	}
+	if err := us.ChangePassword(user, req.NewPassword, false); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	recordAudit(c, "user.password_change", "user", user.Username, nil, nil)
+	logger.Infof("password changed: %s", user.Username)
+	response.ResponseSuccess(c, gin.H{"message": "password changed, please login again"})
+}
+
+// CompletePasswordReset 凭一次性令牌设置新密码（管理员重置后，或登录时被要求修改密码）
+func (uc *UserController) CompletePasswordReset(c *gin.Context) {
+	var req CompletePasswordResetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("password reset request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	us := service.GetUserService()
+	user, err := us.LookupPasswordToken(req.Token)
+	if err != nil {
+		logger.Errorf("password reset failed: %v", err)
+		response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeTokenInvalid)
+		return
+	}
+	if err := us.ValidateNewPassword(user, req.NewPassword); err != nil {
+		responsePasswordError(c, user.Username, err)
+		return
+	}
+	if err := us.ChangePassword(user, req.NewPassword, false); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	us.ConsumePasswordToken(req.Token)
+
+	c.Set("userName", user.Username)
+	recordAudit(c, "user.password_change", "user", user.Username, nil, nil)
+	logger.Infof("password reset completed: %s", user.Username)
+	response.ResponseSuccess(c, gin.H{"message": "password changed, please login again"})
+}
+
+// ResetPassword 管理员重置客服密码，返回一次性重置令牌，用户下次登录前必须设置新密码
+func (uc *UserController) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("reset password request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	us := service.GetUserService()
+	user, err := us.GetUser(req.Username)
+	if err != nil || user == nil {
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+	if !checkUserTarget(c, user) {
+		return
+	}
+	if user.AuthSource == models.AuthSourceOIDC {
+		logger.Errorf("password reset not available for sso user: %s", user.Username)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	token, err := us.ResetPassword(user)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	recordAudit(c, "user.password_reset", "user", user.Username, nil, nil)
+	logger.Infof("password reset for user %s", user.Username)
+	response.ResponseSuccess(c, gin.H{
+		"reset_token": token,
+		"expires_in":  int(service.PasswordResetTTL.Seconds()),
+	})
+}
+
+// GetPasswordPolicy 获取密码策略
+func (uc *UserController) GetPasswordPolicy(c *gin.Context) {
+	response.ResponseSuccess(c, models.GetPasswordPolicy(requestWorkspace(c)))
+}
+
+// SetPasswordPolicy 设置密码策略
+func (uc *UserController) SetPasswordPolicy(c *gin.Context) {
+	var req models.PasswordPolicy
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("password policy request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	before := models.GetPasswordPolicy(requestWorkspace(c))
+	if err := models.SetPasswordPolicy(requestWorkspace(c), req); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	recordAudit(c, "setting.update", "setting", models.SettingPasswordPolicy, before, req)
+	logger.Infof("password policy updated: %+v", req)
+	response.ResponseSuccess(c, req)
+}
@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"kefu-server/models"
+	"kefu-server/protocol"
+	"kefu-server/service"
+	"kefu-server/utils/logger"
+	"kefu-server/utils/response"
+)
+
+// SessionController 会话、聊天记录查询和接口发送消息控制器
+type SessionController struct{}
+
+// SendMessageRequest 通过接口向访客发送消息
+type SendMessageRequest struct {
+	SessionID string                  `json:"session_id" binding:"required"`
+	Message   protocol.MessagePayload `json:"message" binding:"required"`
+}
+
+// SessionItem 会话列表项，附带从会话 id 解析出的业务、访客和当前状态
+type SessionItem struct {
+	*models.Session
+	AppID     string `json:"app_id"`
+	VisitorID string `json:"visitor_id"`
+	Status    string `json:"status"`
+}
+
+func newSessionItem(session *models.Session) SessionItem {
+	return SessionItem{
+		Session:   session,
+		AppID:     session.AppID(),
+		VisitorID: session.VisitorID(),
+		Status:    session.Status(),
+	}
+}
+
+// sessionApps 当前请求可查看会话的业务（限于当前工作区），appID 不为空时只取该业务
+func sessionApps(c *gin.Context, appID string) ([]string, bool) {
+	scope, all, ok := requestAppScope(c)
+	if !ok {
+		return nil, false
+	}
+	query := workspaceDB(c).Model(&models.App{})
+	if !all {
+		query = query.Where("app_id IN ?", scope)
+	}
+	if appID != "" {
+		query = query.Where("app_id = ?", appID)
+	}
+	var apps []string
+	if err := query.Pluck("app_id", &apps).Error; err != nil {
+		logger.Errorf("get session apps failed: %v", err)
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return nil, false
+	}
+	return apps, true
+}
+
+// scopedSession 当前请求可访问的会话，不在工作区或业务范围内时按不存在处理；失败时直接写入错误响应
+func scopedSession(c *gin.Context, sessionID string) *models.Session {
+	session, err := service.GetSessionService().GetWorkspaceSession(requestWorkspace(c), sessionID)
+	if err != nil || session == nil {
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return nil
+	}
+	scope, all, ok := requestAppScope(c)
+	if !ok {
+		return nil
+	}
+	if !all && !slices.Contains(scope, session.AppID()) {
+		logger.Errorf("session %s is out of app scope", sessionID)
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return nil
+	}
+	return session
+}
+
+// ListSessions 分页查询会话，按最后活跃时间倒序
+func (sc *SessionController) ListSessions(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	status := c.Query("status")
+	switch status {
+	case "", models.SessionStatusUnAssigned, models.SessionStatusUnRead, models.SessionStatusUnReply,
+		models.SessionStatusAssigned, models.SessionStatusFollowUP, models.SessionStatusClosed:
+	default:
+		logger.Errorf("invalid session status: %s", status)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	apps, ok := sessionApps(c, c.Query("app_id"))
+	if !ok {
+		return
+	}
+	sessions, total, err := service.GetSessionService().ListSessions(service.SessionFilter{
+		AppIDs: apps,
+		Status: status,
+		Offset: (page - 1) * pageSize,
+		Limit:  pageSize,
+	})
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	items := make([]SessionItem, len(sessions))
+	for i, session := range sessions {
+		items[i] = newSessionItem(session)
+	}
+	response.ResponseSuccess(c, gin.H{
+		"data":  items,
+		"total": total,
+	})
+}
+
+// ListMessages 查询会话的聊天记录（按时间正序），before 为消息 id 时向前翻页
+func (sc *SessionController) ListMessages(c *gin.Context) {
+	sessionID := c.Query("session_id")
+	if sessionID == "" {
+		logger.Errorf("session_id is required")
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	session := scopedSession(c, sessionID)
+	if session == nil {
+		return
+	}
+	msgs, err := service.GetMsgService().GetMessagesBySession(session.SID, c.Query("before"), limit)
+	if err != nil {
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	response.ResponseSuccess(c, gin.H{
+		"session": newSessionItem(session),
+		"data":    msgs,
+		"total":   len(msgs),
+	})
+}
+
+// SendMessage 向会话的访客发送消息：员工以客服身份发送，API Key 以系统身份发送
+// 访客在线时实时推送，会话已分配客服时同步推送给客服
+func (sc *SessionController) SendMessage(c *gin.Context) {
+	var req SendMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("send message request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	if err := req.Message.Validate(); err != nil {
+		logger.Errorf("invalid message: %v", err)
+		response.ResponseErrorWithMsg(c, http.StatusBadRequest, response.ErrCodeInvalidParams, err.Error())
+		return
+	}
+
+	session := scopedSession(c, req.SessionID)
+	if session == nil {
+		return
+	}
+	if session.Closed {
+		logger.Errorf("session %s is closed", session.SID)
+		response.ResponseErrorWithMsg(c, http.StatusConflict, response.ErrCodeInvalidParams, "session is closed")
+		return
+	}
+
+	now := time.Now().Unix()
+	msg := req.Message.ToModel(models.MessageFromSystem, now)
+	actor := ""
+	if key := requestAPIKey(c); key != nil {
+		actor = key.Prefix
+	} else if user := currentUser(c); user != nil {
+		msg.From, msg.AgentID = models.MessageFromAgent, user.Username
+		actor = user.Username
+	} else {
+		return
+	}
+
+	msgID, err := service.GetMsgService().SaveMessage(session.VisitorID(), session.AppID(), session.SessionSeq(), &msg)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	msg.MsgID = msgID
+	if msg.From == models.MessageFromAgent {
+		session.OnAgentReply(now)
+		service.GetSessionService().SaveSession(session)
+	}
+
+	PushMessageToVisitor(session.VisitorID(), session.SID, &msg)
+	if session.CurAgentID != "" && session.CurAgentID != msg.AgentID {
+		PushMessageToAgent(session.CurAgentID, session, &msg)
+	}
+
+	logger.Infof("message sent to session %s by %s", session.SID, actor)
+	response.ResponseSuccess(c, protocol.NewMessage(&msg))
+}
@@ -4,69 +4,281 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"net/http"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"kefu-server/models"
 	"kefu-server/service"
+	"kefu-server/store"
 	"kefu-server/utils"
 	"kefu-server/utils/logger"
 	"kefu-server/utils/response"
 )
 
-type LoginRequest struct {
-	Username  string `json:"username" binding:"required"`
-	Password  string `json:"password" binding:"required"` // 2次hash后的密码
+// PasswordProof 以一次性登录挑战证明持有密码：客户端用由密码派生的私钥签名，
+// 服务端只用保存的公钥验证，请求中不含与密码等价的数据
+type PasswordProof struct {
+	Challenge string `json:"challenge" binding:"required"` // /login/challenge 下发的一次性挑战
 	Timestamp string `json:"timestamp" binding:"required"`
-	Nonce     string `json:"nonce" binding:"required"`
-	Signature string `json:"signature" binding:"required"`
+	Nonce     string `json:"nonce" binding:"required,max=64"`
+	Signature string `json:"signature" binding:"required"` // 对 passwordProofMessage 的 Ed25519 签名（hex）
+	Password  string `json:"password,omitempty"`           // 仅挑战标记 legacy 时提交密码预哈希，用于把旧哈希升级为验证器
+}
+
+type LoginChallengeRequest struct {
+	Username string `json:"username" binding:"required"`
+}
+
+// LoginChallengeResponse 一次性挑战和派生登录私钥所需的参数
+type LoginChallengeResponse struct {
+	Challenge  string `json:"challenge"`
+	Salt       string `json:"salt"` // hex
+	Iterations int    `json:"iterations"`
+	Legacy     bool   `json:"legacy"` // 账号仍是旧哈希，本次需同时提交密码预哈希
+	ExpiresIn  int    `json:"expires_in"`
+}
+
+type LoginRequest struct {
+	Username string `json:"username" binding:"required"`
+	PasswordProof
 }
 
 type LoginResponse struct {
-	Token string      `json:"token"`
-	User  models.User `json:"user"`
+	Token        string      `json:"token"`
+	RefreshToken string      `json:"refresh_token"`
+	ExpiresIn    int         `json:"expires_in"` // 访问令牌有效期（秒）
+	User         models.User `json:"user"`
+}
+
+// MFAChallengeResponse 需要两步验证时登录第一步的响应
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	MFAEnroll   bool   `json:"mfa_enroll"` // 角色要求两步验证但尚未启用，需先完成绑定
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 type UserController struct{}
 
-// hashPassword 使用SHA256 hash密码
-func hashPassword(password string) string {
-	hash := sha256.Sum256([]byte(password))
-	return hex.EncodeToString(hash[:])
+type CreateUserRequest struct {
+	Username string   `json:"username" binding:"required"`
+	Password string   `json:"password" binding:"required,max=128"` // 初始密码，首次登录必须修改
+	Avatar   string   `json:"avatar" binding:"omitempty,url,max=255"`
+	Role     string   `json:"role" binding:"required,oneof=superadmin admin supervisor agent analyst"`
+	Apps     []string `json:"apps"`
+}
+
+type UpdateUserRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"omitempty,max=128"` // 为空则不修改，设置后下次登录必须修改
+	Avatar   string `json:"avatar" binding:"omitempty,url,max=255"`
+	Role     string `json:"role" binding:"required,oneof=superadmin admin supervisor agent analyst"`
+}
+
+type SetUserActiveRequest struct {
+	Username string `json:"username" binding:"required"`
+	Active   *bool  `json:"active" binding:"required"`
 }
 
-// verifySignature 验证签名（使用密码的1次hash作为密钥）
-func verifySignature(username, passwordHash1, passwordHash2, timestamp, nonce, signature string) bool {
-	// 组合数据（使用2次hash后的密码）
-	data := username + passwordHash2 + timestamp + nonce
-	// 使用密码的1次hash作为密钥生成HMAC
-	h := hmac.New(sha256.New, []byte(passwordHash1))
-	h.Write([]byte(data))
-	expectedSignature := hex.EncodeToString(h.Sum(nil))
-	// 比较签名
-	return hmac.Equal([]byte(signature), []byte(expectedSignature))
+type SetUserAppsRequest struct {
+	Username string   `json:"username" binding:"required"`
+	Apps     []string `json:"apps" binding:"required,min=1"`
 }
 
-// verifyTimestamp 验证时间戳，防止回放攻击
+// 用户名：3-50 位字母、数字、下划线、点或中划线
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,50}$`)
+
+const (
+	loginChallengeTTL    = 2 * time.Minute   // 登录挑战有效期
+	loginTimestampWindow = 300 * time.Second // 登录时间戳有效期
+	loginTimestampSkew   = 30 * time.Second  // 允许客户端时钟超前的最大偏差
+	// nonce 保留到其时间戳失效为止：时间戳最多超前 loginTimestampSkew，之后仍可用 loginTimestampWindow
+	loginNonceTTL = loginTimestampWindow + loginTimestampSkew
+)
+
+// verifyTimestamp 验证时间戳，防止回放攻击（兼容秒和毫秒时间戳）
 func verifyTimestamp(timestampStr string) bool {
 	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
 	if err != nil {
 		return false
 	}
-	// 时间戳有效期为5分钟
-	return time.Now().Unix()-timestamp < 300
+	t := time.Unix(timestamp, 0)
+	if timestamp > 1e12 {
+		t = time.UnixMilli(timestamp)
+	}
+	now := time.Now()
+	// 拒绝超前过多的时间戳，以及超过有效期的时间戳
+	return !t.After(now.Add(loginTimestampSkew)) && now.Sub(t) < loginTimestampWindow
 }
 
-// IsAdmin 检查用户是否为管理员
-func IsAdmin(c *gin.Context) bool {
-	role, exists := c.Get("role")
-	if !exists {
+// useNonce 记录 nonce，在时间戳有效期内重复使用视为回放
+func useNonce(nonce string) (bool, error) {
+	return store.SetIfAbsent("ln:"+nonce, []byte("1"), loginNonceTTL)
+}
+
+// loginChallenge 保存在 lc:{challenge} 的挑战记录
+type loginChallenge struct {
+	Username   string `json:"username"`
+	Salt       []byte `json:"salt"`
+	Iterations int    `json:"iterations"`
+	Legacy     bool   `json:"legacy"`
+}
+
+func loginChallengeKey(challenge string) string {
+	return "lc:" + challenge
+}
+
+const decoySaltKey = "login:decoy_key"
+
+// decoySalt 不存在的用户名返回由用户名确定的伪造盐，与真实账号的响应无法区分
+func decoySalt(username string) []byte {
+	key, err := store.GetValue(decoySaltKey)
+	if err != nil {
+		store.SetIfAbsent(decoySaltKey, []byte(utils.GenerateSecureToken(32)), 0)
+		key, _ = store.GetValue(decoySaltKey)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(username))
+	return mac.Sum(nil)[:utils.VerifierSaltLen]
+}
+
+// passwordProofMessage 签名内容，绑定用户名、挑战、时间戳和 nonce（与前端一致）
+func passwordProofMessage(username string, proof *PasswordProof) []byte {
+	return []byte(strings.Join([]string{"kefu-login", username, proof.Challenge, proof.Timestamp, proof.Nonce}, "\n"))
+}
+
+// takePasswordChallenge 检查时间戳和 nonce 并消费一次性挑战，失败时直接写入错误响应
+func takePasswordChallenge(c *gin.Context, username string, proof *PasswordProof) *loginChallenge {
+	// 验证时间戳
+	if !verifyTimestamp(proof.Timestamp) {
+		logger.Errorf("invalid timestamp: %s", proof.Timestamp)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return nil
+	}
+
+	// 验证 nonce 未被使用
+	if fresh, err := useNonce(proof.Nonce); err != nil {
+		logger.Errorf("record nonce failed: %v", err)
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return nil
+	} else if !fresh {
+		logger.Errorf("nonce replayed: %s", proof.Nonce)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return nil
+	}
+
+	// 消费一次性挑战，挑战须是为同一用户名下发的
+	data, err := store.TakeValue(loginChallengeKey(proof.Challenge))
+	var challenge loginChallenge
+	if err != nil || json.Unmarshal(data, &challenge) != nil || challenge.Username != username {
+		logger.Errorf("invalid or expired login challenge for user: %s", username)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return nil
+	}
+	return &challenge
+}
+
+// verifyPasswordProof 用保存的公钥验证签名；旧哈希账号先校验预哈希，
+// 再以挑战中的盐生成验证器验证签名并保存，此后不再接受预哈希
+func verifyPasswordProof(user *models.User, challenge *loginChallenge, proof *PasswordProof) bool {
+	signature, err := hex.DecodeString(proof.Signature)
+	if err != nil {
+		return false
+	}
+	message := passwordProofMessage(user.Username, proof)
+	if !challenge.Legacy {
+		verifier := utils.ParsePasswordVerifier(user.Password)
+		return verifier != nil && verifier.VerifySignature(message, signature)
+	}
+
+	if !utils.IsLegacyPasswordHash(user.Password) || !user.CheckPassword(proof.Password) {
+		return false
+	}
+	verifier := utils.DerivePasswordVerifier(proof.Password, challenge.Salt, challenge.Iterations)
+	if !verifier.VerifySignature(message, signature) {
 		return false
 	}
-	return role == "admin"
+	if err := service.GetUserService().UpgradePassword(user, verifier.String()); err == nil {
+		logger.Infof("password hash upgraded to login verifier: %s", user.Username)
+	}
+	return true
+}
+
+// currentUser 获取当前登录用户，失败时直接写入错误响应
+func currentUser(c *gin.Context) *models.User {
+	userName, exists := c.Get("userName")
+	if !exists {
+		logger.Errorf("failed get user name")
+		response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeUnauthorized)
+		return nil
+	}
+	user, err := service.GetUserService().GetUser(userName.(string))
+	if err != nil || user == nil {
+		logger.Errorf("get current user failed: %v", err)
+		response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeUnauthorized)
+		return nil
+	}
+	return user
+}
+
+// LoginChallenge 下发一次性登录挑战及派生登录私钥所需的盐和迭代次数
+func (uc *UserController) LoginChallenge(c *gin.Context) {
+	var req LoginChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("login challenge request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	// 无论用户是否存在都下发挑战，避免枚举用户名
+	challenge := loginChallenge{Username: req.Username, Iterations: utils.VerifierIterations}
+	user, err := service.GetUserService().GetUser(req.Username)
+	if err == nil && utils.IsLegacyPasswordHash(user.Password) {
+		challenge.Salt, challenge.Legacy = utils.NewVerifierSalt(), true
+	} else if verifier := passwordVerifierOf(user); verifier != nil {
+		challenge.Salt, challenge.Iterations = verifier.Salt, verifier.Iterations
+	} else {
+		challenge.Salt = decoySalt(req.Username)
+	}
+
+	token := utils.GenerateSecureToken(16)
+	data, _ := json.Marshal(challenge)
+	if err := store.SetWithTTL(loginChallengeKey(token), data, loginChallengeTTL); err != nil {
+		logger.Errorf("save login challenge failed: %v", err)
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	response.ResponseSuccess(c, LoginChallengeResponse{
+		Challenge:  token,
+		Salt:       hex.EncodeToString(challenge.Salt),
+		Iterations: challenge.Iterations,
+		Legacy:     challenge.Legacy,
+		ExpiresIn:  int(loginChallengeTTL.Seconds()),
+	})
+}
+
+// passwordVerifierOf 用户存储的登录验证器，用户不存在时返回 nil
+func passwordVerifierOf(user *models.User) *utils.PasswordVerifier {
+	if user == nil {
+		return nil
+	}
+	return utils.ParsePasswordVerifier(user.Password)
 }
 
 func (uc *UserController) Login(c *gin.Context) {
@@ -77,10 +289,28 @@ func (uc *UserController) Login(c *gin.Context) {
 		return
 	}
 
-	// 验证时间戳
-	if !verifyTimestamp(req.Timestamp) {
-		logger.Errorf("invalid timestamp: %s", req.Timestamp)
-		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+	// 检查用户名和 IP 是否被锁定
+	ip := c.ClientIP()
+	ls := service.GetLockoutService()
+	if ls == nil {
+		logger.Errorf("lockout service not initialed")
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	remaining, err := ls.CheckLocked(req.Username, ip)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	if remaining > 0 {
+		logger.Errorf("login locked: user=%s ip=%s remaining=%s", req.Username, ip, remaining)
+		c.Header("Retry-After", strconv.Itoa(int(remaining.Seconds())+1))
+		response.ResponseError(c, http.StatusTooManyRequests, response.ErrCodeLoginLocked)
+		return
+	}
+
+	challenge := takePasswordChallenge(c, req.Username, &req.PasswordProof)
+	if challenge == nil {
 		return
 	}
 
@@ -95,42 +325,221 @@ func (uc *UserController) Login(c *gin.Context) {
 	user, err := userService.GetUser(req.Username)
 	if err != nil || user == nil {
 		logger.Errorf("user does not exist: %s", req.Username)
+		ls.RecordFailure(req.Username, ip)
+		response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeInvalidCredentials)
+		return
+	}
+
+	// 验证对挑战的签名
+	if !verifyPasswordProof(user, challenge, &req.PasswordProof) {
+		logger.Errorf("password error: %s", req.Username)
+		ls.RecordFailure(req.Username, ip)
 		response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeInvalidCredentials)
 		return
 	}
 
-	// 对数据库中的明文密码进行1次hash，得到密码的1次hash
-	passwordHash1 := hashPassword(user.Password)
+	// 已禁用的用户不能登录，在两步验证和签发令牌之前拒绝
+	if !user.Active {
+		logger.Errorf("user is disabled: %s", req.Username)
+		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+		return
+	}
+
+	// 需要两步验证时返回挑战而不是令牌，失败计数在两步验证完成后才清除
+	ms := service.GetMFAService()
+	if ms == nil {
+		logger.Errorf("mfa service not initialed")
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	if ms.IsRequired(user) {
+		mfaToken, err := ms.IssueChallenge(user.ID)
+		if err != nil {
+			response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+			return
+		}
+		logger.Infof("user password verified, mfa required: %s", req.Username)
+		response.ResponseSuccess(c, MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			MFAEnroll:   !user.TOTPEnabled,
+			ExpiresIn:   int(service.MFAChallengeTTL.Seconds()),
+		})
+		return
+	}
+	ls.RecordSuccess(req.Username)
+
+	if requirePasswordChange(c, user, nil) {
+		return
+	}
+	if resp, ok := issueLoginTokens(c, user); ok {
+		logger.Infof("user login successful: %s", req.Username)
+		response.ResponseSuccess(c, resp)
+	}
+}
+
+// issueLoginTokens 签发访问令牌和刷新令牌，失败时直接写入错误响应
+func issueLoginTokens(c *gin.Context, user *models.User) (*LoginResponse, bool) {
+	if !user.Active {
+		logger.Errorf("user is disabled: %s", user.Username)
+		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+		return nil, false
+	}
+	if !canUseWorkspace(user) {
+		logger.Errorf("workspace of user %s is disabled", user.Username)
+		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+		return nil, false
+	}
+	ts := service.GetTokenService()
+	if ts == nil {
+		logger.Errorf("token service not initialed")
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return nil, false
+	}
+	refreshToken, err := ts.IssueRefreshToken(user.ID)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return nil, false
+	}
+	token, err := utils.GenerateToken(user.ID, user.Username, user.Role, user.WorkspaceID)
+	if err != nil {
+		logger.Errorf("generate token failed: %v", err)
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return nil, false
+	}
+
+	return &LoginResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(utils.AccessTokenTTL.Seconds()),
+		User:         *user,
+	}, true
+}
 
-	// 验证签名（使用密码的1次hash作为密钥）
-	if !verifySignature(req.Username, passwordHash1, req.Password, req.Timestamp, req.Nonce, req.Signature) {
-		logger.Errorf("invalid signature for user: %s", req.Username)
+// canUseWorkspace 工作区停用后其账号无法登录，超级管理员不受影响
+func canUseWorkspace(user *models.User) bool {
+	return user.Role == models.RoleSuperAdmin || models.IsWorkspaceActive(user.WorkspaceID)
+}
+
+// RefreshToken 使用刷新令牌换取新的访问令牌（刷新令牌同时轮换）
+func (uc *UserController) RefreshToken(c *gin.Context) {
+	var req RefreshTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("refresh token request parameter error: %v", err)
 		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
 		return
 	}
 
-	// 对密码的1次hash再进行1次hash，得到密码的2次hash
-	passwordHash2 := hashPassword(passwordHash1)
+	ts := service.GetTokenService()
+	if ts == nil {
+		logger.Errorf("token service not initialed")
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
 
-	// 比较2次hash后的密码
-	if passwordHash2 != req.Password {
-		logger.Errorf("password error: %s", req.Username)
-		response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeInvalidCredentials)
+	userID, refreshToken, err := ts.RotateRefreshToken(req.RefreshToken)
+	if err != nil {
+		logger.Errorf("refresh token failed: %v", err)
+		response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeTokenInvalid)
+		return
+	}
+
+	// 用户可能已被删除或禁用
+	user, err := service.GetUserService().GetUserByID(userID)
+	if err != nil || !user.Active || !canUseWorkspace(user) {
+		logger.Errorf("refresh token for unavailable user: %d", userID)
+		ts.RevokeRefreshToken(refreshToken)
+		response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeTokenInvalid)
 		return
 	}
 
-	token, err := utils.GenerateToken(user.ID, user.Username, user.Role)
+	token, err := utils.GenerateToken(user.ID, user.Username, user.Role, user.WorkspaceID)
 	if err != nil {
 		logger.Errorf("generate token failed: %v", err)
 		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
 		return
 	}
 
-	logger.Infof("user login successful: %s", req.Username)
 	response.ResponseSuccess(c, LoginResponse{
-		Token: token,
-		User:  *user,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(utils.AccessTokenTTL.Seconds()),
+		User:         *user,
+	})
+}
+
+// ListLockouts 获取登录失败与锁定记录
+func (uc *UserController) ListLockouts(c *gin.Context) {
+	lockedOnly := c.DefaultQuery("locked", "true") == "true"
+
+	ls := service.GetLockoutService()
+	if ls == nil {
+		logger.Errorf("lockout service not initialed")
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	lockouts, err := ls.ListLockouts(lockedOnly)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	lockouts = slices.DeleteFunc(lockouts, func(l *service.Lockout) bool {
+		return !canManageLockout(c, l.Type, l.Key)
 	})
+
+	response.ResponseSuccess(c, gin.H{
+		"data":  lockouts,
+		"total": len(lockouts),
+	})
+}
+
+// canManageLockout 锁定记录是实例级的：超级管理员可管理全部，其余只能管理本工作区账号的用户名锁定
+func canManageLockout(c *gin.Context, typ, key string) bool {
+	if models.HasPermission(c.GetString("role"), models.PermWorkspaceManage) {
+		return true
+	}
+	if typ != service.LockoutTypeUser {
+		return false
+	}
+	user, err := service.GetUserService().GetUser(key)
+	return err == nil && user != nil && user.WorkspaceID == requestWorkspace(c)
+}
+
+// ClearLockout 解除用户名或 IP 的登录锁定
+func (uc *UserController) ClearLockout(c *gin.Context) {
+	typ := c.Query("type")
+	key := c.Query("key")
+	if (typ != service.LockoutTypeUser && typ != service.LockoutTypeIP) || key == "" {
+		logger.Errorf("invalid lockout type or key: %s %s", typ, key)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	if !canManageLockout(c, typ, key) {
+		logger.Errorf("lockout %s %s is not in workspace %d", typ, key, requestWorkspace(c))
+		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+		return
+	}
+
+	ls := service.GetLockoutService()
+	if ls == nil {
+		logger.Errorf("lockout service not initialed")
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	if err := ls.ClearLockout(typ, key); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	recordAudit(c, "lockout.clear", "lockout", typ+":"+key, nil, nil)
+	logger.Infof("lockout cleared: %s %s", typ, key)
+	response.ResponseSuccess(c, gin.H{"message": "clear successful"})
+}
+
+// GetJWKS 公开非对称验证公钥（JWK Set）
+func (uc *UserController) GetJWKS(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"keys": utils.PublicJWKS()})
 }
 
 func (uc *UserController) GetUserInfo(c *gin.Context) {
@@ -159,6 +568,413 @@ func (uc *UserController) GetUserInfo(c *gin.Context) {
 }
 
 func (uc *UserController) Logout(c *gin.Context) {
-	logger.Infof("user logout")
+	claims, exists := c.Get("claims")
+	if !exists {
+		logger.Errorf("failed get token claims")
+		response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeUnauthorized)
+		return
+	}
+
+	// 吊销当前令牌
+	ts := service.GetTokenService()
+	if ts == nil {
+		logger.Errorf("token service not initialed")
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	if err := ts.RevokeToken(claims.(*utils.Claims)); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	// 同时吊销刷新令牌（可选）
+	var req LogoutRequest
+	if err := c.ShouldBindJSON(&req); err == nil && req.RefreshToken != "" {
+		if err := ts.RevokeRefreshToken(req.RefreshToken); err != nil {
+			logger.Errorf("revoke refresh token failed: %v", err)
+		}
+	}
+
+	logger.Infof("user logout: %s", claims.(*utils.Claims).UserName)
 	response.ResponseSuccess(c, gin.H{"message": "logout successful"})
 }
+
+// validateApps 检查业务列表中的 app_id 是否都存在于当前工作区（"all" 表示工作区内全部业务）
+func validateApps(c *gin.Context, apps []string) bool {
+	for _, appID := range apps {
+		if appID == "all" {
+			continue
+		}
+		var count int64
+		if err := workspaceDB(c).Model(&models.App{}).Where("app_id = ?", appID).Count(&count).Error; err != nil || count == 0 {
+			logger.Errorf("app not found: %s", appID)
+			return false
+		}
+	}
+	return true
+}
+
+// isLastAdmin 将该用户改为 role（禁用或删除时为空）后，工作区或实例是否失去最后一个激活的管理员
+func isLastAdmin(us *service.UserService, user *models.User, role string) (bool, error) {
+	if !user.Active {
+		return false, nil
+	}
+	if user.Role == models.RoleSuperAdmin && role != models.RoleSuperAdmin {
+		count, err := us.CountActiveSuperAdmins()
+		if err != nil || count <= 1 {
+			return err == nil, err
+		}
+	}
+	if !models.IsAdminRole(user.Role) || models.IsAdminRole(role) {
+		return false, nil
+	}
+	count, err := us.CountActiveAdmins(user.WorkspaceID)
+	if err != nil {
+		return false, err
+	}
+	return count <= 1, nil
+}
+
+// checkUserTarget 校验目标账号在当前请求的管理范围内，失败时直接写入错误响应
+// 其他工作区的账号视为不存在；超级管理员只能由超级管理员管理；API Key 只能管理其所属业务的客服
+func checkUserTarget(c *gin.Context, user *models.User) bool {
+	if user.WorkspaceID != requestWorkspace(c) {
+		logger.Errorf("user %s is not in workspace %d", user.Username, requestWorkspace(c))
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return false
+	}
+	if user.Role == models.RoleSuperAdmin && c.GetString("role") != models.RoleSuperAdmin {
+		logger.Errorf("only super admins can manage super admin: %s", user.Username)
+		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+		return false
+	}
+	key := requestAPIKey(c)
+	if key == nil {
+		return true
+	}
+	apps, _ := user.AppScope()
+	if !apiKeyAllowsUser(key, user.Role, apps) {
+		logger.Errorf("api key %s cannot manage user: %s", key.Prefix, user.Username)
+		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+		return false
+	}
+	return true
+}
+
+// canGrantRole 只有超级管理员能授予超级管理员角色，API Key 不能授予管理员角色，失败时直接写入错误响应
+func canGrantRole(c *gin.Context, role string) bool {
+	if key := requestAPIKey(c); key != nil && models.IsAdminRole(role) {
+		logger.Errorf("api key %s cannot grant admin role", key.Prefix)
+		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+		return false
+	}
+	if role == models.RoleSuperAdmin && c.GetString("role") != models.RoleSuperAdmin {
+		logger.Errorf("only super admins can grant super admin role")
+		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+		return false
+	}
+	return true
+}
+
+// ListUsers 获取客服列表
+func (uc *UserController) ListUsers(c *gin.Context) {
+	// 解析查询参数
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 10
+	}
+	keyword := c.Query("keyword")
+	role := c.Query("role")
+	appID := c.Query("app_id")
+	// API Key 只能查看负责其所属业务的客服
+	if key := requestAPIKey(c); key != nil {
+		appID = key.AppID
+	}
+
+	var active *bool
+	if activeStr := c.Query("active"); activeStr != "" {
+		v, err := strconv.ParseBool(activeStr)
+		if err != nil {
+			logger.Errorf("invalid active filter: %s", activeStr)
+			response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+			return
+		}
+		active = &v
+	}
+
+	us := service.GetUserService()
+	users, total, err := us.ListUsers(requestWorkspace(c), page, pageSize, keyword, role, appID, active)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	logger.Infof("list users successful, page: %d, page_size: %d, total: %d", page, pageSize, total)
+	response.ResponseSuccess(c, gin.H{
+		"data":  users,
+		"total": total,
+	})
+}
+
+// CreateUser 创建客服
+func (uc *UserController) CreateUser(c *gin.Context) {
+	var req CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("create user request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	if !usernamePattern.MatchString(req.Username) {
+		logger.Errorf("invalid username: %s", req.Username)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	if !canGrantRole(c, req.Role) {
+		return
+	}
+	if err := models.GetPasswordPolicy(requestWorkspace(c)).Validate(req.Username, req.Password); err != nil {
+		responsePasswordError(c, req.Username, err)
+		return
+	}
+
+	// 缺省负责全部业务，API Key 创建的客服缺省负责其所属业务
+	key := requestAPIKey(c)
+	if len(req.Apps) == 0 {
+		req.Apps = []string{"all"}
+		if key != nil {
+			req.Apps = []string{key.AppID}
+		}
+	}
+	if key != nil && !apiKeyAllowsUser(key, req.Role, req.Apps) {
+		logger.Errorf("api key %s cannot create user outside its app: %s", key.Prefix, req.Username)
+		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+		return
+	}
+	if !validateApps(c, req.Apps) {
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	us := service.GetUserService()
+
+	// 检查用户名是否已存在（登录时不带工作区，用户名需全局唯一）
+	if existing, _ := us.GetUser(req.Username); existing != nil {
+		logger.Errorf("username already exists: %s", req.Username)
+		response.ResponseError(c, http.StatusConflict, response.ErrCodeUserExists)
+		return
+	}
+
+	if _, err := us.CreateUser(requestWorkspace(c), req.Username, req.Password, req.Avatar, req.Role, true); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	if err := us.SetUserApps(req.Username, req.Apps); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	user, err := us.GetUser(req.Username)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	recordAudit(c, "user.create", "user", user.Username, nil, user)
+	logger.Infof("create user successful: %s", user.Username)
+	response.ResponseSuccess(c, user)
+}
+
+// UpdateUser 更新客服信息
+func (uc *UserController) UpdateUser(c *gin.Context) {
+	var req UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("update user request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	us := service.GetUserService()
+	user, err := us.GetUser(req.Username)
+	if err != nil || user == nil {
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+	if !checkUserTarget(c, user) {
+		return
+	}
+	if req.Role != user.Role && !canGrantRole(c, req.Role) {
+		return
+	}
+
+	// 不允许将最后一个管理员降级
+	last, err := isLastAdmin(us, user, req.Role)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	if last {
+		logger.Errorf("cannot demote the last admin: %s", req.Username)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeLastAdmin)
+		return
+	}
+
+	if req.Password != "" {
+		if err := models.GetPasswordPolicy(requestWorkspace(c)).Validate(req.Username, req.Password); err != nil {
+			responsePasswordError(c, req.Username, err)
+			return
+		}
+	}
+
+	before := *user
+	user, err = us.UpdateUser(req.Username, "", req.Avatar, req.Role, user.Active)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	if req.Password != "" {
+		// 管理员设置的密码为临时密码，用户下次登录必须修改
+		if err := us.ChangePassword(user, req.Password, true); err != nil {
+			response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+			return
+		}
+		// 密码哈希不出现在快照中，单独标记密码已修改
+		recordAudit(c, "user.password_reset", "user", req.Username, nil, nil)
+	}
+	after := *user
+	recordAudit(c, "user.update", "user", req.Username, before, after)
+
+	logger.Infof("update user successful: %s", req.Username)
+	response.ResponseSuccess(c, user)
+}
+
+// SetUserActive 启用或禁用客服
+func (uc *UserController) SetUserActive(c *gin.Context) {
+	var req SetUserActiveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("set user active request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	us := service.GetUserService()
+	user, err := us.GetUser(req.Username)
+	if err != nil || user == nil {
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+	if !checkUserTarget(c, user) {
+		return
+	}
+
+	// 不允许禁用最后一个管理员
+	if !*req.Active {
+		last, err := isLastAdmin(us, user, "")
+		if err != nil {
+			response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+			return
+		}
+		if last {
+			logger.Errorf("cannot disable the last admin: %s", req.Username)
+			response.ResponseError(c, http.StatusBadRequest, response.ErrCodeLastAdmin)
+			return
+		}
+	}
+
+	if err := us.SetUserActive(req.Username, *req.Active); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	recordAudit(c, "user.active", "user", req.Username, gin.H{"active": user.Active}, gin.H{"active": *req.Active})
+
+	logger.Infof("set user active successful: %s, active: %t", req.Username, *req.Active)
+	response.ResponseSuccess(c, gin.H{"message": "update successful"})
+}
+
+// DeleteUser 删除客服
+func (uc *UserController) DeleteUser(c *gin.Context) {
+	username := c.Query("username")
+	if username == "" {
+		logger.Errorf("username is required")
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	us := service.GetUserService()
+	user, err := us.GetUser(username)
+	if err != nil || user == nil {
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+	if !checkUserTarget(c, user) {
+		return
+	}
+
+	// 不允许删除最后一个管理员
+	last, err := isLastAdmin(us, user, "")
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	if last {
+		logger.Errorf("cannot delete the last admin: %s", username)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeLastAdmin)
+		return
+	}
+
+	if err := us.DeleteUser(username); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	recordAudit(c, "user.delete", "user", username, user, nil)
+
+	logger.Infof("delete user successful: %s", username)
+	response.ResponseSuccess(c, gin.H{"message": "delete successful"})
+}
+
+// SetUserApps 分配客服负责的业务
+func (uc *UserController) SetUserApps(c *gin.Context) {
+	var req SetUserAppsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("set user apps request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	if !validateApps(c, req.Apps) {
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	us := service.GetUserService()
+	before, err := us.GetUser(req.Username)
+	if err != nil || before == nil {
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	} else if !checkUserTarget(c, before) {
+		return
+	}
+	if key := requestAPIKey(c); key != nil && !apiKeyAllowsUser(key, "", req.Apps) {
+		logger.Errorf("api key %s cannot assign apps: %v", key.Prefix, req.Apps)
+		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+		return
+	}
+
+	if err := us.SetUserApps(req.Username, req.Apps); err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	user, err := us.GetUser(req.Username)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	recordAudit(c, "user.apps", "user", req.Username, gin.H{"apps": before.Apps}, gin.H{"apps": user.Apps})
+	logger.Infof("set user apps successful: %s", req.Username)
+	response.ResponseSuccess(c, user)
+}
@@ -2,8 +2,10 @@ package controllers
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"sync"
 	"time"
 
@@ -11,20 +13,19 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"kefu-server/models"
+	"kefu-server/protocol"
 	"kefu-server/service"
+	"kefu-server/store"
+	"kefu-server/utils"
 	"kefu-server/utils/logger"
-)
-
-const (
-	MessageTypeReq    = "message.req"
-	MessageTypeTyping = "message.typing"
-	MessageTypeClose  = "message.close"
+	"kefu-server/utils/response"
 )
 
 // VisitorConn 封装访客连接
 type VisitorConn struct {
 	Conn      *websocket.Conn
 	SessionID string
+	Version   int // 连接时协商的协议版本
 	SendChan  chan []byte
 	Done      chan struct{}
 }
@@ -35,6 +36,14 @@ var (
 	visitorMu    sync.RWMutex
 )
 
+// 排队会话分配时串行执行，避免访客留言、重连和客服上线同时分配同一会话
+var routeMu sync.Mutex
+
+const (
+	queuedMessageLimit = 100                 // 分配客服时最多补推的排队留言数
+	outOfHoursNoOpen   = 14 * 24 * time.Hour // 近期没有营业时段时，非营业时段按此时长计，到期前不重复自动回复
+)
+
 // 注册连接
 func registerVisitorConn(sessionID string, conn *VisitorConn) {
 	visitorMu.Lock()
@@ -51,39 +60,39 @@ func unregisterVisitorConn(sessionID string) {
 
 // 推送消息给访客（供客服系统调用）
 func PushMessageToVisitor(visitorID, sessionID string, msg *models.Message) error {
+	pushEventToVisitor(sessionID, protocol.TypeMessageRsp, protocol.NewMessage(msg))
+	return nil
+}
+
+// pushEventToVisitor 向访客推送 {type, payload}，payload 为 nil 时省略
+func pushEventToVisitor(sessionID, msgType string, payload any) {
 	visitorMu.RLock()
 	conn, ok := visitorConns[sessionID]
 	visitorMu.RUnlock()
 
 	if !ok || conn == nil {
 		logger.Errorf("Visitor %s not found", sessionID)
-		return nil // 访客不在线，静默丢弃（或可存离线消息）
+		return // 访客不在线，静默丢弃（或可存离线消息）
 	}
 
-	payload, _ := json.Marshal(struct {
-		Type    string `json:"type"`
-		Payload string `json:"payload"`
-	}{
-		Type:    msg.MsgType,
-		Payload: msg.Content,
-	})
+	sendToVisitor(conn, protocol.Encode(msgType, "", payload))
+}
 
+// sendToVisitor 向访客连接投递已编码的帧
+func sendToVisitor(conn *VisitorConn, frame []byte) {
 	select {
-	case conn.SendChan <- payload:
+	case conn.SendChan <- frame:
 	default:
-		logger.Warnf("Visitor %s send buffer full", sessionID)
+		logger.Warnf("Visitor %s send buffer full", conn.SessionID)
 	}
-	return nil
 }
 
 type VisitorController struct{}
 
 func (vc *VisitorController) WSHandler(c *gin.Context) {
-	visitorID := c.Query("visitor_id")
-	appID := c.Query("app_id")
-
-	if visitorID == "" || appID == "" {
-		logger.Errorf("Visitor ID or App ID not found")
+	appID := protocol.AppID(c.Request)
+	if appID == "" {
+		logger.Errorf("App ID not found")
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
@@ -94,6 +103,25 @@ func (vc *VisitorController) WSHandler(c *gin.Context) {
 		return
 	}
 
+	visitor, err := verifyVisitor(appID, visitorCredentials{
+		VisitorID:     c.Query("visitor_id"),
+		VisitorToken:  c.Query("visitor_token"),
+		UserHash:      c.Query("user_hash"),
+		IdentityToken: c.Query("identity_token"),
+	})
+	if err != nil {
+		logger.Errorf("Visitor identity rejected %s: %v", appID, err)
+		c.AbortWithStatus(identityErrorStatus(err))
+		return
+	}
+
+	version, perr := protocol.Negotiate(c.Request)
+	if perr != nil {
+		logger.Errorf("Visitor protocol negotiation failed %s: %v", appID, perr)
+		c.AbortWithStatusJSON(http.StatusBadRequest, perr)
+		return
+	}
+
 	ss := service.GetSessionService()
 	if ss == nil {
 		logger.Errorf("Session service not initialized")
@@ -101,15 +129,19 @@ func (vc *VisitorController) WSHandler(c *gin.Context) {
 		return
 	}
 
-	session, err := ss.GetOrCreateSession(visitorID, appID)
+	session, err := ss.GetOrCreateSession(visitor.ID, appID)
 	if err != nil {
 		logger.Errorf("Failed to get session: %v", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	if session.SetIdentity(visitor.Identity) {
+		ss.SaveSession(session)
+	}
 
 	conn, err := websocket.Accept(c.Writer, c.Request, &websocket.AcceptOptions{
 		InsecureSkipVerify: true,
+		Subprotocols:       []string{protocol.Subprotocol(version)},
 	})
 	if err != nil {
 		logger.Errorf("Failed to accept websocket connection: %v", err)
@@ -117,11 +149,13 @@ func (vc *VisitorController) WSHandler(c *gin.Context) {
 		return
 	}
 	defer conn.CloseNow()
+	conn.SetReadLimit(protocol.MaxFrameSize)
 
 	// 创建连接对象
 	visitorConn := &VisitorConn{
 		Conn:      conn,
 		SessionID: session.SID,
+		Version:   version,
 		SendChan:  make(chan []byte, 128),
 		Done:      make(chan struct{}),
 	}
@@ -130,6 +164,28 @@ func (vc *VisitorController) WSHandler(c *gin.Context) {
 	registerVisitorConn(session.SID, visitorConn)
 	defer unregisterVisitorConn(session.SID)
 
+	sendToVisitor(visitorConn, protocol.Encode(protocol.TypeHello, "", protocol.Hello{
+		Version:   version,
+		SessionID: session.SID,
+		VisitorID: visitor.ID,
+	}))
+
+	// 访客令牌剩余有效期不足一半时随连接下发新令牌，访客无需重新申请
+	if !visitor.TokenExpiresAt.IsZero() && time.Until(visitor.TokenExpiresAt) < utils.VisitorTokenTTL/2 {
+		if token, expiresAt, err := utils.GenerateVisitorToken(visitor.ID, appID); err == nil {
+			pushEventToVisitor(session.SID, protocol.TypeVisitorToken, protocol.VisitorToken{
+				VisitorID:    visitor.ID,
+				VisitorToken: token,
+				ExpiresAt:    expiresAt.Unix(),
+			})
+		} else {
+			logger.Errorf("Failed to renew visitor token: %v", err)
+		}
+	}
+
+	// 非营业时间留言的访客在营业后重新连接时分配客服
+	routeQueuedSession(session.SID)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -155,15 +211,23 @@ func (vc *VisitorController) readLoop(ctx context.Context, vconn *VisitorConn) {
 			return
 		}
 
-		var req struct {
-			Type    string          `json:"type"`
-			Payload json.RawMessage `json:"payload"`
-		}
-		if err := json.Unmarshal(data, &req); err != nil {
+		frame, perr := protocol.Decode(protocol.EndpointChat, data)
+		if perr != nil {
+			logger.Warnf("Visitor %s frame rejected: %v", vconn.SessionID, perr)
+			sendToVisitor(vconn, protocol.Encode(protocol.TypeError, "", perr))
 			continue
 		}
 
-		vc.handleMessage(vconn.SessionID, req.Type, string(req.Payload))
+		switch frame.Type {
+		case protocol.TypeMessageReq:
+			vc.handleMessage(vconn, frame)
+		case protocol.TypePreChatSubmit:
+			vc.handlePreChat(vconn, frame)
+		case protocol.TypeTyping:
+			vc.handleTyping(vconn)
+		case protocol.TypeSessionClose:
+			vc.handleClose(vconn)
+		}
 	}
 }
 
@@ -191,17 +255,29 @@ func (vc *VisitorController) writeLoop(ctx context.Context, vconn *VisitorConn)
 	}
 }
 
-func (vc *VisitorController) handleMessage(sessionID, msgType, content string) {
+// visitorSession 访客连接对应的会话，不存在时向访客推送错误帧并返回 nil
+func visitorSession(vconn *VisitorConn, ref string) *models.Session {
 	ss := service.GetSessionService()
 	if ss == nil { // 单例
 		logger.Errorf("Session service not initialized")
-		return
+		sendToVisitor(vconn, protocol.Encode(protocol.TypeError, "", protocol.NewError(protocol.ErrCodeInternal, ref, "session service unavailable")))
+		return nil
 	}
-	session, err := ss.GetSession(sessionID)
+	session, err := ss.GetSession(vconn.SessionID)
 	if err != nil || session == nil {
-		logger.Errorf("Session %s does not exist", sessionID)
+		logger.Errorf("Session %s does not exist", vconn.SessionID)
+		sendToVisitor(vconn, protocol.Encode(protocol.TypeError, "", protocol.NewError(protocol.ErrCodeSessionNotFound, ref, "session not found")))
+		return nil
+	}
+	return session
+}
+
+func (vc *VisitorController) handleMessage(vconn *VisitorConn, frame *protocol.Frame) {
+	session := visitorSession(vconn, frame.ID)
+	if session == nil {
 		return
 	}
+	ss := service.GetSessionService()
 
 	now := time.Now().Unix()
 	session.OnVisitorMessage(now)
@@ -212,29 +288,351 @@ func (vc *VisitorController) handleMessage(sessionID, msgType, content string) {
 		return
 	}
 
-	msg := models.Message{Content: content, MsgType: msgType, Timestamp: now}
+	msg := frame.Payload.(*protocol.MessagePayload).ToModel(models.MessageFromVisitor, now)
 	msgID, err := ms.SaveMessage(session.VisitorID(), session.AppID(), session.SessionSeq(), &msg)
 	if err != nil {
 		logger.Errorf("Failed to save message: %v", err)
+		sendToVisitor(vconn, protocol.Encode(protocol.TypeError, "", protocol.NewError(protocol.ErrCodeInternal, frame.ID, "failed to save message")))
 		return
 	}
 	msg.MsgID = msgID
 
 	ss.SaveSession(session)
 
-	// 自动分配客服
-	if session.CurAgentID == "" {
-		us := service.GetUserService()
-		if agent, _ := us.FindAgent(session.AppID()); agent != nil {
-			session.AssignAgent(agent.Username, now)
-			ss.SaveSession(session)
+	// 已分配客服时直接推送给客服
+	if session.CurAgentID != "" {
+		PushMessageToAgent(session.AgentID(), session, &msg)
+		return
+	}
+
+	// 未提交咨询前表单或非营业时间不分配客服，每个非营业时段只自动回复一次
+	if app := models.GetApp(session.AppID()); app != nil {
+		if app.PreChatForm.Pending(session) {
+			pushEventToVisitor(session.SID, protocol.TypePreChatRequired, nil)
+			return
 		}
+		if !app.BusinessHours.IsOpen(time.Now()) {
+			vc.replyOutOfHours(session, app)
+			return
+		}
+	}
+
+	// 与 routeQueuedSession 互斥，加锁后重新读取会话：其间已被分配时直接推送本条消息
+	routeMu.Lock()
+	defer routeMu.Unlock()
+	latest, err := ss.GetSession(session.SID)
+	if err != nil || latest == nil || latest.Closed {
+		return
+	}
+	if latest.CurAgentID != "" {
+		PushMessageToAgent(latest.AgentID(), latest, &msg)
+		return
+	}
+	assignSession(latest)
+}
+
+// assignSession 自动分配客服，并把分配前排队的访客留言（含本条）一并推送给客服
+func assignSession(session *models.Session) {
+	agent, _ := service.GetUserService().FindAgent(session.AppID())
+	if agent == nil {
+		return
+	}
+	session.AssignAgent(agent.Username, time.Now().Unix())
+	if err := service.GetSessionService().SaveSession(session); err != nil {
+		return
+	}
+
+	msgs, err := service.GetMsgService().GetMessagesBySession(session.SID, "", queuedMessageLimit)
+	if err != nil {
+		logger.Errorf("Failed to load queued messages of session %s: %v", session.SID, err)
+		return
 	}
+	for _, msg := range msgs {
+		PushMessageToAgent(session.AgentID(), session, msg)
+	}
+}
+
+// routeQueuedSession 营业时间内为排队中的会话分配客服，
+// 用于访客在非营业时间留言后重新连接、或客服上线时补分配
+func routeQueuedSession(sessionID string) {
+	routeMu.Lock()
+	defer routeMu.Unlock()
 
-	// 在分配客服后，推送消息给客服
+	// 加锁后重新读取，避免同一会话被重复分配
+	session, err := service.GetSessionService().GetSession(sessionID)
+	if err != nil || session == nil || session.Closed || session.CurAgentID != "" || session.LastVisitorMsgTime == 0 {
+		return
+	}
+	app := models.GetApp(session.AppID())
+	if app == nil || app.PreChatForm.Pending(session) || !app.BusinessHours.IsOpen(time.Now()) {
+		return
+	}
+	assignSession(session)
+}
+
+// routeQueuedSessions 客服上线后为其负责业务中的排队会话分配客服
+func routeQueuedSessions(agentID string, status int) {
+	if status != models.UserStatusOnline {
+		return
+	}
+	agent, err := service.GetUserService().GetUser(agentID)
+	if err != nil {
+		return
+	}
+	var apps []string
+	if err := store.DB.Model(&models.App{}).Scopes(models.InWorkspace(agent.WorkspaceID)).Pluck("app_id", &apps).Error; err != nil {
+		logger.Errorf("Failed to list apps of agent %s: %v", agentID, err)
+		return
+	}
+	apps = slices.DeleteFunc(apps, func(appID string) bool { return !agent.ServesApp(appID) })
+	if len(apps) == 0 {
+		return
+	}
+	sessions, _, err := service.GetSessionService().ListSessions(service.SessionFilter{
+		AppIDs: apps,
+		Status: models.SessionStatusUnAssigned,
+	})
+	if err != nil {
+		return
+	}
+	for _, session := range sessions {
+		routeQueuedSession(session.SID)
+	}
+}
+
+// submitPreChat 校验咨询前表单并保存到会话
+func submitPreChat(session *models.Session, values map[string]string) error {
+	app := models.GetApp(session.AppID())
+	if app == nil {
+		return fmt.Errorf("app not found or disabled")
+	}
+	result, err := app.PreChatForm.Check(values)
+	if err != nil {
+		return err
+	}
+	session.PreChat = result
+	return service.GetSessionService().SaveSession(session)
+}
+
+// handlePreChat 访客通过 WebSocket 提交咨询前表单
+func (vc *VisitorController) handlePreChat(vconn *VisitorConn, frame *protocol.Frame) {
+	session := visitorSession(vconn, frame.ID)
+	if session == nil {
+		return
+	}
+
+	if err := submitPreChat(session, *frame.Payload.(*protocol.PreChatPayload)); err != nil {
+		logger.Errorf("Session %s pre-chat form rejected: %v", vconn.SessionID, err)
+		sendToVisitor(vconn, protocol.Encode(protocol.TypeError, "", protocol.NewError(protocol.ErrCodePreChatInvalid, frame.ID, "%v", err)))
+		return
+	}
+	sendToVisitor(vconn, protocol.Encode(protocol.TypePreChatAck, "", session.PreChat))
+}
+
+// handleTyping 访客正在输入，转告已分配的客服
+func (vc *VisitorController) handleTyping(vconn *VisitorConn) {
+	session, err := service.GetSessionService().GetSession(vconn.SessionID)
+	if err != nil || session == nil || session.CurAgentID == "" {
+		return
+	}
+	pushEventToAgent(session.CurAgentID, protocol.TypeTyping, session.SID, protocol.Typing{From: models.MessageFromVisitor})
+}
+
+// handleClose 访客结束会话，通知已分配的客服
+func (vc *VisitorController) handleClose(vconn *VisitorConn) {
+	session := visitorSession(vconn, "")
+	if session == nil {
+		return
+	}
+	session.Close()
+	service.GetSessionService().SaveSession(session)
+
+	update := protocol.SessionUpdate{Status: session.Status(), AgentID: session.CurAgentID}
+	sendToVisitor(vconn, protocol.Encode(protocol.TypeSessionUpdate, "", update))
 	if session.CurAgentID != "" {
-		PushMessageToAgent(session.AgentID(), session.SID, &msg)
+		pushEventToAgent(session.CurAgentID, protocol.TypeSessionUpdate, session.SID, update)
+	}
+}
+
+type PreChatRequest struct {
+	AppID         string            `json:"appid" binding:"required"`
+	VisitorToken  string            `json:"visitor_token"`               // 匿名访客的令牌
+	VisitorID     string            `json:"visitor_id" binding:"max=64"` // 与 user_hash 一起使用
+	UserHash      string            `json:"user_hash"`
+	IdentityToken string            `json:"identity_token"`
+	Fields        map[string]string `json:"fields" binding:"max=20"`
+}
+
+// VisitorTokenRequest 申请或续期匿名访客令牌
+type VisitorTokenRequest struct {
+	AppID        string `json:"appid" binding:"required"`
+	VisitorToken string `json:"visitor_token"` // 续期时传入当前令牌，过期后在续期窗口内仍有效
+}
+
+// SubmitPreChat 访客在连接 /ws/chat 之前通过 REST 提交咨询前表单
+func (vc *VisitorController) SubmitPreChat(c *gin.Context) {
+	var req PreChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("pre-chat request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	if !vc.isValidOrigin(req.AppID, c.GetHeader("Origin"), c.GetHeader("Referer")) {
+		logger.Errorf("Origin not allowed %s", req.AppID)
+		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+		return
+	}
+	visitor, err := verifyVisitor(req.AppID, visitorCredentials{
+		VisitorID:     req.VisitorID,
+		VisitorToken:  req.VisitorToken,
+		UserHash:      req.UserHash,
+		IdentityToken: req.IdentityToken,
+	})
+	if err != nil {
+		logger.Errorf("Visitor identity rejected %s: %v", req.AppID, err)
+		response.ResponseErrorWithMsg(c, identityErrorStatus(err), response.ErrCodeVisitorIdentity, err.Error())
+		return
+	}
+	ss := service.GetSessionService()
+	if ss == nil {
+		logger.Errorf("Session service not initialized")
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	session, err := ss.GetOrCreateSession(visitor.ID, req.AppID)
+	if err != nil {
+		logger.Errorf("Failed to get session: %v", err)
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	session.SetIdentity(visitor.Identity) // 随表单一起保存
+	if err := submitPreChat(session, req.Fields); err != nil {
+		logger.Errorf("Session %s pre-chat form rejected: %v", session.SID, err)
+		response.ResponseErrorWithMsg(c, http.StatusBadRequest, response.ErrCodeInvalidParams, err.Error())
+		return
+	}
+	response.ResponseSuccess(c, gin.H{"pre_chat": session.PreChat})
+}
+
+// replyOutOfHours 向访客发送非营业时间自动回复，以下次营业开始时间区分非营业时段，每个时段只回复一次
+func (vc *VisitorController) replyOutOfHours(session *models.Session, app *models.App) {
+	now := time.Now()
+	next := app.BusinessHours.NextOpen(now)
+	var replied bool
+	if next.IsZero() {
+		// 近期没有营业时段：以回复后 outOfHoursNoOpen 为时段结束，到期前不重复回复
+		replied = session.OutOfHoursUntil > now.Unix()
+		next = now.Add(outOfHoursNoOpen)
+	} else {
+		replied = session.OutOfHoursUntil == next.Unix()
+	}
+	if replied || app.BusinessHours.OutOfHoursMsg == "" {
+		return
+	}
+	until := next.Unix()
+
+	msg := models.Message{
+		From:      models.MessageFromSystem,
+		MsgType:   models.MsgTypeText,
+		Content:   app.BusinessHours.OutOfHoursMsg,
+		Timestamp: now.Unix(),
+	}
+	if _, err := service.GetMsgService().SaveMessage(session.VisitorID(), session.AppID(), session.SessionSeq(), &msg); err != nil {
+		logger.Errorf("Failed to save out of hours reply: %v", err)
+		return
+	}
+	session.OutOfHoursUntil = until
+	service.GetSessionService().SaveSession(session)
+
+	PushMessageToVisitor(session.VisitorID(), session.SID, &msg)
+}
+
+// IssueToken 为首次访问的匿名访客签发令牌，或为已有令牌续期（保留原 visitor_id）
+func (vc *VisitorController) IssueToken(c *gin.Context) {
+	var req VisitorTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("visitor token request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+	if !vc.isValidOrigin(req.AppID, c.GetHeader("Origin"), c.GetHeader("Referer")) {
+		logger.Errorf("Origin not allowed %s", req.AppID)
+		response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+		return
+	}
+	// 开启身份验证的业务不接受匿名访客
+	if app := models.GetApp(req.AppID); app == nil || app.IdentityRequired {
+		response.ResponseErrorWithMsg(c, http.StatusUnauthorized, response.ErrCodeVisitorIdentity, models.ErrIdentityRequired.Error())
+		return
+	}
+
+	// 令牌无效或超过续期窗口时视为新访客
+	visitorID, renewed := models.GenVisitorID(), false
+	if req.VisitorToken != "" {
+		if claims, err := utils.ParseVisitorToken(req.VisitorToken, utils.VisitorTokenRenewWindow); err == nil && claims.AppID == req.AppID {
+			visitorID, renewed = claims.Subject, true
+		} else {
+			logger.Warnf("visitor token not renewable for app %s: %v", req.AppID, err)
+		}
+	}
+
+	token, expiresAt, err := utils.GenerateVisitorToken(visitorID, req.AppID)
+	if err != nil {
+		logger.Errorf("Failed to generate visitor token: %v", err)
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	response.ResponseSuccess(c, gin.H{
+		"visitor_id":    visitorID,
+		"visitor_token": token,
+		"expires_at":    expiresAt.Unix(),
+		"renewed":       renewed,
+	})
+}
+
+// visitorCredentials 访客携带的凭证：匿名访客使用 visitor_token，已登录用户使用接入方签名
+type visitorCredentials struct {
+	VisitorID     string
+	VisitorToken  string
+	UserHash      string
+	IdentityToken string
+}
+
+// verifiedVisitor 校验通过的访客
+type verifiedVisitor struct {
+	ID             string
+	Identity       *models.VisitorIdentity // 接入方签名的身份，匿名访客为 nil
+	TokenExpiresAt time.Time               // 匿名访客令牌的过期时间
+}
+
+// verifyVisitor 按业务的身份验证设置校验访客凭证，不接受未签名的 visitor_id
+func verifyVisitor(appID string, cred visitorCredentials) (*verifiedVisitor, error) {
+	app := models.GetApp(appID)
+	if app == nil {
+		return nil, fmt.Errorf("app not found or disabled")
+	}
+	if cred.UserHash == "" && cred.IdentityToken == "" && !app.IdentityRequired {
+		claims, err := utils.ParseVisitorToken(cred.VisitorToken, 0)
+		if err != nil || claims.AppID != appID {
+			return nil, models.ErrVisitorToken
+		}
+		return &verifiedVisitor{ID: claims.Subject, TokenExpiresAt: claims.ExpiresAt.Time}, nil
+	}
+
+	visitorID, identity, err := app.VerifyVisitor(cred.VisitorID, cred.UserHash, cred.IdentityToken)
+	if err != nil {
+		return nil, err
+	}
+	return &verifiedVisitor{ID: visitorID, Identity: identity}, nil
+}
+
+// identityErrorStatus 非法的 visitor_id 属于参数错误，其余为身份未通过验证
+func identityErrorStatus(err error) int {
+	if errors.Is(err, models.ErrInvalidVisitorID) {
+		return http.StatusBadRequest
 	}
+	return http.StatusUnauthorized
 }
 
 func (vc *VisitorController) isValidOrigin(appID, origin, referer string) bool {
@@ -242,5 +640,5 @@ func (vc *VisitorController) isValidOrigin(appID, origin, referer string) bool {
 	if app == nil {
 		return false
 	}
-	return models.IsDomainAllowed(origin, referer, app.AllowDomain)
+	return app.IsOriginAllowed(origin, referer)
 }
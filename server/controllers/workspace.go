@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"kefu-server/models"
+	"kefu-server/service"
+	"kefu-server/store"
+	"kefu-server/utils/logger"
+	"kefu-server/utils/response"
+)
+
+type WorkspaceController struct{}
+
+type CreateWorkspaceRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+	Slug string `json:"slug" binding:"required"`
+}
+
+type UpdateWorkspaceRequest struct {
+	ID     uint   `json:"id" binding:"required"`
+	Name   string `json:"name" binding:"required,max=100"`
+	Active *bool  `json:"active" binding:"required"`
+}
+
+// requestWorkspace 当前请求所在的工作区（由 auth 中间件注入）
+func requestWorkspace(c *gin.Context) uint {
+	if workspaceID := c.GetUint("workspaceID"); workspaceID != 0 {
+		return workspaceID
+	}
+	return models.DefaultWorkspaceID
+}
+
+// workspaceDB 限定在当前请求工作区内的查询
+func workspaceDB(c *gin.Context) *gorm.DB {
+	return store.DB.Scopes(models.InWorkspace(requestWorkspace(c)))
+}
+
+// ListWorkspaces 获取工作区列表
+func (wc *WorkspaceController) ListWorkspaces(c *gin.Context) {
+	list, err := service.GetWorkspaceService().ListWorkspaces()
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+	response.ResponseSuccess(c, gin.H{
+		"data":  list,
+		"total": len(list),
+	})
+}
+
+// CreateWorkspace 创建工作区，之后以 X-Workspace-ID 进入该工作区创建管理员和业务
+func (wc *WorkspaceController) CreateWorkspace(c *gin.Context) {
+	var req CreateWorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil || !models.IsValidWorkspaceSlug(req.Slug) {
+		logger.Errorf("create workspace request parameter error: %v, slug: %s", err, req.Slug)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	var count int64
+	if err := store.DB.Model(&models.Workspace{}).Where("slug = ?", req.Slug).Count(&count).Error; err != nil || count > 0 {
+		logger.Errorf("workspace slug already exists: %s", req.Slug)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	workspace, err := service.GetWorkspaceService().CreateWorkspace(req.Name, req.Slug)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	recordAudit(c, "workspace.create", "workspace", strconv.FormatUint(uint64(workspace.ID), 10), nil, workspace)
+	logger.Infof("create workspace successful: %s", workspace.Slug)
+	response.ResponseSuccess(c, workspace)
+}
+
+// UpdateWorkspace 修改工作区名称或启停状态，停用后其客服立即下线，访客无法接入
+func (wc *WorkspaceController) UpdateWorkspace(c *gin.Context) {
+	var req UpdateWorkspaceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Errorf("update workspace request parameter error: %v", err)
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	ws := service.GetWorkspaceService()
+	before := ws.GetWorkspace(req.ID)
+	if before == nil {
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+	workspace, err := ws.UpdateWorkspace(req.ID, req.Name, *req.Active)
+	if err != nil {
+		response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		return
+	}
+
+	recordAudit(c, "workspace.update", "workspace", strconv.FormatUint(uint64(req.ID), 10), before, workspace)
+	logger.Infof("update workspace successful: %d", req.ID)
+	response.ResponseSuccess(c, workspace)
+}
+
+// DeleteWorkspace 删除工作区，需先归档清除其业务并删除其账号
+func (wc *WorkspaceController) DeleteWorkspace(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Query("id"), 10, 64)
+	if err != nil {
+		logger.Errorf("invalid workspace id: %s", c.Query("id"))
+		response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		return
+	}
+
+	ws := service.GetWorkspaceService()
+	workspace := ws.GetWorkspace(uint(id))
+	if workspace == nil {
+		response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+		return
+	}
+	if err := ws.DeleteWorkspace(uint(id)); err != nil {
+		switch {
+		case errors.Is(err, service.ErrWorkspaceNotEmpty):
+			response.ResponseError(c, http.StatusConflict, response.ErrCodeWorkspaceNotEmpty)
+		case errors.Is(err, service.ErrDefaultWorkspace):
+			response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+		default:
+			response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+		}
+		return
+	}
+
+	recordAudit(c, "workspace.delete", "workspace", c.Query("id"), workspace, nil)
+	logger.Infof("delete workspace successful: %s", workspace.Slug)
+	response.ResponseSuccess(c, gin.H{"message": "delete successful"})
+}
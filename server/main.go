@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	_ "time/tzdata" // 内置时区数据，业务营业时间不依赖系统时区库
 
 	"kefu-server/config"
 	"kefu-server/models"
 	"kefu-server/router"
+	"kefu-server/service"
 	"kefu-server/store"
+	"kefu-server/utils"
 	"kefu-server/utils/logger"
 )
 
@@ -28,6 +32,12 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// 令牌配置与签名密钥
+	if err := utils.InitJWT(cfg.Auth); err != nil {
+		logger.Errorf("jwt initialization failed: %v", err)
+		log.Fatal(err)
+	}
+
 	// 初始化数据库
 	db, err := store.InitDB(cfg.Admin.Database)
 	if err != nil {
@@ -36,17 +46,52 @@ func main() {
 	}
 
 	// 数据库迁移
-	if err := db.AutoMigrate(&models.User{}, &models.App{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.App{}, &models.Setting{}, &models.APIKey{}, &models.AuditLog{}, &models.AppTemplate{}, &models.Workspace{}); err != nil {
 		logger.Errorf("database migration failed: %v", err)
 		log.Fatal(err)
 	}
 
+	// 升级前的数据归入缺省工作区
+	if err := models.MigrateWorkspaces(db); err != nil {
+		logger.Errorf("failed to migrate workspaces: %v", err)
+		log.Fatal(err)
+	}
+
+	// 迁移历史明文密码
+	if err := models.MigratePasswords(db); err != nil {
+		logger.Errorf("failed to migrate passwords: %v", err)
+		log.Fatal(err)
+	}
+
 	// 创建默认用户
 	if err := models.CreateDefaultUsers(db); err != nil {
 		logger.Errorf("failed to create default users: %v", err)
 		log.Fatal(err)
 	}
 
+	// 重启后没有任何客服连接，在线状态全部重置为离线
+	if err := service.GetPresenceService().ResetAll(); err != nil {
+		log.Fatal(err)
+	}
+
+	// 初始化 KV 存储
+	kv, err := store.InitStore(cfg.Admin.Store)
+	if err != nil {
+		logger.Errorf("kv store initialization failed: %v", err)
+		log.Fatal(err)
+	}
+	defer kv.Close()
+
+	// 继续服务重启前未完成的保留策略任务
+	if err := service.GetRetentionService().Resume(); err != nil {
+		logger.Errorf("resume retention jobs failed: %v", err)
+	}
+
+	// 定期清理过期审计日志
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service.GetAuditService().StartRetentionJob(ctx)
+
 	// 设置路由
 	r := router.SetupRouter()
 
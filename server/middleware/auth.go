@@ -3,18 +3,46 @@ package middleware
 
 import (
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 
+	"kefu-server/models"
+	"kefu-server/service"
 	"kefu-server/utils"
 	"kefu-server/utils/logger"
 	"kefu-server/utils/response"
 )
 
-// AuthMiddleware returns gin.HandlerFunc
+// AuthMiddleware returns gin.HandlerFunc. Requests are authenticated either by
+// a staff access token (Authorization: Bearer) or by a per-app API key
+// (X-Api-Key); API key requests carry "apiKey" instead of user information.
+// Both set "workspaceID": the user's workspace, or the workspace of the
+// key's app.
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if rawKey := c.GetHeader("X-Api-Key"); rawKey != "" {
+			key, err := service.GetAPIKeyService().Authenticate(rawKey, c.ClientIP())
+			if err != nil {
+				logger.Errorf("api key rejected: %v", err)
+				response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeTokenInvalid)
+				c.Abort()
+				return
+			}
+			workspaceID, ok := models.GetAppWorkspace(key.AppID)
+			if !ok || !models.IsWorkspaceActive(workspaceID) {
+				logger.Errorf("api key %s app unavailable: %s", key.Prefix, key.AppID)
+				response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeTokenInvalid)
+				c.Abort()
+				return
+			}
+			c.Set("apiKey", key)
+			c.Set("workspaceID", workspaceID)
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			logger.Errorf("authorization token not provided")
@@ -42,11 +70,58 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Store user information in context for subsequent handlers
-		c.Set("userID", claims.UserID)
-		c.Set("userName", claims.UserName)
-		c.Set("role", claims.Role)
+		// Reject revoked tokens (logout, disabled account)
+		ts := service.GetTokenService()
+		if ts == nil || ts.IsRevoked(claims) {
+			logger.Errorf("token revoked: %s", claims.ID)
+			response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeTokenInvalid)
+			c.Abort()
+			return
+		}
+
+		if !setClaims(c, claims) {
+			c.Abort()
+			return
+		}
 
 		c.Next() // Continue to next middleware or handler
 	}
 }
+
+// setClaims stores user information in context for subsequent handlers.
+// A super admin may act in another workspace by sending X-Workspace-ID; for
+// everyone else the header must match their own workspace. On failure the
+// error response has been written.
+func setClaims(c *gin.Context, claims *utils.Claims) bool {
+	workspaceID := claims.WorkspaceID
+	if workspaceID == 0 {
+		workspaceID = models.DefaultWorkspaceID // tokens issued before workspaces existed
+	}
+	if header := c.GetHeader("X-Workspace-ID"); header != "" {
+		requested, err := strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			logger.Errorf("invalid workspace header: %s", header)
+			response.ResponseError(c, http.StatusBadRequest, response.ErrCodeInvalidParams)
+			return false
+		}
+		if uint(requested) != workspaceID {
+			if claims.Role != models.RoleSuperAdmin {
+				logger.Errorf("user %s cannot access workspace %d", claims.UserName, requested)
+				response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+				return false
+			}
+			if service.GetWorkspaceService().GetWorkspace(uint(requested)) == nil {
+				response.ResponseError(c, http.StatusNotFound, response.ErrCodeNotFound)
+				return false
+			}
+			workspaceID = uint(requested)
+		}
+	}
+
+	c.Set("claims", claims)
+	c.Set("userID", claims.UserID)
+	c.Set("userName", claims.UserName)
+	c.Set("role", claims.Role)
+	c.Set("workspaceID", workspaceID)
+	return true
+}
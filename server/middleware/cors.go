@@ -12,11 +12,11 @@ func CORS() gin.HandlerFunc {
 		// Allow requests from all origins
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		// Allowed request headers
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Encryption-Enabled, X-Device-ID")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Encryption-Enabled, X-Device-ID, If-None-Match")
 		// Allowed request methods
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 		// Allowed exposed response headers
-		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, ETag")
 		// Allow sending credentials
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"kefu-server/models"
+	"kefu-server/utils/logger"
+	"kefu-server/utils/response"
+)
+
+// RequirePermission returns a middleware that requires the authenticated role
+// (or API key) to hold every listed permission. It must run after AuthMiddleware.
+func RequirePermission(perms ...models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, _ := c.Get("role")
+		roleStr, _ := role.(string)
+		apiKey, _ := c.Get("apiKey")
+		key, _ := apiKey.(*models.APIKey)
+		for _, perm := range perms {
+			allowed := models.HasPermission(roleStr, perm)
+			if key != nil {
+				allowed = key.HasPermission(perm)
+			}
+			if !allowed {
+				logger.Errorf("permission denied: role=%s, required=%s, path=%s", roleStr, perm, c.FullPath())
+				response.ResponseError(c, http.StatusForbidden, response.ErrCodeForbidden)
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"kefu-server/service"
+	"kefu-server/utils/logger"
+	"kefu-server/utils/response"
+)
+
+// WSAuthMiddleware authenticates WebSocket handshakes. Browsers cannot set an
+// Authorization header on a handshake, so they pass a one-time ticket obtained
+// from POST /api/v1/ws/ticket as the "ticket" query parameter. Other clients
+// may still use the Authorization header.
+func WSAuthMiddleware() gin.HandlerFunc {
+	auth := AuthMiddleware()
+	return func(c *gin.Context) {
+		ticket := c.Query("ticket")
+		if ticket == "" {
+			auth(c)
+			return
+		}
+
+		ts := service.GetTokenService()
+		if ts == nil {
+			logger.Errorf("token service not initialed")
+			response.ResponseError(c, http.StatusInternalServerError, response.ErrCodeInternalError)
+			c.Abort()
+			return
+		}
+		claims, err := ts.TakeWSTicket(ticket)
+		if err != nil {
+			logger.Errorf("websocket ticket rejected: %v", err)
+			response.ResponseError(c, http.StatusUnauthorized, response.ErrCodeTokenInvalid)
+			c.Abort()
+			return
+		}
+
+		if !setClaims(c, claims) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
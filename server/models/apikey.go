@@ -0,0 +1,31 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKey 服务端集成使用的业务级 API Key，明文只在创建和轮换时返回一次
+type APIKey struct {
+	gorm.Model
+	Name        string       `gorm:"size:100;not null" json:"name"`
+	AppID       string       `gorm:"size:255;index;not null" json:"app_id"` // 所属业务
+	Prefix      string       `gorm:"size:16" json:"prefix"`                 // 明文前缀，便于识别
+	KeyHash     string       `gorm:"size:64;uniqueIndex" json:"-"`          // SHA256(密钥)
+	Permissions []Permission `gorm:"serializer:json" json:"permissions"`
+	CreatedBy   string       `gorm:"size:50" json:"created_by"`
+	LastUsedAt  *time.Time   `json:"last_used_at"`
+	LastUsedIP  string       `gorm:"size:64" json:"last_used_ip"`
+	RotatedAt   *time.Time   `json:"rotated_at"`
+	RevokedAt   *time.Time   `json:"revoked_at"` // 非空表示已吊销
+}
+
+// APIKeyPermissions API Key 可被授予的权限（安全设置和业务管理只允许员工操作）
+var APIKeyPermissions = []Permission{PermAppRead, PermUserRead, PermUserWrite, PermSessionRead, PermMessageSend}
+
+// HasPermission API Key 是否拥有指定权限
+func (k *APIKey) HasPermission(perm Permission) bool {
+	return slices.Contains(k.Permissions, perm)
+}
@@ -4,7 +4,6 @@ import (
 	"kefu-server/store"
 	"kefu-server/utils"
 	"kefu-server/utils/logger"
-	"strings"
 
 	"gorm.io/gorm"
 )
@@ -14,10 +13,23 @@ type App struct {
 	Name        string `gorm:"size:255" json:"name"`
 	Logo        string `gorm:"size:255" json:"logo"`
 	AppID       string `gorm:"size:255" json:"app_id"`
-	Status      int    `gorm:"size:255" json:"status"` // 1=启用, 0=禁用
-	AllowDomain string `gorm:"size:255" json:"allow_domain"`
+	Status      int    `gorm:"size:255" json:"status"`       // 1=启用, 0=禁用
+	AllowDomain string `gorm:"size:255" json:"allow_domain"` // 允许接入的域名，见 DomainAllowlist
 	WelcomeMsg  string `gorm:"size:255" json:"welcome_msg"`
 	Contact     string `gorm:"size:255" json:"contact"` // 联系人
+
+	Widget        WidgetConfig    `gorm:"type:text" json:"widget"`         // 接入组件设置
+	BusinessHours BusinessHours   `gorm:"type:text" json:"business_hours"` // 营业时间
+	PreChatForm   PreChatForm     `gorm:"type:text" json:"pre_chat_form"`  // 咨询前表单
+	Retention     RetentionPolicy `gorm:"type:text" json:"retention"`      // 会话和消息保留策略
+	ConfigVersion int             `gorm:"default:1" json:"config_version"` // 下发配置的版本，每次修改递增
+
+	IdentitySecret   string `gorm:"size:64" json:"-"`  // 访客身份签名密钥，只通过专用接口查看
+	IdentityRequired bool   `json:"identity_required"` // 是否只允许签名验证过的访客
+
+	AllowLocalhost bool `json:"allow_localhost"` // 本地开发模式：另外允许 localhost 和回环地址接入
+
+	WorkspaceID uint `gorm:"index;not null;default:1" json:"workspace_id"` // 所属工作区
 }
 
 // GenAppID 生成唯一的 AppID
@@ -28,46 +40,16 @@ func GenAppID() string {
 	return "zerospace_" + timestamp + "_" + random
 }
 
+// GetApp 访客接入时查找业务，业务或其工作区停用时返回 nil
 func GetApp(appID string) *App {
 	var app App
 	if err := store.DB.Where("app_id = ? AND status = ?", appID, 1).First(&app).Error; err != nil {
 		logger.Errorf("app not found or disabled: %s", appID)
 		return nil
 	}
-	return &app
-}
-
-// IsDomainAllowed 检查域名是否在允许列表中
-func IsDomainAllowed(origin, referer, allowDomain string) bool {
-	if allowDomain == "" {
-		return false
-	}
-
-	// 提取域名
-	domain := utils.ExtractDomain(origin)
-	if domain == "" {
-		domain = utils.ExtractDomain(referer)
-	}
-
-	if domain == "" {
-		return false
-	}
-
-	// 检查域名是否在允许列表中
-	allowedDomains := strings.Split(allowDomain, ",")
-	for _, allowedDomain := range allowedDomains {
-		allowedDomain = strings.TrimSpace(allowedDomain)
-		if allowedDomain == domain {
-			return true
-		}
-		// 检查是否为通配符域名（如 *.pages.dev）
-		if strings.HasPrefix(allowedDomain, "*") {
-			wildcardDomain := strings.TrimPrefix(allowedDomain, "*")
-			if strings.HasSuffix(domain, wildcardDomain) {
-				return true
-			}
-		}
+	if !IsWorkspaceActive(app.WorkspaceID) {
+		logger.Errorf("workspace of app %s is disabled", appID)
+		return nil
 	}
-
-	return false
+	return &app
 }
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"kefu-server/store"
+)
+
+// AppConfigVersion 当前导出格式的版本，导入时拒绝更高版本
+const AppConfigVersion = 1
+
+// 导出格式
+const (
+	AppConfigFormatJSON = "json"
+	AppConfigFormatYAML = "yaml"
+)
+
+// AppConfig 可导出、导入的业务配置，不含 AppID、身份密钥和会话数据
+type AppConfig struct {
+	Version     int    `json:"version"`
+	Name        string `json:"name" binding:"max=255"`
+	Logo        string `json:"logo" binding:"max=255"`
+	AllowDomain string `json:"allow_domain" binding:"max=255"`
+	WelcomeMsg  string `json:"welcome_msg" binding:"max=255"`
+	Contact     string `json:"contact" binding:"max=255"`
+	Status      int    `json:"status" binding:"oneof=0 1"`
+
+	Widget           WidgetConfig    `json:"widget"`
+	BusinessHours    BusinessHours   `json:"business_hours"`
+	PreChatForm      PreChatForm     `json:"pre_chat_form"`
+	Retention        RetentionPolicy `json:"retention"`
+	IdentityRequired bool            `json:"identity_required"`
+	AllowLocalhost   bool            `json:"allow_localhost"`
+
+	Routing AppRouting `json:"routing"`
+}
+
+// AppRouting 接待分配：明确负责该业务的客服，负责全部业务的客服不列出
+type AppRouting struct {
+	Agents []string `json:"agents" binding:"max=500"`
+}
+
+// Value 模板中以 json 字符串存储
+func (c AppConfig) Value() (driver.Value, error) {
+	data, err := json.Marshal(c)
+	return string(data), err
+}
+
+// Scan 读取 json 字符串
+func (c *AppConfig) Scan(value any) error {
+	return scanJSON(value, c)
+}
+
+// ExportAppConfig 导出业务配置
+func ExportAppConfig(app *App, agents []string) AppConfig {
+	config := AppConfig{
+		Version:          AppConfigVersion,
+		Name:             app.Name,
+		Logo:             app.Logo,
+		AllowDomain:      app.AllowDomain,
+		WelcomeMsg:       app.WelcomeMsg,
+		Contact:          app.Contact,
+		Status:           app.Status,
+		Widget:           app.Widget,
+		BusinessHours:    app.BusinessHours,
+		PreChatForm:      app.PreChatForm,
+		Retention:        app.Retention,
+		IdentityRequired: app.IdentityRequired,
+		AllowLocalhost:   app.AllowLocalhost,
+		Routing:          AppRouting{Agents: agents},
+	}
+	config.Widget.Normalize()
+	config.Retention.Normalize()
+	if config.Routing.Agents == nil {
+		config.Routing.Agents = []string{}
+	}
+	return config
+}
+
+// Validate 校验版本及绑定标签无法表达的规则，并补齐缺省值
+func (c *AppConfig) Validate() error {
+	if c.Version < 1 || c.Version > AppConfigVersion {
+		return fmt.Errorf("unsupported config version %d, expected 1 to %d", c.Version, AppConfigVersion)
+	}
+	allowDomain, err := NormalizeAllowDomain(c.AllowDomain)
+	if err != nil {
+		return err
+	}
+	c.AllowDomain = allowDomain
+	if err := c.Widget.Normalize(); err != nil {
+		return err
+	}
+	if err := c.BusinessHours.Validate(); err != nil {
+		return err
+	}
+	if err := c.PreChatForm.Validate(); err != nil {
+		return err
+	}
+	c.Retention.Normalize()
+	return nil
+}
+
+// MarshalAppConfig 按格式编码配置，yaml 的字段名与 json 一致
+func MarshalAppConfig(config *AppConfig, format string) ([]byte, error) {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil || format != AppConfigFormatYAML {
+		return data, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil { // json 是 yaml 的子集，按节点转换可保留字段顺序
+		return nil, err
+	}
+	clearYAMLStyle(&doc)
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), encoder.Close()
+}
+
+// clearYAMLStyle 去掉从 json 继承的流式风格，输出块风格的 yaml
+func clearYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearYAMLStyle(child)
+	}
+}
+
+// ParseAppConfig 解码配置，format 为空时按内容判断
+func ParseAppConfig(data []byte, format string) (*AppConfig, error) {
+	if format == "" {
+		format = AppConfigFormatYAML
+		if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+			format = AppConfigFormatJSON
+		}
+	}
+	if format == AppConfigFormatYAML {
+		var doc any
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid yaml: %v", err)
+		}
+		var err error
+		if data, err = json.Marshal(doc); err != nil {
+			return nil, fmt.Errorf("invalid yaml: %v", err)
+		}
+	}
+
+	var config AppConfig
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields() // 拼错的字段名不应被静默忽略
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+	return &config, nil
+}
+
+// AppTemplate 命名的业务模板，新建业务时可基于模板创建
+type AppTemplate struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	WorkspaceID uint      `gorm:"uniqueIndex:idx_app_templates_workspace_name;not null;default:1" json:"workspace_id"`
+	Name        string    `gorm:"size:64;uniqueIndex:idx_app_templates_workspace_name" json:"name"` // 工作区内唯一
+	Description string    `gorm:"size:255" json:"description"`
+	Config      AppConfig `gorm:"type:text" json:"config"`
+	CreatedBy   string    `gorm:"size:100" json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// GetAppTemplate 按名称查找工作区内的模板
+func GetAppTemplate(workspaceID uint, name string) (*AppTemplate, error) {
+	var template AppTemplate
+	if err := store.DB.Scopes(InWorkspace(workspaceID)).Where("name = ?", name).First(&template).Error; err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
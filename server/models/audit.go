@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+)
+
+// AuditLog 管理操作审计日志（配置和人员变更），只追加不修改
+type AuditLog struct {
+	ID         uint      `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+	Actor      string    `gorm:"size:100;index" json:"actor"`      // 操作人用户名，API Key 为其前缀
+	ActorType  string    `gorm:"size:20" json:"actor_type"`        // user / apikey / system
+	Action     string    `gorm:"size:50;index" json:"action"`      // 如 app.update、user.delete
+	TargetType string    `gorm:"size:50;index" json:"target_type"` // app / user / apikey / setting / lockout
+	TargetID   string    `gorm:"size:255;index" json:"target_id"`
+	Before     string    `gorm:"type:text" json:"before"` // 变更前快照（json），创建时为空
+	After      string    `gorm:"type:text" json:"after"`  // 变更后快照（json），删除时为空
+	Diff       string    `gorm:"type:text" json:"diff"`   // 变化的字段 {"field": {"from": x, "to": y}}
+	IP         string    `gorm:"size:64" json:"ip"`
+	UserAgent  string    `gorm:"size:255" json:"user_agent"`
+
+	WorkspaceID uint `gorm:"index;not null;default:1" json:"workspace_id"` // 操作所在的工作区
+}
+
+// 操作人类型
+const (
+	AuditActorUser   = "user"
+	AuditActorAPIKey = "apikey"
+	AuditActorSystem = "system"
+)
+
+const (
+	SettingAuditRetentionDays = "audit_retention_days" // 审计日志保留天数，0 表示永久保留
+	DefaultAuditRetentionDays = 365
+)
@@ -0,0 +1,207 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	holidayDateLayout  = "2006-01-02"
+	nextOpenSearchDays = 14 // 计算下次营业时间时向后查找的天数
+)
+
+// BusinessHours 业务营业时间：按周排班，节假日可整天休息或使用特殊时段
+type BusinessHours struct {
+	Enabled       bool          `json:"enabled"`                            // 未启用时视为全天营业
+	TimeZone      string        `json:"time_zone"`                          // IANA 时区，如 Asia/Shanghai
+	Weekly        []DaySchedule `json:"weekly" binding:"max=7,dive"`        // 未列出的星期视为休息
+	Holidays      []Holiday     `json:"holidays" binding:"max=366,dive"`    // 节假日例外
+	OutOfHoursMsg string        `json:"out_of_hours_msg" binding:"max=500"` // 非营业时间的自动回复
+}
+
+// DaySchedule 某个星期几的营业时段
+type DaySchedule struct {
+	Weekday int         `json:"weekday" binding:"min=0,max=6"` // 0 为星期日
+	Ranges  []TimeRange `json:"ranges" binding:"max=8,dive"`
+}
+
+// Holiday 节假日，Ranges 为空表示全天休息，否则只在这些时段营业
+type Holiday struct {
+	Date   string      `json:"date"` // YYYY-MM-DD，按业务时区
+	Name   string      `json:"name" binding:"max=64"`
+	Ranges []TimeRange `json:"ranges" binding:"max=8,dive"`
+}
+
+// TimeRange 营业时段 [Start, End)，格式 HH:MM，End 可为 24:00
+type TimeRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// Value 以 json 字符串存储
+func (b BusinessHours) Value() (driver.Value, error) {
+	data, err := json.Marshal(b)
+	return string(data), err
+}
+
+// Scan 读取 json 字符串，历史业务为空时保持零值（未启用）
+func (b *BusinessHours) Scan(value any) error {
+	return scanJSON(value, b)
+}
+
+// parseClock 解析 HH:MM 为当天的分钟数
+func parseClock(s string) (int, error) {
+	if len(s) != 5 || s[2] != ':' {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	for _, ch := range s[:2] + s[3:] {
+		if ch < '0' || ch > '9' {
+			return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+		}
+	}
+	h, _ := strconv.Atoi(s[:2])
+	m, _ := strconv.Atoi(s[3:])
+	if h == 24 && m == 0 {
+		return 24 * 60, nil
+	}
+	if h > 23 || m > 59 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// validateRanges 时段格式正确、开始早于结束且互不重叠
+func validateRanges(ranges []TimeRange) error {
+	type span struct{ start, end int }
+	spans := make([]span, 0, len(ranges))
+	for _, r := range ranges {
+		start, err := parseClock(r.Start)
+		if err != nil {
+			return err
+		}
+		end, err := parseClock(r.End)
+		if err != nil {
+			return err
+		}
+		if start >= end {
+			return fmt.Errorf("time range %s-%s: start must be before end", r.Start, r.End)
+		}
+		spans = append(spans, span{start, end})
+	}
+	slices.SortFunc(spans, func(a, b span) int { return a.start - b.start })
+	for i := 1; i < len(spans); i++ {
+		if spans[i].start < spans[i-1].end {
+			return fmt.Errorf("time ranges overlap")
+		}
+	}
+	return nil
+}
+
+// Validate 校验绑定标签无法表达的规则
+func (b *BusinessHours) Validate() error {
+	if !b.Enabled {
+		return nil
+	}
+	if b.TimeZone == "" {
+		return fmt.Errorf("time zone is required")
+	}
+	if _, err := time.LoadLocation(b.TimeZone); err != nil {
+		return fmt.Errorf("unknown time zone %q", b.TimeZone)
+	}
+
+	seen := map[int]bool{}
+	for _, day := range b.Weekly {
+		if seen[day.Weekday] {
+			return fmt.Errorf("duplicate weekday %d", day.Weekday)
+		}
+		seen[day.Weekday] = true
+		if err := validateRanges(day.Ranges); err != nil {
+			return fmt.Errorf("weekday %d: %w", day.Weekday, err)
+		}
+	}
+
+	dates := map[string]bool{}
+	for _, holiday := range b.Holidays {
+		if _, err := time.Parse(holidayDateLayout, holiday.Date); err != nil {
+			return fmt.Errorf("invalid holiday date %q, expected YYYY-MM-DD", holiday.Date)
+		}
+		if dates[holiday.Date] {
+			return fmt.Errorf("duplicate holiday %s", holiday.Date)
+		}
+		dates[holiday.Date] = true
+		if err := validateRanges(holiday.Ranges); err != nil {
+			return fmt.Errorf("holiday %s: %w", holiday.Date, err)
+		}
+	}
+	return nil
+}
+
+// rangesOn 某天（业务时区）的营业时段，节假日优先于每周排班
+func (b *BusinessHours) rangesOn(day time.Time) []TimeRange {
+	date := day.Format(holidayDateLayout)
+	for _, holiday := range b.Holidays {
+		if holiday.Date == date {
+			return holiday.Ranges
+		}
+	}
+	for _, schedule := range b.Weekly {
+		if schedule.Weekday == int(day.Weekday()) {
+			return schedule.Ranges
+		}
+	}
+	return nil
+}
+
+// location 业务时区，配置无效时退回 UTC
+func (b *BusinessHours) location() *time.Location {
+	loc, err := time.LoadLocation(b.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// IsOpen 指定时刻是否在营业时间内，未启用时始终营业
+func (b *BusinessHours) IsOpen(now time.Time) bool {
+	if !b.Enabled {
+		return true
+	}
+	local := now.In(b.location())
+	minute := local.Hour()*60 + local.Minute()
+	for _, r := range b.rangesOn(local) {
+		start, _ := parseClock(r.Start)
+		end, _ := parseClock(r.End)
+		if minute >= start && minute < end {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpen 指定时刻之后最近的营业开始时间，近期没有营业时段时返回零值
+func (b *BusinessHours) NextOpen(now time.Time) time.Time {
+	if !b.Enabled {
+		return time.Time{}
+	}
+	loc := b.location()
+	local := now.In(loc)
+	for offset := 0; offset < nextOpenSearchDays; offset++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+offset, 0, 0, 0, 0, loc)
+		var next time.Time
+		for _, r := range b.rangesOn(day) {
+			start, _ := parseClock(r.Start)
+			at := time.Date(day.Year(), day.Month(), day.Day(), start/60, start%60, 0, 0, loc)
+			if at.After(now) && (next.IsZero() || at.Before(next)) {
+				next = at
+			}
+		}
+		if !next.IsZero() {
+			return next
+		}
+	}
+	return time.Time{}
+}
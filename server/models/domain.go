@@ -0,0 +1,242 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"kefu-server/utils/logger"
+)
+
+// 允许域名列表：逗号分隔的条目，每条为 [scheme://]host[:port]
+//   - example.com           精确匹配，http 和 https 均可，只允许默认端口
+//   - *.example.com         example.com 的任意子域名（按标签边界），不含 example.com 本身
+//   - https://example.com   只允许 https
+//   - example.com:8443      只允许该端口
+//
+// 本地开发模式（AllowLocalhost）另外放行 localhost 和回环地址的任意端口
+const maxAllowDomainLength = 255
+
+var domainLabelPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// DomainRule 一条允许域名
+type DomainRule struct {
+	Scheme   string // http 或 https，为空时两者均可
+	Host     string // 小写域名或 IP；通配规则为去掉 "*." 后的父域名
+	Wildcard bool   // 匹配 Host 的任意子域名
+	Port     string // 为空时只允许 scheme 的默认端口
+}
+
+// DomainAllowlist 业务的允许域名列表
+type DomainAllowlist []DomainRule
+
+// ParseDomainRule 解析并校验一条允许域名
+func ParseDomainRule(entry string) (DomainRule, error) {
+	var rule DomainRule
+	rest := strings.ToLower(strings.TrimSpace(entry))
+	if scheme, after, ok := strings.Cut(rest, "://"); ok {
+		if scheme != "http" && scheme != "https" {
+			return rule, fmt.Errorf("allow domain %q: scheme must be http or https", entry)
+		}
+		rule.Scheme = scheme
+		rest = after
+	}
+	rest = strings.TrimSuffix(rest, "/")
+	if strings.ContainsAny(rest, "/?#@ ") {
+		return rule, fmt.Errorf("allow domain %q: path, query and credentials are not allowed", entry)
+	}
+
+	host := rest
+	if i := strings.LastIndex(rest, ":"); i >= 0 && !strings.HasSuffix(rest, "]") {
+		host = rest[:i]
+		port, err := strconv.Atoi(rest[i+1:])
+		if err != nil || port < 1 || port > 65535 || rest[i+1] == '0' {
+			return rule, fmt.Errorf("allow domain %q: invalid port", entry)
+		}
+		rule.Port = rest[i+1:]
+	}
+
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		ip := net.ParseIP(host[1 : len(host)-1])
+		if ip == nil || ip.To4() != nil {
+			return rule, fmt.Errorf("allow domain %q: invalid IPv6 address", entry)
+		}
+		rule.Host = ip.String()
+		return rule, nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() == nil {
+			return rule, fmt.Errorf("allow domain %q: IPv6 addresses must be enclosed in brackets", entry)
+		}
+		rule.Host = ip.String()
+		return rule, nil
+	}
+
+	if strings.HasPrefix(host, "*.") {
+		rule.Wildcard = true
+		host = host[2:]
+	}
+	host = strings.TrimSuffix(host, ".")
+	if strings.Contains(host, "*") {
+		return rule, fmt.Errorf("allow domain %q: wildcard is only allowed as a leading \"*.\" label", entry)
+	}
+	if host == "" || len(host) > 253 {
+		return rule, fmt.Errorf("allow domain %q: invalid host", entry)
+	}
+	labels := strings.Split(host, ".")
+	for _, label := range labels {
+		if !domainLabelPattern.MatchString(label) {
+			return rule, fmt.Errorf("allow domain %q: invalid host label %q (use punycode for internationalized domains)", entry, label)
+		}
+	}
+	if rule.Wildcard && len(labels) < 2 {
+		return rule, fmt.Errorf("allow domain %q: wildcard must cover a registrable domain, e.g. *.example.com", entry)
+	}
+	if rule.Wildcard && net.ParseIP(host) != nil {
+		return rule, fmt.Errorf("allow domain %q: wildcard cannot be used with an IP address", entry)
+	}
+	rule.Host = host
+	return rule, nil
+}
+
+// String 规范化的条目写法
+func (r DomainRule) String() string {
+	var b strings.Builder
+	if r.Scheme != "" {
+		b.WriteString(r.Scheme + "://")
+	}
+	if r.Wildcard {
+		b.WriteString("*.")
+	}
+	if strings.Contains(r.Host, ":") {
+		b.WriteString("[" + r.Host + "]")
+	} else {
+		b.WriteString(r.Host)
+	}
+	if r.Port != "" {
+		b.WriteString(":" + r.Port)
+	}
+	return b.String()
+}
+
+// Matches 来源的 scheme、host、port 是否符合该条目，port 为空表示 scheme 的默认端口
+func (r DomainRule) Matches(scheme, host, port string) bool {
+	if r.Scheme != "" && r.Scheme != scheme {
+		return false
+	}
+	if effectivePort(scheme, r.Port) != effectivePort(scheme, port) {
+		return false
+	}
+	if r.Wildcard {
+		return strings.HasSuffix(host, "."+r.Host)
+	}
+	return host == r.Host
+}
+
+// ParseDomainAllowlist 解析并校验逗号分隔的允许域名，重复条目只保留一条
+func ParseDomainAllowlist(s string) (DomainAllowlist, error) {
+	var list DomainAllowlist
+	seen := make(map[DomainRule]bool)
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		rule, err := ParseDomainRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[rule] {
+			seen[rule] = true
+			list = append(list, rule)
+		}
+	}
+	return list, nil
+}
+
+// String 以逗号分隔的规范写法
+func (l DomainAllowlist) String() string {
+	entries := make([]string, len(l))
+	for i, rule := range l {
+		entries[i] = rule.String()
+	}
+	return strings.Join(entries, ",")
+}
+
+// NormalizeAllowDomain 写入业务前校验允许域名并转换为规范写法
+func NormalizeAllowDomain(s string) (string, error) {
+	list, err := ParseDomainAllowlist(s)
+	if err != nil {
+		return "", err
+	}
+	normalized := list.String()
+	if len(normalized) > maxAllowDomainLength {
+		return "", fmt.Errorf("allow domain is too long, at most %d characters", maxAllowDomainLength)
+	}
+	return normalized, nil
+}
+
+// IsOriginAllowed 检查访客页面来源是否在业务的允许域名内，优先使用 Origin，没有时使用 Referer
+func (a *App) IsOriginAllowed(origin, referer string) bool {
+	source := origin
+	if source == "" {
+		source = referer
+	}
+	scheme, host, port, ok := parseSource(source)
+	if !ok {
+		return false
+	}
+	if a.AllowLocalhost && isLocalHost(host) {
+		return true
+	}
+	for _, entry := range strings.Split(a.AllowDomain, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		// 校验规则上线前保存的条目可能不合法（如 *example.com），忽略而不是宽松匹配
+		rule, err := ParseDomainRule(entry)
+		if err != nil {
+			logger.Warnf("app %s ignores invalid allow domain: %v", a.AppID, err)
+			continue
+		}
+		if rule.Matches(scheme, host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSource 从 Origin 或 Referer 中取出 scheme、小写 host 和端口
+func parseSource(source string) (scheme, host, port string, ok bool) {
+	u, err := url.Parse(source)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", "", "", false
+	}
+	host = strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return "", "", "", false
+	}
+	return u.Scheme, host, u.Port(), true
+}
+
+// effectivePort 未写端口时使用 scheme 的默认端口
+func effectivePort(scheme, port string) string {
+	if port != "" {
+		return port
+	}
+	if scheme == "http" {
+		return "80"
+	}
+	return "443"
+}
+
+// isLocalHost 本地开发地址：localhost、*.localhost 和回环地址
+func isLocalHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,147 @@
+package models
+
+import "testing"
+
+func TestParseDomainRule(t *testing.T) {
+	tests := []struct {
+		entry string
+		want  string // 规范写法，为空表示应当校验失败
+	}{
+		{"example.com", "example.com"},
+		{"  Example.COM  ", "example.com"},
+		{"example.com.", "example.com"},
+		{"*.example.com", "*.example.com"},
+		{"*.pages.dev", "*.pages.dev"},
+		{"https://example.com", "https://example.com"},
+		{"https://example.com/", "https://example.com"},
+		{"http://example.com:8080", "http://example.com:8080"},
+		{"example.com:8443", "example.com:8443"},
+		{"https://*.example.com:8443", "https://*.example.com:8443"},
+		{"intranet", "intranet"},
+		{"192.168.1.10", "192.168.1.10"},
+		{"[::1]:3000", "[::1]:3000"},
+		{"xn--fiqs8s.cn", "xn--fiqs8s.cn"},
+
+		{"", ""},
+		{"*", ""},
+		{"*example.com", ""},
+		{"*.com", ""},
+		{"a.*.example.com", ""},
+		{"example.*", ""},
+		{"*.192.168.1.10", ""},
+		{"ftp://example.com", ""},
+		{"https://example.com/path", ""},
+		{"https://user@example.com", ""},
+		{"example.com?x=1", ""},
+		{"example.com:0", ""},
+		{"example.com:65536", ""},
+		{"example.com:080", ""},
+		{"example.com:", ""},
+		{"-example.com", ""},
+		{"exa_mple.com", ""},
+		{"例子.cn", ""},
+		{"::1", ""},
+	}
+	for _, tt := range tests {
+		rule, err := ParseDomainRule(tt.entry)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("ParseDomainRule(%q) = %q, want error", tt.entry, rule.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDomainRule(%q) error: %v", tt.entry, err)
+			continue
+		}
+		if got := rule.String(); got != tt.want {
+			t.Errorf("ParseDomainRule(%q) = %q, want %q", tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeAllowDomain(t *testing.T) {
+	got, err := NormalizeAllowDomain(" Example.com, *.example.com ,,example.com, https://shop.example.com/ ")
+	if err != nil {
+		t.Fatalf("NormalizeAllowDomain error: %v", err)
+	}
+	if want := "example.com,*.example.com,https://shop.example.com"; got != want {
+		t.Errorf("NormalizeAllowDomain = %q, want %q", got, want)
+	}
+
+	if got, err := NormalizeAllowDomain(""); err != nil || got != "" {
+		t.Errorf("NormalizeAllowDomain(\"\") = %q, %v, want empty", got, err)
+	}
+	if _, err := NormalizeAllowDomain("example.com,*example.com"); err == nil {
+		t.Error("NormalizeAllowDomain accepted an invalid entry")
+	}
+
+	long := ""
+	for i := 0; i < 30; i++ {
+		long += "abcdefgh" + string(rune('a'+i%26)) + ".com,"
+	}
+	if _, err := NormalizeAllowDomain(long); err == nil {
+		t.Error("NormalizeAllowDomain accepted a list longer than the column")
+	}
+}
+
+func TestIsOriginAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		allow     string
+		localhost bool
+		origin    string
+		referer   string
+		want      bool
+	}{
+		{"exact host", "example.com", false, "https://example.com", "", true},
+		{"exact host over http", "example.com", false, "http://example.com", "", true},
+		{"exact host is case insensitive", "example.com", false, "https://EXAMPLE.com", "", true},
+		{"exact host does not match subdomain", "example.com", false, "https://www.example.com", "", false},
+		{"exact host does not match suffix", "example.com", false, "https://evilexample.com", "", false},
+
+		{"wildcard matches subdomain", "*.example.com", false, "https://www.example.com", "", true},
+		{"wildcard matches nested subdomain", "*.example.com", false, "https://a.b.example.com", "", true},
+		{"wildcard does not match apex", "*.example.com", false, "https://example.com", "", false},
+		{"wildcard respects label boundary", "*.example.com", false, "https://evilexample.com", "", false},
+		{"wildcard does not match lookalike", "*.example.com", false, "https://example.com.evil.net", "", false},
+
+		{"scheme restricted", "https://example.com", false, "http://example.com", "", false},
+		{"scheme matches", "https://example.com", false, "https://example.com", "", true},
+
+		{"default port only", "example.com", false, "https://example.com:8443", "", false},
+		{"explicit default port", "example.com", false, "https://example.com:443", "", true},
+		{"port must match", "example.com:8443", false, "https://example.com:8443", "", true},
+		{"other port rejected", "example.com:8443", false, "https://example.com:9443", "", false},
+		{"port without default", "example.com:8443", false, "https://example.com", "", false},
+		{"https port entry over http", "https://example.com:443", false, "http://example.com", "", false},
+
+		{"ipv4", "192.168.1.10:8080", false, "http://192.168.1.10:8080", "", true},
+		{"ipv6", "[::1]:3000", false, "http://[::1]:3000", "", true},
+
+		{"multiple entries", "a.com, *.b.com", false, "https://x.b.com", "", true},
+		{"referer fallback", "example.com", false, "", "https://example.com/page?x=1", true},
+		{"origin takes precedence", "example.com", false, "https://evil.net", "https://example.com/", false},
+		{"null origin", "example.com", false, "null", "", false},
+		{"non-http origin", "example.com", false, "file:///index.html", "", false},
+		{"empty allowlist", "", false, "https://example.com", "", false},
+		{"no source", "example.com", false, "", "", false},
+		{"legacy entry ignored", "*example.com", false, "https://evilexample.com", "", false},
+		{"legacy entry skipped", "*example.com,example.com", false, "https://example.com", "", true},
+
+		{"localhost off", "example.com", false, "http://localhost:5173", "", false},
+		{"localhost any port", "", true, "http://localhost:5173", "", true},
+		{"localhost subdomain", "", true, "http://app.localhost:3000", "", true},
+		{"loopback ipv4", "", true, "http://127.0.0.1:8080", "", true},
+		{"loopback ipv6", "", true, "http://[::1]:8080", "", true},
+		{"localhost mode keeps allowlist", "example.com", true, "https://example.com", "", true},
+		{"localhost mode rejects others", "example.com", true, "https://evil.net", "", false},
+		{"localhost lookalike", "", true, "https://localhost.evil.net", "", false},
+	}
+	for _, tt := range tests {
+		app := App{AppID: "test", AllowDomain: tt.allow, AllowLocalhost: tt.localhost}
+		if got := app.IsOriginAllowed(tt.origin, tt.referer); got != tt.want {
+			t.Errorf("%s: IsOriginAllowed(%q, %q) with %q = %v, want %v", tt.name, tt.origin, tt.referer, tt.allow, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"kefu-server/utils"
+)
+
+// 访客身份验证
+// 接入方服务端持有业务的身份密钥，为登录用户生成以下任一凭证交给接入组件：
+//   user_hash      = hex(HMAC-SHA256(secret, visitor_id))
+//   identity_token = HS256 JWT，sub 为 visitor_id，可携带 name、email，必须设置 exp
+// 业务开启 IdentityRequired 后，未签名或签名不匹配的访客无法连接；
+// 未开启时匿名访客使用服务端签发的 visitor_token，不再接受裸 visitor_id
+
+const (
+	identitySecretBytes  = 32
+	maxVisitorIDLength   = 64
+	maxIdentityNameChars = 64
+	maxIdentityEmailLen  = 255
+)
+
+var (
+	ErrIdentityRequired = errors.New("visitor identity verification required")
+	ErrIdentityInvalid  = errors.New("visitor identity verification failed")
+	ErrInvalidVisitorID = errors.New("invalid visitor id")
+	ErrVisitorToken     = errors.New("visitor token invalid or expired")
+)
+
+// VisitorIdentity 接入方签名确认的访客身份，保存在会话中供客服查看
+type VisitorIdentity struct {
+	Name     string `json:"name,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Verified bool   `json:"verified"`
+}
+
+// identityClaims identity_token 的载荷
+type identityClaims struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// GenVisitorID 为首次访问的匿名访客生成 visitor_id
+func GenVisitorID() string {
+	return "v_" + utils.GenerateSecureToken(12)
+}
+
+// GenIdentitySecret 生成业务的身份密钥
+func GenIdentitySecret() string {
+	return utils.GenerateSecureToken(identitySecretBytes)
+}
+
+// VisitorHash 计算 visitor_id 的 user_hash，与接入方服务端的算法一致
+func VisitorHash(secret, visitorID string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(visitorID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validVisitorID visitor_id 会拼入存储键，不能为空、过长或包含分隔符
+func validVisitorID(visitorID string) bool {
+	return visitorID != "" && len(visitorID) <= maxVisitorIDLength && !strings.Contains(visitorID, ":")
+}
+
+// VerifyVisitor 校验接入方签名的访客凭证，返回可信的 visitor_id 及已验证的身份
+// 使用 identity_token 时可省略 visitor_id，以 token 的 sub 为准；提供了凭证就必须有效
+func (a *App) VerifyVisitor(visitorID, userHash, identityToken string) (string, *VisitorIdentity, error) {
+	switch {
+	case identityToken != "":
+		if a.IdentitySecret == "" {
+			return "", nil, ErrIdentityInvalid
+		}
+		var claims identityClaims
+		if _, err := jwt.ParseWithClaims(identityToken, &claims, func(*jwt.Token) (any, error) {
+			return []byte(a.IdentitySecret), nil
+		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired()); err != nil {
+			return "", nil, ErrIdentityInvalid
+		}
+		if !validVisitorID(claims.Subject) {
+			return "", nil, ErrInvalidVisitorID
+		}
+		if visitorID != "" && visitorID != claims.Subject {
+			return "", nil, ErrIdentityInvalid
+		}
+		if len([]rune(claims.Name)) > maxIdentityNameChars || len(claims.Email) > maxIdentityEmailLen {
+			return "", nil, ErrIdentityInvalid
+		}
+		return claims.Subject, &VisitorIdentity{Name: claims.Name, Email: claims.Email, Verified: true}, nil
+
+	case userHash != "":
+		if !validVisitorID(visitorID) {
+			return "", nil, ErrInvalidVisitorID
+		}
+		if a.IdentitySecret == "" || !hmac.Equal([]byte(strings.ToLower(userHash)), []byte(VisitorHash(a.IdentitySecret, visitorID))) {
+			return "", nil, ErrIdentityInvalid
+		}
+		return visitorID, &VisitorIdentity{Verified: true}, nil
+
+	default:
+		return "", nil, ErrIdentityRequired
+	}
+}
@@ -1,18 +1,66 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
 )
 
+// 消息内容类型
+const (
+	MsgTypeText  = "text"  // 纯文本，可含 Emoji、URL 和换行
+	MsgTypeImage = "image" // 图片，URL 必填
+	MsgTypeAudio = "audio" // 语音，URL 和 Duration 必填
+	MsgTypeFile  = "file"  // 文件，URL、Name 和 Size 必填
+)
+
+// 消息发送方
+const (
+	MessageFromVisitor = "visitor"
+	MessageFromAgent   = "agent"
+	MessageFromSystem  = "system" // 自动回复
+)
+
 type Message struct {
-	MsgID     string `json:"msg_id"`   // m:{visitor_id}:{app_id}:{session_seq}:{msg_seq}
-	MsgType   string `json:"msg_type"` // "text", "image", etc.
+	MsgID     string `json:"msg_id"`         // m:{visitor_id}:{app_id}:{session_seq}:{msg_seq}
+	From      string `json:"from,omitempty"` // visitor / agent / system
+	AgentID   string `json:"agent_id,omitempty"`
+	MsgType   string `json:"msg_type"` // text / image / audio / file
 	Content   string `json:"content,omitempty"`
+	URL       string `json:"url,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Size      int64  `json:"size,omitempty"`     // 文件字节数
+	Duration  int    `json:"duration,omitempty"` // 语音秒数
 	Timestamp int64  `json:"timestamp"`
 }
 
+// UpgradeLegacy 转换 WebSocket 协议定型前保存的消息：MsgType 记录的是帧类型，
+// 访客消息的 Content 可能是原样保存的 json 对象
+func (m *Message) UpgradeLegacy() {
+	switch m.MsgType {
+	case "message.req":
+		m.From = MessageFromVisitor
+	case "message.rsp":
+		m.From = MessageFromAgent
+	default:
+		return
+	}
+	m.MsgType = MsgTypeText
+	if !strings.HasPrefix(m.Content, "{") {
+		return
+	}
+	var payload Message
+	if err := json.Unmarshal([]byte(m.Content), &payload); err == nil && payload.MsgType != "" {
+		m.MsgType = payload.MsgType
+		m.Content = payload.Content
+		m.URL = payload.URL
+		m.Name = payload.Name
+		m.Size = payload.Size
+		m.Duration = payload.Duration
+	}
+}
+
 // ParseMessageID 从 messageID 中解析字段
 func ParseMessageID(messageID string) (visitorID, appID string, sessionSeq, msgSeq uint32) {
 	parts := strings.Split(messageID, ":")
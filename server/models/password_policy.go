@@ -0,0 +1,91 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const SettingPasswordPolicy = "password_policy" // 密码策略，json 对象
+
+const (
+	MaxPasswordHistory = 24  // 密码历史最多保留的条数
+	MaxPasswordLength  = 128 // 密码最大长度
+)
+
+// PasswordPolicy 密码策略
+type PasswordPolicy struct {
+	MinLength     int  `json:"min_length" binding:"min=8,max=128"`
+	RequireLetter bool `json:"require_letter"`
+	RequireDigit  bool `json:"require_digit"`
+	RequireSymbol bool `json:"require_symbol"`
+	HistoryCount  int  `json:"history_count" binding:"min=0,max=24"` // 不得与最近 N 个密码（含当前密码）相同，0 表示不限制
+}
+
+// DefaultPasswordPolicy 缺省密码策略
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:    8,
+	HistoryCount: 3,
+}
+
+var (
+	ErrPasswordTooShort = errors.New("password is too short")
+	ErrPasswordTooLong  = errors.New("password is too long")
+	ErrPasswordNoLetter = errors.New("password must contain a letter")
+	ErrPasswordNoDigit  = errors.New("password must contain a digit")
+	ErrPasswordNoSymbol = errors.New("password must contain a symbol")
+	ErrPasswordUsername = errors.New("password must not contain the username")
+)
+
+// GetPasswordPolicy 读取工作区的密码策略，未配置时返回缺省策略
+func GetPasswordPolicy(workspaceID uint) PasswordPolicy {
+	policy := DefaultPasswordPolicy
+	if value := GetWorkspaceSetting(workspaceID, SettingPasswordPolicy); value != "" {
+		json.Unmarshal([]byte(value), &policy)
+	}
+	return policy
+}
+
+// SetPasswordPolicy 保存工作区的密码策略
+func SetPasswordPolicy(workspaceID uint, policy PasswordPolicy) error {
+	data, _ := json.Marshal(policy)
+	return SetWorkspaceSetting(workspaceID, SettingPasswordPolicy, string(data))
+}
+
+// Validate 校验明文密码是否符合策略（不含历史密码检查）
+func (p PasswordPolicy) Validate(username, password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return ErrPasswordTooShort
+	}
+	if length > MaxPasswordLength {
+		return ErrPasswordTooLong
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return ErrPasswordUsername
+	}
+
+	var letter, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	if p.RequireLetter && !letter {
+		return ErrPasswordNoLetter
+	}
+	if p.RequireDigit && !digit {
+		return ErrPasswordNoDigit
+	}
+	if p.RequireSymbol && !symbol {
+		return ErrPasswordNoSymbol
+	}
+	return nil
+}
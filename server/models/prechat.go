@@ -0,0 +1,136 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 咨询前表单字段类型
+const (
+	PreChatFieldText     = "text"
+	PreChatFieldTextarea = "textarea"
+	PreChatFieldEmail    = "email"
+	PreChatFieldPhone    = "phone"
+	PreChatFieldNumber   = "number"
+	PreChatFieldSelect   = "select"
+
+	defaultPreChatMaxLength = 500
+)
+
+var (
+	preChatNamePattern  = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+	preChatPhonePattern = regexp.MustCompile(`^\+?[0-9][0-9 ()-]{4,19}$`)
+)
+
+// PreChatForm 访客开始咨询前需要填写的表单，启用后提交前不分配客服
+type PreChatForm struct {
+	Enabled bool           `json:"enabled"`
+	Fields  []PreChatField `json:"fields" binding:"max=20,dive"`
+}
+
+// PreChatField 表单字段
+type PreChatField struct {
+	Name      string   `json:"name" binding:"required"` // 字段键，如 email、order_no
+	Label     string   `json:"label" binding:"max=64"`  // 显示名称
+	Type      string   `json:"type" binding:"required,oneof=text textarea email phone number select"`
+	Required  bool     `json:"required"`
+	Pattern   string   `json:"pattern" binding:"max=255"`            // 校验正则，需完整匹配
+	Options   []string `json:"options" binding:"max=50,dive,max=64"` // select 的可选值
+	MaxLength int      `json:"max_length" binding:"min=0,max=2000"`  // 为 0 时缺省 500
+}
+
+// Value 以 json 字符串存储
+func (f PreChatForm) Value() (driver.Value, error) {
+	data, err := json.Marshal(f)
+	return string(data), err
+}
+
+// Scan 读取 json 字符串，历史业务为空时保持零值（未启用）
+func (f *PreChatForm) Scan(value any) error {
+	return scanJSON(value, f)
+}
+
+// Validate 校验绑定标签无法表达的规则
+func (f *PreChatForm) Validate() error {
+	names := map[string]bool{}
+	for _, field := range f.Fields {
+		if !preChatNamePattern.MatchString(field.Name) {
+			return fmt.Errorf("invalid field name %q, expected lowercase letters, digits and _", field.Name)
+		}
+		if names[field.Name] {
+			return fmt.Errorf("duplicate field %q", field.Name)
+		}
+		names[field.Name] = true
+		if field.Pattern != "" {
+			if _, err := regexp.Compile(field.Pattern); err != nil {
+				return fmt.Errorf("field %s: invalid pattern: %v", field.Name, err)
+			}
+		}
+		if field.Type == PreChatFieldSelect && len(field.Options) == 0 {
+			return fmt.Errorf("field %s: select requires options", field.Name)
+		}
+	}
+	return nil
+}
+
+// Check 校验访客提交的表单，返回只含已定义字段的值
+func (f *PreChatForm) Check(values map[string]string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, field := range f.Fields {
+		value := strings.TrimSpace(values[field.Name])
+		if value == "" {
+			if field.Required {
+				return nil, fmt.Errorf("%s is required", field.Name)
+			}
+			continue
+		}
+
+		maxLength := field.MaxLength
+		if maxLength == 0 {
+			maxLength = defaultPreChatMaxLength
+		}
+		if utf8.RuneCountInString(value) > maxLength {
+			return nil, fmt.Errorf("%s is too long", field.Name)
+		}
+
+		switch field.Type {
+		case PreChatFieldEmail:
+			if addr, err := mail.ParseAddress(value); err != nil || addr.Address != value {
+				return nil, fmt.Errorf("%s must be an email address", field.Name)
+			}
+		case PreChatFieldPhone:
+			if !preChatPhonePattern.MatchString(value) {
+				return nil, fmt.Errorf("%s must be a phone number", field.Name)
+			}
+		case PreChatFieldNumber:
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("%s must be a number", field.Name)
+			}
+		case PreChatFieldSelect:
+			if !slices.Contains(field.Options, value) {
+				return nil, fmt.Errorf("%s must be one of the options", field.Name)
+			}
+		}
+
+		if field.Pattern != "" {
+			pattern, err := regexp.Compile(`^(?:` + field.Pattern + `)$`)
+			if err != nil || !pattern.MatchString(value) {
+				return nil, fmt.Errorf("%s has an invalid format", field.Name)
+			}
+		}
+		result[field.Name] = value
+	}
+	return result, nil
+}
+
+// Pending 表单已启用且会话尚未提交
+func (f *PreChatForm) Pending(session *Session) bool {
+	return f.Enabled && len(f.Fields) > 0 && session.PreChat == nil
+}
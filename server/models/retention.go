@@ -0,0 +1,89 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"kefu-server/store"
+)
+
+// DefaultRetentionDays 未设置保留策略的业务沿用原有的 30 天
+const DefaultRetentionDays = 30
+
+// RetentionPolicy 会话和消息的保留策略
+// 消息自发送时间起计算，会话自最后活跃时间起计算，到期后由存储自动删除
+type RetentionPolicy struct {
+	Forever bool `json:"forever"`                       // 永久保留，忽略 Days
+	Days    int  `json:"days" binding:"min=0,max=3650"` // 保留天数，为 0 时取缺省天数
+}
+
+// Value 以 json 字符串存储
+func (r RetentionPolicy) Value() (driver.Value, error) {
+	data, err := json.Marshal(r)
+	return string(data), err
+}
+
+// Scan 读取 json 字符串，历史业务为空时为零值（按缺省天数保留）
+func (r *RetentionPolicy) Scan(value any) error {
+	return scanJSON(value, r)
+}
+
+// Normalize 永久保留时清空天数；历史业务的零值补为缺省天数
+func (r *RetentionPolicy) Normalize() {
+	if r.Forever {
+		r.Days = 0
+	} else if r.Days == 0 {
+		r.Days = DefaultRetentionDays
+	}
+}
+
+// ExpiresAt 从 from 起算的过期时间，永久保留时返回零值
+func (r RetentionPolicy) ExpiresAt(from time.Time) time.Time {
+	r.Normalize()
+	if r.Forever {
+		return time.Time{}
+	}
+	return from.AddDate(0, 0, r.Days)
+}
+
+// 保留策略缓存，会话和消息每次写入都要用到；gen 在清除缓存时递增，
+// 避免清除前发起的查询把旧策略写回缓存
+var retentionCache = struct {
+	sync.Mutex
+	policies map[string]RetentionPolicy
+	gen      uint64
+}{policies: map[string]RetentionPolicy{}}
+
+// GetRetention 业务当前的保留策略，停用或已归档的业务同样适用，查询失败时使用缺省策略
+func GetRetention(appID string) RetentionPolicy {
+	retentionCache.Lock()
+	policy, ok := retentionCache.policies[appID]
+	gen := retentionCache.gen
+	retentionCache.Unlock()
+	if ok {
+		return policy
+	}
+
+	var app App
+	if err := store.DB.Unscoped().Select("retention").Where("app_id = ?", appID).First(&app).Error; err != nil {
+		return RetentionPolicy{Days: DefaultRetentionDays}
+	}
+	app.Retention.Normalize()
+
+	retentionCache.Lock()
+	if retentionCache.gen == gen {
+		retentionCache.policies[appID] = app.Retention
+	}
+	retentionCache.Unlock()
+	return app.Retention
+}
+
+// InvalidateRetention 修改保留策略或删除业务记录后清除缓存
+func InvalidateRetention(appID string) {
+	retentionCache.Lock()
+	delete(retentionCache.policies, appID)
+	retentionCache.gen++
+	retentionCache.Unlock()
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// 角色
+const (
+	RoleSuperAdmin = "superadmin" // 超级管理员：管理工作区，可进入任意工作区操作
+	RoleAdmin      = "admin"      // 管理员：所在工作区的全部权限
+	RoleSupervisor = "supervisor" // 主管：接待、查看会话、查看客服和业务
+	RoleAgent      = "agent"      // 客服：接待访客
+	RoleAnalyst    = "analyst"    // 分析员：只读
+)
+
+// Permission 权限
+type Permission string
+
+const (
+	PermAppRead         Permission = "app:read"         // 查看业务
+	PermAppWrite        Permission = "app:write"        // 创建、修改、删除业务
+	PermUserRead        Permission = "user:read"        // 查看客服
+	PermUserWrite       Permission = "user:write"       // 创建、修改、删除客服
+	PermChat            Permission = "chat"             // 接待访客
+	PermSessionRead     Permission = "session:read"     // 查看会话与消息
+	PermMessageSend     Permission = "message:send"     // 通过接口向访客发送消息
+	PermSecurityManage  Permission = "security:manage"  // 登录锁定、两步验证策略等安全设置
+	PermAuditRead       Permission = "audit:read"       // 查看审计日志
+	PermWorkspaceManage Permission = "workspace:manage" // 创建、停用工作区及实例级设置
+)
+
+// RolePermissions 角色到权限的映射
+var RolePermissions = map[string][]Permission{
+	RoleSuperAdmin: {
+		PermAppRead, PermAppWrite, PermUserRead, PermUserWrite,
+		PermChat, PermSessionRead, PermMessageSend, PermSecurityManage, PermAuditRead, PermWorkspaceManage,
+	},
+	RoleAdmin: {
+		PermAppRead, PermAppWrite, PermUserRead, PermUserWrite,
+		PermChat, PermSessionRead, PermMessageSend, PermSecurityManage, PermAuditRead,
+	},
+	RoleSupervisor: {PermAppRead, PermUserRead, PermChat, PermSessionRead},
+	RoleAgent:      {PermAppRead, PermChat},
+	RoleAnalyst:    {PermAppRead, PermUserRead, PermSessionRead},
+}
+
+// IsValidRole 是否为已定义的角色
+func IsValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// HasPermission 角色是否拥有指定权限
+func HasPermission(role string, perm Permission) bool {
+	return slices.Contains(RolePermissions[role], perm)
+}
+
+// IsAdminRole 是否为管理员或超级管理员
+func IsAdminRole(role string) bool {
+	return role == RoleAdmin || role == RoleSuperAdmin
+}
+
+// AppScope 返回用户可访问的业务，all 为 true 表示不限业务（仍限于所在工作区）
+func (u *User) AppScope() (apps []string, all bool) {
+	if IsAdminRole(u.Role) {
+		return nil, true
+	}
+	json.Unmarshal([]byte(u.Apps), &apps)
+	return apps, slices.Contains(apps, "all")
+}
+
+// CanAccessApp 用户是否可访问指定业务
+func (u *User) CanAccessApp(appID string) bool {
+	apps, all := u.AppScope()
+	return all || slices.Contains(apps, appID)
+}
+
+// ServesApp 用户是否接待该业务的访客：拥有接待权限，且明确负责该业务或负责全部业务
+func (u *User) ServesApp(appID string) bool {
+	return HasPermission(u.Role, PermChat) && u.CanAccessApp(appID)
+}
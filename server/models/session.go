@@ -24,6 +24,10 @@ type Session struct {
 	LastAgentReadTime  int64  `json:"last_agent_read_time"`   // 最后客服已读消息时间
 	Closed             bool   `json:"closed"`                 // 会话是否关闭
 	FollowUp           bool   `json:"need_follow_up"`         // 会话是否需要跟进
+	OutOfHoursUntil    int64  `json:"out_of_hours_until"`     // 已自动回复的非营业时段的结束时间（即下次营业开始，近期无营业时段时为回复后 14 天）
+
+	PreChat  map[string]string `json:"pre_chat"`           // 访客提交的咨询前表单，未提交为 null
+	Identity *VisitorIdentity  `json:"identity,omitempty"` // 接入方签名确认的访客身份
 }
 
 // 由 session_id 提取 visitor_id, app_id, session_seq
@@ -147,3 +151,27 @@ func (s *Session) SessionSeq() uint32 {
 	_, _, sessionSeq := s.ParseSid()
 	return sessionSeq
 }
+
+// 13. 最后活跃时间：最后一条访客或客服消息，尚无消息时为创建时间
+func (s *Session) LastActiveAt() int64 {
+	lastActive := max(s.LastVisitorMsgTime, s.LastAgentReplyTime)
+	if lastActive == 0 { // 尚无消息（如只提交了咨询前表单）
+		lastActive = s.CreatedAt
+	}
+	return lastActive
+}
+
+// 14. 更新已验证的访客身份，只含 user_hash 的验证不覆盖之前 token 带来的姓名和邮箱
+func (s *Session) SetIdentity(identity *VisitorIdentity) bool {
+	if identity == nil {
+		return false
+	}
+	if s.Identity != nil && identity.Name == "" && identity.Email == "" {
+		return false
+	}
+	if s.Identity != nil && *s.Identity == *identity {
+		return false
+	}
+	s.Identity = identity
+	return true
+}
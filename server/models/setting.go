@@ -0,0 +1,64 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"kefu-server/store"
+	"kefu-server/utils/logger"
+)
+
+// Setting 键值配置（可由管理员在运行时修改）
+// 实例级配置直接以 key 保存；工作区配置以 ws:{workspace_id}:{key} 保存，未设置时沿用实例级的值
+type Setting struct {
+	Key       string    `gorm:"primaryKey;size:100" json:"key"`
+	Value     string    `gorm:"type:text" json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const (
+	SettingRequire2FARoles = "require_2fa_roles" // 强制两步验证的角色，json 字符串数组
+)
+
+// GetSetting 读取配置，不存在时返回空字符串
+func GetSetting(key string) string {
+	var setting Setting
+	if err := store.DB.Where("key = ?", key).First(&setting).Error; err != nil {
+		return ""
+	}
+	return setting.Value
+}
+
+func workspaceSettingKey(workspaceID uint, key string) string {
+	return fmt.Sprintf("ws:%d:%s", workspaceID, key)
+}
+
+// GetWorkspaceSetting 读取工作区配置，未设置时返回实例级配置
+func GetWorkspaceSetting(workspaceID uint, key string) string {
+	if value := GetSetting(workspaceSettingKey(workspaceID, key)); value != "" {
+		return value
+	}
+	return GetSetting(key)
+}
+
+// SetWorkspaceSetting 写入工作区配置
+func SetWorkspaceSetting(workspaceID uint, key, value string) error {
+	return SetSetting(workspaceSettingKey(workspaceID, key), value)
+}
+
+// DeleteWorkspaceSettings 删除工作区的全部配置
+func DeleteWorkspaceSettings(db *gorm.DB, workspaceID uint) error {
+	return db.Where("key LIKE ?", workspaceSettingKey(workspaceID, "%")).Delete(&Setting{}).Error
+}
+
+// SetSetting 写入配置
+func SetSetting(key, value string) error {
+	setting := Setting{Key: key, Value: value}
+	if err := store.DB.Save(&setting).Error; err != nil {
+		logger.Errorf("save setting %s failed: %v", key, err)
+		return err
+	}
+	return nil
+}
@@ -1,29 +1,86 @@
 package models
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"time"
 
 	"gorm.io/gorm"
 
+	"kefu-server/utils"
 	"kefu-server/utils/logger"
 )
 
 type User struct {
 	gorm.Model
 	Username string `gorm:"uniqueIndex;size:50;not null" json:"username"`
-	Password string `gorm:"size:255;not null" json:"-"`     // 明文密码
+	Password string `gorm:"size:255;not null" json:"-"`     // argon2id(SHA256(明文)) 加盐哈希
 	Avatar   string `gorm:"size:255" json:"avatar"`         // 头像
-	Role     string `gorm:"size:50;not null" json:"role"`   // agent or admin
-	Status   int    `gorm:"size:50;not null" json:"status"` // 1、在席 2、离席
+	Role     string `gorm:"size:50;not null" json:"role"`   // superadmin / admin / supervisor / agent / analyst，见 role.go
+	Status   int    `gorm:"size:50;not null" json:"status"` // 0、离线 1、在席 2、离席 3、忙碌，见 UserStatus*
 	Active   bool   `gorm:"default:true" json:"active"`     // 1、激活 0、禁用
 	Apps     string `gorm:"type:text" json:"apps"`          // 客服负责的业务, 格式位json字符串数组， 范围 缺省 ["all"]
+
+	WorkspaceID uint `gorm:"index;not null;default:1" json:"workspace_id"` // 所属工作区
+
+	ExternalID string `gorm:"size:255;index" json:"-"`                  // 单点登录用户的 issuer|sub
+	AuthSource string `gorm:"size:20;default:local" json:"auth_source"` // local 或 oidc
+
+	MustChangePassword bool       `gorm:"default:false" json:"must_change_password"` // 下次登录必须修改密码
+	PasswordChangedAt  *time.Time `json:"password_changed_at"`
+	PasswordHistory    string     `gorm:"type:text" json:"-"` // 历史密码哈希，json 字符串数组，最新的在前
+
+	TOTPSecret    string `gorm:"size:64" json:"-"`                  // TOTP 密钥（base32），未确认前为待启用状态
+	TOTPEnabled   bool   `gorm:"default:false" json:"totp_enabled"` // 是否已启用两步验证
+	RecoveryCodes string `gorm:"type:text" json:"-"`                // 恢复码的 SHA256 摘要，json 字符串数组
+}
+
+// 账号来源
+const (
+	AuthSourceLocal = "local" // 本地账号密码
+	AuthSourceOIDC  = "oidc"  // 单点登录自动创建
+)
+
+// 客服在线状态（User.Status），只有在席的客服会被分配新会话
+const (
+	UserStatusOffline = 0 // 离线：没有客服 WebSocket 连接
+	UserStatusOnline  = 1 // 在席
+	UserStatusAway    = 2 // 离席
+	UserStatusBusy    = 3 // 忙碌：保持连接但不接新会话
+)
+
+// userStatusNames 在线状态的接口名称
+var userStatusNames = map[int]string{
+	UserStatusOffline: "offline",
+	UserStatusOnline:  "online",
+	UserStatusAway:    "away",
+	UserStatusBusy:    "busy",
+}
+
+// UserStatusName 在线状态的接口名称
+func UserStatusName(status int) string {
+	if name, ok := userStatusNames[status]; ok {
+		return name
+	}
+	return userStatusNames[UserStatusOffline]
+}
+
+// ParseUserStatus 由接口名称解析在线状态
+func ParseUserStatus(name string) (int, bool) {
+	for status, n := range userStatusNames {
+		if n == name {
+			return status, true
+		}
+	}
+	return 0, false
+}
+
+// SetPassword 由明文密码生成存储用的登录验证器
+func (u *User) SetPassword(password string) {
+	u.Password = utils.NewPasswordVerifier(utils.PrehashPassword(password))
 }
 
-// hashPassword 使用SHA256 hash密码
-func hashPassword(password string) string {
-	hash := sha256.Sum256([]byte(password))
-	return hex.EncodeToString(hash[:])
+// CheckPassword 校验密码预哈希，仅用于旧 argon2id 哈希升级为验证器
+func (u *User) CheckPassword(prehash string) bool {
+	return utils.MatchPassword(prehash, u.Password)
 }
 
 func CreateDefaultUsers(db *gorm.DB) error {
@@ -34,18 +91,24 @@ func CreateDefaultUsers(db *gorm.DB) error {
 		users := []User{
 			{
 				Username: "admin",
-				Password: "12345678", // 存储明文密码
-				Role:     "admin",
+				Role:     RoleSuperAdmin,
 				Avatar:   "https://api.dicebear.com/7.x/avataaars/svg?seed=admin",
 			},
 			{
 				Username: "agent",
-				Password: "12345678", // 存储明文密码
-				Role:     "agent",
+				Role:     RoleAgent,
 				Avatar:   "https://api.dicebear.com/7.x/avataaars/svg?seed=agent",
 			},
 		}
 
+		// 初始密码随机生成，仅在首次启动时输出一次
+		for i := range users {
+			password := utils.GenerateSecureToken(8)
+			users[i].SetPassword(password)
+			users[i].MustChangePassword = true
+			logger.Warnf("initial password for %s: %s (must be changed at first login)", users[i].Username, password)
+		}
+
 		if err := db.Create(&users).Error; err != nil {
 			logger.Errorf("create default users failed: %v", err)
 			return err
@@ -56,3 +119,30 @@ func CreateDefaultUsers(db *gorm.DB) error {
 
 	return nil
 }
+
+// MigratePasswords 将历史明文密码迁移为登录验证器
+func MigratePasswords(db *gorm.DB) error {
+	var users []User
+	if err := db.Unscoped().Find(&users).Error; err != nil {
+		logger.Errorf("load users for password migration failed: %v", err)
+		return err
+	}
+
+	migrated := 0
+	for _, user := range users {
+		if utils.IsPasswordHashed(user.Password) {
+			continue
+		}
+		user.SetPassword(user.Password)
+		if err := db.Unscoped().Model(&User{}).Where("id = ?", user.ID).Update("password", user.Password).Error; err != nil {
+			logger.Errorf("migrate password failed for user %s: %v", user.Username, err)
+			return err
+		}
+		migrated++
+	}
+
+	if migrated > 0 {
+		logger.Infof("migrated %d plaintext passwords to login verifiers", migrated)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// 接入组件位置
+const (
+	WidgetPositionBottomRight = "bottom-right"
+	WidgetPositionBottomLeft  = "bottom-left"
+)
+
+var ErrWidgetAutoOpenPath = errors.New("auto open path must start with /")
+
+// WidgetConfig 接入组件外观与行为设置，随 /api/v1/config 下发
+type WidgetConfig struct {
+	ThemeColor   string       `json:"theme_color" binding:"omitempty,hexcolor"`                    // 主题色，如 #2563eb
+	Position     string       `json:"position" binding:"omitempty,oneof=bottom-right bottom-left"` // 悬浮按钮位置
+	LauncherText string       `json:"launcher_text" binding:"max=32"`                              // 悬浮按钮文字
+	ShowAvatar   bool         `json:"show_avatar"`                                                 // 是否显示客服头像
+	OfflineMsg   string       `json:"offline_msg" binding:"max=255"`                               // 无客服在线时的提示
+	Attachments  bool         `json:"attachments"`                                                 // 是否允许访客发送附件
+	Sound        bool         `json:"sound"`                                                       // 新消息提示音
+	AutoOpen     AutoOpenRule `json:"auto_open"`                                                   // 自动打开规则
+}
+
+// AutoOpenRule 访客进入页面后自动打开聊天窗口的规则
+type AutoOpenRule struct {
+	Enabled        bool     `json:"enabled"`
+	DelaySeconds   int      `json:"delay_seconds" binding:"min=0,max=600"` // 进入页面后延迟打开
+	Paths          []string `json:"paths" binding:"max=20,dive,max=255"`   // 生效的页面路径前缀，为空表示全部页面
+	OncePerSession bool     `json:"once_per_session"`                      // 同一浏览器会话只自动打开一次
+}
+
+// DefaultWidgetConfig 新建业务的缺省组件设置
+func DefaultWidgetConfig() WidgetConfig {
+	return WidgetConfig{
+		ThemeColor:   "#2563eb",
+		Position:     WidgetPositionBottomRight,
+		LauncherText: "在线咨询",
+		ShowAvatar:   true,
+		Sound:        true,
+	}
+}
+
+// Value 以 json 字符串存储（按字段名更新时同样生效）
+func (w WidgetConfig) Value() (driver.Value, error) {
+	data, err := json.Marshal(w)
+	return string(data), err
+}
+
+// Scan 读取 json 字符串，历史业务为空时保持零值
+func (w *WidgetConfig) Scan(value any) error {
+	return scanJSON(value, w)
+}
+
+// Normalize 校验绑定标签无法表达的规则，并为空字段填充缺省值
+func (w *WidgetConfig) Normalize() error {
+	def := DefaultWidgetConfig()
+	if w.ThemeColor == "" {
+		w.ThemeColor = def.ThemeColor
+	}
+	w.ThemeColor = strings.ToLower(w.ThemeColor)
+	if w.Position == "" {
+		w.Position = def.Position
+	}
+	w.LauncherText = strings.TrimSpace(w.LauncherText)
+	if w.LauncherText == "" {
+		w.LauncherText = def.LauncherText
+	}
+	if w.AutoOpen.Paths == nil {
+		w.AutoOpen.Paths = []string{}
+	}
+	for i, path := range w.AutoOpen.Paths {
+		path = strings.TrimSpace(path)
+		if !strings.HasPrefix(path, "/") {
+			return ErrWidgetAutoOpenPath
+		}
+		w.AutoOpen.Paths[i] = path
+	}
+	return nil
+}
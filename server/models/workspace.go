@@ -0,0 +1,101 @@
+package models
+
+import (
+	"regexp"
+
+	"gorm.io/gorm"
+
+	"kefu-server/store"
+	"kefu-server/utils/logger"
+)
+
+// Workspace 工作区：一个实例中相互隔离的客户或品牌，拥有各自的业务、客服和安全设置
+// AppID 和用户名仍全局唯一（访客连接和登录时不带工作区），其余查询均按工作区过滤
+type Workspace struct {
+	gorm.Model
+	Name   string `gorm:"size:100;not null" json:"name"`
+	Slug   string `gorm:"size:64;uniqueIndex" json:"slug"` // 便于识别的唯一标识
+	Active bool   `gorm:"default:true" json:"active"`      // 停用后其客服无法登录，访客无法接入
+}
+
+// DefaultWorkspaceID 升级前的数据和单点登录自动创建的账号归属的工作区
+const DefaultWorkspaceID uint = 1
+
+// 工作区标识：2-64 位小写字母、数字或中划线
+var workspaceSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,63}$`)
+
+// IsValidWorkspaceSlug 工作区标识是否合法
+func IsValidWorkspaceSlug(slug string) bool {
+	return workspaceSlugPattern.MatchString(slug)
+}
+
+// InWorkspace 按工作区过滤的查询条件，用于 db.Scopes
+func InWorkspace(workspaceID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("workspace_id = ?", workspaceID)
+	}
+}
+
+// GetAppWorkspace 业务所属的工作区，已归档的业务同样适用
+func GetAppWorkspace(appID string) (uint, bool) {
+	var app App
+	if err := store.DB.Unscoped().Select("workspace_id").Where("app_id = ?", appID).First(&app).Error; err != nil {
+		return 0, false
+	}
+	return app.WorkspaceID, true
+}
+
+// IsWorkspaceActive 工作区是否存在且未停用
+func IsWorkspaceActive(workspaceID uint) bool {
+	var count int64
+	store.DB.Model(&Workspace{}).Where("id = ? AND active = ?", workspaceID, true).Count(&count)
+	return count > 0
+}
+
+// MigrateWorkspaces 创建缺省工作区并将升级前的数据归入其中
+// 实例中还没有超级管理员时，缺省工作区的管理员升级为超级管理员，保持单实例部署原有的管理能力
+func MigrateWorkspaces(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&Workspace{}).Where("id = ?", DefaultWorkspaceID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		workspace := Workspace{Model: gorm.Model{ID: DefaultWorkspaceID}, Name: "Default", Slug: "default", Active: true}
+		if err := db.Create(&workspace).Error; err != nil {
+			logger.Errorf("create default workspace failed: %v", err)
+			return err
+		}
+		logger.Infof("default workspace created")
+	}
+
+	for _, model := range []any{&App{}, &User{}, &AppTemplate{}, &AuditLog{}} {
+		if err := db.Unscoped().Model(model).Where("workspace_id IS NULL OR workspace_id = 0").
+			Update("workspace_id", DefaultWorkspaceID).Error; err != nil {
+			logger.Errorf("migrate workspace of %T failed: %v", model, err)
+			return err
+		}
+	}
+
+	// 模板名称改为在工作区内唯一
+	if db.Migrator().HasIndex(&AppTemplate{}, "idx_app_templates_name") {
+		if err := db.Migrator().DropIndex(&AppTemplate{}, "idx_app_templates_name"); err != nil {
+			logger.Errorf("drop app template name index failed: %v", err)
+			return err
+		}
+	}
+
+	if err := db.Model(&User{}).Where("role = ?", RoleSuperAdmin).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		result := db.Model(&User{}).Where("role = ? AND workspace_id = ?", RoleAdmin, DefaultWorkspaceID).Update("role", RoleSuperAdmin)
+		if result.Error != nil {
+			logger.Errorf("promote super admins failed: %v", result.Error)
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			logger.Warnf("promoted %d admins of the default workspace to super admin", result.RowsAffected)
+		}
+	}
+	return nil
+}
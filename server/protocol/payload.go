@@ -0,0 +1,178 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"unicode/utf8"
+
+	"kefu-server/models"
+)
+
+const (
+	maxTextLength  = 5000 // 文本消息最大字符数
+	maxURLLength   = 2048
+	maxNameLength  = 255
+	maxDuration    = 3600 // 语音最长秒数
+	maxPreChatSize = 50   // 咨询前表单最多字段数
+)
+
+// Hello 连接建立后推送的第一帧
+type Hello struct {
+	Version   int    `json:"version"`
+	SessionID string `json:"session_id,omitempty"` // 访客连接对应的会话
+	VisitorID string `json:"visitor_id,omitempty"`
+	AgentID   string `json:"agent_id,omitempty"`
+}
+
+// MessagePayload 访客或客服发送的消息内容
+type MessagePayload struct {
+	MsgType  string `json:"msg_type"` // text / image / audio / file
+	Content  string `json:"content,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Duration int    `json:"duration,omitempty"`
+}
+
+// Validate 按内容类型检查必填项和长度
+func (p *MessagePayload) Validate() error {
+	if utf8.RuneCountInString(p.Content) > maxTextLength {
+		return fmt.Errorf("content is too long, at most %d characters", maxTextLength)
+	}
+	if utf8.RuneCountInString(p.Name) > maxNameLength {
+		return fmt.Errorf("name is too long, at most %d characters", maxNameLength)
+	}
+	switch p.MsgType {
+	case models.MsgTypeText:
+		if p.Content == "" {
+			return errors.New("content is required for text messages")
+		}
+		return nil
+	case models.MsgTypeImage:
+		return validateMediaURL(p.URL)
+	case models.MsgTypeAudio:
+		if p.Duration <= 0 || p.Duration > maxDuration {
+			return fmt.Errorf("duration must be between 1 and %d seconds", maxDuration)
+		}
+		return validateMediaURL(p.URL)
+	case models.MsgTypeFile:
+		if p.Name == "" || p.Size <= 0 {
+			return errors.New("name and size are required for file messages")
+		}
+		return validateMediaURL(p.URL)
+	default:
+		return fmt.Errorf("unknown msg_type %q", p.MsgType)
+	}
+}
+
+// validateMediaURL 媒体地址须为 http(s) 绝对地址
+func validateMediaURL(raw string) error {
+	if raw == "" {
+		return errors.New("url is required")
+	}
+	if len(raw) > maxURLLength {
+		return fmt.Errorf("url is too long, at most %d characters", maxURLLength)
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https address")
+	}
+	return nil
+}
+
+// Message 推送给访客或客服的消息
+type Message struct {
+	ID      string `json:"id"`
+	From    string `json:"from"` // visitor / agent / system
+	AgentID string `json:"agent_id,omitempty"`
+	MessagePayload
+	Timestamp int64 `json:"timestamp"`
+}
+
+// NewMessage 转换保存的消息
+func NewMessage(msg *models.Message) Message {
+	return Message{
+		ID:      msg.MsgID,
+		From:    msg.From,
+		AgentID: msg.AgentID,
+		MessagePayload: MessagePayload{
+			MsgType:  msg.MsgType,
+			Content:  msg.Content,
+			URL:      msg.URL,
+			Name:     msg.Name,
+			Size:     msg.Size,
+			Duration: msg.Duration,
+		},
+		Timestamp: msg.Timestamp,
+	}
+}
+
+// ToModel 按发送方生成待保存的消息
+func (p *MessagePayload) ToModel(from string, timestamp int64) models.Message {
+	return models.Message{
+		From:      from,
+		MsgType:   p.MsgType,
+		Content:   p.Content,
+		URL:       p.URL,
+		Name:      p.Name,
+		Size:      p.Size,
+		Duration:  p.Duration,
+		Timestamp: timestamp,
+	}
+}
+
+// AgentMessage 推送给客服的访客消息，附带访客提交的咨询前表单和已验证的身份
+type AgentMessage struct {
+	Message  Message                 `json:"message"`
+	PreChat  map[string]string       `json:"pre_chat,omitempty"`
+	Identity *models.VisitorIdentity `json:"identity,omitempty"`
+}
+
+// PreChatPayload 咨询前表单 {字段: 值}
+type PreChatPayload map[string]string
+
+// Validate 只限制字段数，字段规则由业务的表单设置校验
+func (p *PreChatPayload) Validate() error {
+	if len(*p) > maxPreChatSize {
+		return fmt.Errorf("too many pre-chat fields, at most %d", maxPreChatSize)
+	}
+	return nil
+}
+
+// PresencePayload 客服切换在线状态，离线由断开连接决定
+type PresencePayload struct {
+	Status string `json:"status"`
+}
+
+// Validate 只接受 online / away / busy
+func (p *PresencePayload) Validate() error {
+	if status, ok := models.ParseUserStatus(p.Status); !ok || status == models.UserStatusOffline {
+		return fmt.Errorf("status must be online, away or busy")
+	}
+	return nil
+}
+
+// Presence 客服在线状态变化
+type Presence struct {
+	AgentID string `json:"agent_id"`
+	Status  string `json:"status"`
+}
+
+// Typing 对方正在输入
+type Typing struct {
+	From string `json:"from"` // visitor / agent
+}
+
+// SessionUpdate 会话状态变化
+type SessionUpdate struct {
+	Status  string `json:"status"`
+	AgentID string `json:"agent_id,omitempty"`
+}
+
+// VisitorToken 续期后的访客令牌
+type VisitorToken struct {
+	VisitorID    string `json:"visitor_id"`
+	VisitorToken string `json:"visitor_token"`
+	ExpiresAt    int64  `json:"expires_at"`
+}
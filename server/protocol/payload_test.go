@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+
+	"kefu-server/models"
+)
+
+func TestMessagePayloadValidate(t *testing.T) {
+	const media = "https://cdn.example.com/a.png"
+	tests := []struct {
+		name    string
+		payload MessagePayload
+		ok      bool
+	}{
+		{"text", MessagePayload{MsgType: "text", Content: "hi"}, true},
+		{"text max length", MessagePayload{MsgType: "text", Content: strings.Repeat("字", maxTextLength)}, true},
+		{"text too long", MessagePayload{MsgType: "text", Content: strings.Repeat("字", maxTextLength+1)}, false},
+		{"text empty", MessagePayload{MsgType: "text"}, false},
+		{"image", MessagePayload{MsgType: "image", URL: media}, true},
+		{"image http", MessagePayload{MsgType: "image", URL: "http://cdn.example.com/a.png"}, true},
+		{"image without url", MessagePayload{MsgType: "image"}, false},
+		{"image relative url", MessagePayload{MsgType: "image", URL: "/a.png"}, false},
+		{"image script url", MessagePayload{MsgType: "image", URL: "javascript:alert(1)"}, false},
+		{"image data url", MessagePayload{MsgType: "image", URL: "data:image/png;base64,AAAA"}, false},
+		{"image url too long", MessagePayload{MsgType: "image", URL: media + "?" + strings.Repeat("x", maxURLLength)}, false},
+		{"audio", MessagePayload{MsgType: "audio", URL: media, Duration: 5}, true},
+		{"audio max duration", MessagePayload{MsgType: "audio", URL: media, Duration: maxDuration}, true},
+		{"audio without duration", MessagePayload{MsgType: "audio", URL: media}, false},
+		{"audio too long", MessagePayload{MsgType: "audio", URL: media, Duration: maxDuration + 1}, false},
+		{"audio without url", MessagePayload{MsgType: "audio", Duration: 5}, false},
+		{"file", MessagePayload{MsgType: "file", URL: media, Name: "a.pdf", Size: 1024}, true},
+		{"file without name", MessagePayload{MsgType: "file", URL: media, Size: 1024}, false},
+		{"file without size", MessagePayload{MsgType: "file", URL: media, Name: "a.pdf"}, false},
+		{"file name too long", MessagePayload{MsgType: "file", URL: media, Name: strings.Repeat("a", maxNameLength+1), Size: 1}, false},
+		{"missing msg_type", MessagePayload{Content: "hi"}, false},
+		{"unknown msg_type", MessagePayload{MsgType: "video", URL: media}, false},
+	}
+	for _, tt := range tests {
+		err := tt.payload.Validate()
+		if tt.ok && err != nil {
+			t.Errorf("%s: Validate error: %v", tt.name, err)
+		} else if !tt.ok && err == nil {
+			t.Errorf("%s: Validate passed, want error", tt.name)
+		}
+	}
+}
+
+func TestPresencePayloadValidate(t *testing.T) {
+	tests := []struct {
+		status string
+		ok     bool
+	}{
+		{"online", true},
+		{"away", true},
+		{"busy", true},
+		{"offline", false},
+		{"", false},
+		{"ONLINE", false},
+	}
+	for _, tt := range tests {
+		p := PresencePayload{Status: tt.status}
+		if err := p.Validate(); (err == nil) != tt.ok {
+			t.Errorf("PresencePayload{%q}.Validate() = %v, want ok=%v", tt.status, err, tt.ok)
+		}
+	}
+}
+
+func TestMessageModel(t *testing.T) {
+	payload := MessagePayload{MsgType: "file", URL: "https://cdn.example.com/a.pdf", Name: "a.pdf", Size: 1024}
+	msg := payload.ToModel(models.MessageFromAgent, 1700000000)
+	msg.MsgID, msg.AgentID = "m:v:app:0000000001:0000000002", "bob"
+
+	got := NewMessage(&msg)
+	if got.ID != msg.MsgID || got.From != models.MessageFromAgent || got.AgentID != "bob" || got.Timestamp != 1700000000 {
+		t.Errorf("NewMessage = %+v", got)
+	}
+	if got.MessagePayload != payload {
+		t.Errorf("NewMessage payload = %+v, want %+v", got.MessagePayload, payload)
+	}
+}
@@ -0,0 +1,234 @@
+// Package protocol 访客（/ws/chat）和客服（/ws/agent）WebSocket 共用的消息协议
+//
+// 每一帧都是一个 json 信封 {type, id, session_id, payload}：
+//   - type       消息类型，见 Type* 常量，每个端点只接受自己的一组类型
+//   - id         客户端自定义的帧标识，出错时在错误帧的 ref 中原样带回
+//   - session_id 会话标识，客服帧必填，访客连接只对应一个会话因而不需要
+//   - payload    json 对象，结构由 type 决定
+//
+// 协议版本在建立连接时协商：客户端通过 WebSocket 子协议（kefu.v1）或查询参数 v 声明，
+// 服务端连接后首先推送 hello 帧告知采用的版本。
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"kefu-server/utils/logger"
+)
+
+const (
+	Version1       = 1
+	CurrentVersion = Version1
+
+	subprotocolPrefix = "kefu.v"
+
+	MaxFrameSize = 64 << 10 // 单帧最大字节数
+	maxFrameID   = 64
+)
+
+// SupportedVersions 服务端支持的协议版本，新版本在前
+var SupportedVersions = []int{Version1}
+
+// 消息类型
+const (
+	TypeHello = "hello" // 服务端 → 双方：连接建立，告知协议版本和会话
+	TypeError = "error" // 服务端 → 双方：帧被拒绝或处理失败
+
+	TypeMessageReq      = "message.req"       // 访客 → 服务端 → 客服：访客消息
+	TypeMessageRsp      = "message.rsp"       // 客服 → 服务端 → 访客：客服回复或自动回复
+	TypeTyping          = "typing.start"      // 双向：对方正在输入
+	TypeSessionClose    = "session.close"     // 访客或客服 → 服务端：结束会话
+	TypeSessionFollowUp = "session.follow_up" // 客服 → 服务端：标记待跟进
+	TypeSessionUpdate   = "session.update"    // 服务端 → 双方：会话状态变化
+
+	TypePreChatSubmit   = "prechat.submit"   // 访客 → 服务端：提交咨询前表单
+	TypePreChatAck      = "prechat.ack"      // 服务端 → 访客：表单已接收
+	TypePreChatRequired = "prechat.required" // 服务端 → 访客：需先填写表单才能分配客服
+
+	TypeVisitorToken = "visitor.token" // 服务端 → 访客：续期后的访客令牌
+
+	TypePresence    = "presence"     // 服务端 → 客服：客服在线状态变化
+	TypePresenceSet = "presence.set" // 客服 → 服务端：切换自己的在线状态
+)
+
+// Endpoint 接收帧的 WebSocket 端点
+type Endpoint int
+
+const (
+	EndpointChat  Endpoint = iota // 访客 /ws/chat
+	EndpointAgent                 // 客服 /ws/agent
+)
+
+// 错误码
+const (
+	ErrCodeBadFrame           = "bad_frame"           // 不是合法的 json 信封
+	ErrCodeUnknownType        = "unknown_type"        // 该端点不接受此类型
+	ErrCodeInvalidPayload     = "invalid_payload"     // payload 缺失或不符合结构
+	ErrCodeSessionNotFound    = "session_not_found"   // 会话不存在或不属于当前客服
+	ErrCodePreChatInvalid     = "prechat_invalid"     // 咨询前表单校验失败
+	ErrCodeUnsupportedVersion = "unsupported_version" // 协商时没有共同支持的版本
+	ErrCodeInternal           = "internal_error"
+)
+
+// Error 错误帧的 payload
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Ref     string `json:"ref,omitempty"` // 出错帧的 id
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// NewError 创建错误，ref 为出错帧的 id
+func NewError(code, ref, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...), Ref: ref}
+}
+
+// Frame 通过校验的入站帧，Payload 为该类型对应的结构指针，没有 payload 的类型为 nil
+type Frame struct {
+	Type      string
+	ID        string
+	SessionID string
+	Payload   any
+}
+
+// Envelope 出站帧
+type Envelope struct {
+	Type      string `json:"type"`
+	SessionID string `json:"session_id,omitempty"`
+	Payload   any    `json:"payload,omitempty"`
+}
+
+type inboundEnvelope struct {
+	Type      string          `json:"type"`
+	ID        string          `json:"id"`
+	SessionID string          `json:"session_id"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// frameSchema 入站帧的结构约束
+type frameSchema struct {
+	session bool       // 必须带 session_id
+	payload func() any // 返回 payload 结构的指针，为 nil 时忽略 payload
+}
+
+type validator interface {
+	Validate() error
+}
+
+var schemas = map[Endpoint]map[string]frameSchema{
+	EndpointChat: {
+		TypeMessageReq:    {payload: func() any { return new(MessagePayload) }},
+		TypeTyping:        {},
+		TypeSessionClose:  {},
+		TypePreChatSubmit: {payload: func() any { return new(PreChatPayload) }},
+	},
+	EndpointAgent: {
+		TypeMessageRsp:      {session: true, payload: func() any { return new(MessagePayload) }},
+		TypeTyping:          {session: true},
+		TypeSessionClose:    {session: true},
+		TypeSessionFollowUp: {session: true},
+		TypePresenceSet:     {payload: func() any { return new(PresencePayload) }},
+	},
+}
+
+// Decode 按端点的约束解析并校验入站帧
+func Decode(endpoint Endpoint, data []byte) (*Frame, *Error) {
+	var in inboundEnvelope
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, NewError(ErrCodeBadFrame, "", "frame must be a json object")
+	}
+	if len(in.ID) > maxFrameID {
+		return nil, NewError(ErrCodeBadFrame, "", "id is too long, at most %d characters", maxFrameID)
+	}
+	if in.Type == "" {
+		return nil, NewError(ErrCodeBadFrame, in.ID, "type is required")
+	}
+	schema, ok := schemas[endpoint][in.Type]
+	if !ok {
+		return nil, NewError(ErrCodeUnknownType, in.ID, "type %q is not accepted on this endpoint", in.Type)
+	}
+	if schema.session && in.SessionID == "" {
+		return nil, NewError(ErrCodeBadFrame, in.ID, "session_id is required for %s", in.Type)
+	}
+
+	frame := &Frame{Type: in.Type, ID: in.ID, SessionID: in.SessionID}
+	if schema.payload == nil {
+		return frame, nil
+	}
+	if len(in.Payload) == 0 || string(in.Payload) == "null" {
+		return nil, NewError(ErrCodeInvalidPayload, in.ID, "payload is required for %s", in.Type)
+	}
+	payload := schema.payload()
+	if err := json.Unmarshal(in.Payload, payload); err != nil {
+		return nil, NewError(ErrCodeInvalidPayload, in.ID, "invalid payload for %s: %v", in.Type, err)
+	}
+	if v, ok := payload.(validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, NewError(ErrCodeInvalidPayload, in.ID, "%v", err)
+		}
+	}
+	frame.Payload = payload
+	return frame, nil
+}
+
+// Encode 编码出站帧，payload 为 nil 时省略
+func Encode(msgType, sessionID string, payload any) []byte {
+	data, err := json.Marshal(Envelope{Type: msgType, SessionID: sessionID, Payload: payload})
+	if err != nil {
+		logger.Errorf("encode %s frame failed: %v", msgType, err)
+		return nil
+	}
+	return data
+}
+
+// Subprotocol 协议版本对应的 WebSocket 子协议名
+func Subprotocol(version int) string {
+	return subprotocolPrefix + strconv.Itoa(version)
+}
+
+// Negotiate 在升级连接前确定协议版本：优先按客户端提供的子协议选择双方都支持的最新版本，
+// 其次使用查询参数 v，都未提供时使用 Version1
+func Negotiate(r *http.Request) (int, *Error) {
+	var offered []string
+	for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				offered = append(offered, strings.ToLower(token))
+			}
+		}
+	}
+	if len(offered) > 0 {
+		for _, version := range SupportedVersions {
+			if slices.Contains(offered, Subprotocol(version)) {
+				return version, nil
+			}
+		}
+		return 0, NewError(ErrCodeUnsupportedVersion, "", "none of the offered subprotocols is supported: %s", strings.Join(offered, ", "))
+	}
+
+	if v := r.URL.Query().Get("v"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil || !slices.Contains(SupportedVersions, version) {
+			return 0, NewError(ErrCodeUnsupportedVersion, "", "protocol version %q is not supported", v)
+		}
+		return version, nil
+	}
+	return Version1, nil
+}
+
+// AppID 访客连接的业务标识：与 /config 等接口一致使用查询参数 appid，兼容早期按 app_id 接入的客户端
+func AppID(r *http.Request) string {
+	query := r.URL.Query()
+	if appID := query.Get("appid"); appID != "" {
+		return appID
+	}
+	return query.Get("app_id")
+}
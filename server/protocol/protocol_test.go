@@ -0,0 +1,200 @@
+package protocol
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint Endpoint
+		data     string
+		code     string // 期望的错误码，为空表示应当通过
+		ref      string // 错误帧带回的 id
+	}{
+		{"not json", EndpointChat, `hello`, ErrCodeBadFrame, ""},
+		{"not object", EndpointChat, `["message.req"]`, ErrCodeBadFrame, ""},
+		{"id too long", EndpointChat, `{"type":"typing.start","id":"` + strings.Repeat("x", maxFrameID+1) + `"}`, ErrCodeBadFrame, ""},
+		{"missing type", EndpointChat, `{"id":"1","payload":{"msg_type":"text","content":"hi"}}`, ErrCodeBadFrame, "1"},
+		{"empty type", EndpointChat, `{"type":"","id":"1"}`, ErrCodeBadFrame, "1"},
+		{"unknown type", EndpointChat, `{"type":"message.send","id":"2"}`, ErrCodeUnknownType, "2"},
+		{"agent type on chat", EndpointChat, `{"type":"message.rsp","id":"3","payload":{"msg_type":"text","content":"hi"}}`, ErrCodeUnknownType, "3"},
+		{"presence on chat", EndpointChat, `{"type":"presence.set","payload":{"status":"online"}}`, ErrCodeUnknownType, ""},
+		{"visitor type on agent", EndpointAgent, `{"type":"message.req","session_id":"s","payload":{"msg_type":"text","content":"hi"}}`, ErrCodeUnknownType, ""},
+		{"prechat on agent", EndpointAgent, `{"type":"prechat.submit","session_id":"s","payload":{}}`, ErrCodeUnknownType, ""},
+
+		// 客服帧必须带 session_id
+		{"agent message without session", EndpointAgent, `{"type":"message.rsp","id":"4","payload":{"msg_type":"text","content":"hi"}}`, ErrCodeBadFrame, "4"},
+		{"agent typing without session", EndpointAgent, `{"type":"typing.start"}`, ErrCodeBadFrame, ""},
+		{"agent close without session", EndpointAgent, `{"type":"session.close"}`, ErrCodeBadFrame, ""},
+		{"agent follow up without session", EndpointAgent, `{"type":"session.follow_up"}`, ErrCodeBadFrame, ""},
+
+		// message.req / message.rsp
+		{"message without payload", EndpointChat, `{"type":"message.req","id":"5"}`, ErrCodeInvalidPayload, "5"},
+		{"message null payload", EndpointChat, `{"type":"message.req","payload":null}`, ErrCodeInvalidPayload, ""},
+		{"message string payload", EndpointChat, `{"type":"message.req","id":"6","payload":"hello"}`, ErrCodeInvalidPayload, "6"},
+		{"message wrong field type", EndpointChat, `{"type":"message.req","payload":{"msg_type":"audio","url":"https://a.example/x.mp3","duration":"5"}}`, ErrCodeInvalidPayload, ""},
+		{"message empty text", EndpointChat, `{"type":"message.req","payload":{"msg_type":"text"}}`, ErrCodeInvalidPayload, ""},
+		{"message unknown msg_type", EndpointChat, `{"type":"message.req","payload":{"msg_type":"video","url":"https://a.example/x.mp4"}}`, ErrCodeInvalidPayload, ""},
+		{"message script url", EndpointChat, `{"type":"message.req","id":"7","payload":{"msg_type":"image","url":"javascript:alert(1)"}}`, ErrCodeInvalidPayload, "7"},
+		{"agent message empty text", EndpointAgent, `{"type":"message.rsp","session_id":"s","payload":{"msg_type":"text","content":""}}`, ErrCodeInvalidPayload, ""},
+		{"message text", EndpointChat, `{"type":"message.req","id":"8","payload":{"msg_type":"text","content":"hi"}}`, "", ""},
+		{"message image", EndpointChat, `{"type":"message.req","payload":{"msg_type":"image","url":"https://a.example/x.png"}}`, "", ""},
+		{"agent message text", EndpointAgent, `{"type":"message.rsp","session_id":"s","payload":{"msg_type":"text","content":"hi"}}`, "", ""},
+
+		// prechat.submit
+		{"prechat without payload", EndpointChat, `{"type":"prechat.submit"}`, ErrCodeInvalidPayload, ""},
+		{"prechat non string value", EndpointChat, `{"type":"prechat.submit","payload":{"age":18}}`, ErrCodeInvalidPayload, ""},
+		{"prechat too many fields", EndpointChat, `{"type":"prechat.submit","payload":{` + preChatFields(maxPreChatSize+1) + `}}`, ErrCodeInvalidPayload, ""},
+		{"prechat", EndpointChat, `{"type":"prechat.submit","payload":{` + preChatFields(maxPreChatSize) + `}}`, "", ""},
+
+		// presence.set
+		{"presence without payload", EndpointAgent, `{"type":"presence.set"}`, ErrCodeInvalidPayload, ""},
+		{"presence offline", EndpointAgent, `{"type":"presence.set","payload":{"status":"offline"}}`, ErrCodeInvalidPayload, ""},
+		{"presence unknown", EndpointAgent, `{"type":"presence.set","payload":{"status":"lunch"}}`, ErrCodeInvalidPayload, ""},
+		{"presence away", EndpointAgent, `{"type":"presence.set","payload":{"status":"away"}}`, "", ""},
+
+		// 没有 payload 的类型忽略 payload
+		{"typing", EndpointChat, `{"type":"typing.start"}`, "", ""},
+		{"typing with payload", EndpointChat, `{"type":"typing.start","payload":"ignored"}`, "", ""},
+		{"close", EndpointChat, `{"type":"session.close","id":"9"}`, "", ""},
+		{"agent follow up", EndpointAgent, `{"type":"session.follow_up","session_id":"s"}`, "", ""},
+	}
+	for _, tt := range tests {
+		frame, err := Decode(tt.endpoint, []byte(tt.data))
+		if tt.code != "" {
+			if err == nil {
+				t.Errorf("%s: Decode = %+v, want %s", tt.name, frame, tt.code)
+				continue
+			}
+			if err.Code != tt.code || err.Ref != tt.ref {
+				t.Errorf("%s: Decode error = %s (ref %q), want %s (ref %q)", tt.name, err, err.Ref, tt.code, tt.ref)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Decode error: %v", tt.name, err)
+		}
+	}
+}
+
+func preChatFields(n int) string {
+	fields := make([]string, n)
+	for i := range fields {
+		fields[i] = `"f` + strings.Repeat("x", i) + `":"v"`
+	}
+	return strings.Join(fields, ",")
+}
+
+func TestDecodeFrame(t *testing.T) {
+	frame, err := Decode(EndpointAgent, []byte(`{"type":"message.rsp","id":"a1","session_id":"m:v:app:0000000001","payload":{"msg_type":"text","content":"hi"}}`))
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if frame.Type != TypeMessageRsp || frame.ID != "a1" || frame.SessionID != "m:v:app:0000000001" {
+		t.Errorf("Decode = %+v", frame)
+	}
+	payload, ok := frame.Payload.(*MessagePayload)
+	if !ok || payload.MsgType != "text" || payload.Content != "hi" {
+		t.Errorf("Decode payload = %#v, want *MessagePayload", frame.Payload)
+	}
+
+	frame, err = Decode(EndpointChat, []byte(`{"type":"typing.start","payload":{"from":"visitor"}}`))
+	if err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if frame.Payload != nil {
+		t.Errorf("Decode typing payload = %#v, want nil", frame.Payload)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		msgType   string
+		sessionID string
+		payload   any
+		want      string
+	}{
+		{TypeTyping, "s", nil, `{"type":"typing.start","session_id":"s"}`},
+		{TypePreChatRequired, "", nil, `{"type":"prechat.required"}`},
+		{TypeError, "", NewError(ErrCodeUnknownType, "1", "type %q is not accepted", "x"), `{"type":"error","payload":{"code":"unknown_type","message":"type \"x\" is not accepted","ref":"1"}}`},
+		{TypeHello, "", Hello{Version: Version1, AgentID: "bob"}, `{"type":"hello","payload":{"version":1,"agent_id":"bob"}}`},
+	}
+	for _, tt := range tests {
+		if got := string(Encode(tt.msgType, tt.sessionID, tt.payload)); got != tt.want {
+			t.Errorf("Encode(%s) = %s, want %s", tt.msgType, got, tt.want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocols []string // Sec-WebSocket-Protocol 请求头，每项一行
+		query     string
+		want      int // 0 表示应当协商失败
+	}{
+		{"default", nil, "", Version1},
+		{"subprotocol", []string{"kefu.v1"}, "", Version1},
+		{"subprotocol case", []string{"KEFU.V1"}, "", Version1},
+		{"subprotocol list", []string{"kefu.v9, kefu.v1"}, "", Version1},
+		{"subprotocol headers", []string{"kefu.v9", "kefu.v1"}, "", Version1},
+		{"subprotocol over query", []string{"kefu.v1"}, "v=9", Version1},
+		{"query", nil, "v=1", Version1},
+		{"empty query", nil, "v=", Version1},
+
+		{"unsupported subprotocol", []string{"kefu.v9"}, "", 0},
+		{"foreign subprotocol", []string{"chat, superchat"}, "v=1", 0},
+		{"unsupported query", nil, "v=2", 0},
+		{"invalid query", nil, "v=abc", 0},
+		{"zero query", nil, "v=0", 0},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/ws/chat?"+tt.query, nil)
+		for _, value := range tt.protocols {
+			r.Header.Add("Sec-WebSocket-Protocol", value)
+		}
+		got, err := Negotiate(r)
+		if tt.want == 0 {
+			if err == nil {
+				t.Errorf("%s: Negotiate = %d, want error", tt.name, got)
+			} else if err.Code != ErrCodeUnsupportedVersion {
+				t.Errorf("%s: Negotiate error code = %s, want %s", tt.name, err.Code, ErrCodeUnsupportedVersion)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Negotiate error: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: Negotiate = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	if got := Subprotocol(Version1); got != "kefu.v1" {
+		t.Errorf("Subprotocol(1) = %q, want kefu.v1", got)
+	}
+}
+
+func TestAppID(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"appid=shop", "shop"},
+		{"app_id=shop", "shop"},
+		{"appid=shop&app_id=blog", "shop"},
+		{"appid=&app_id=blog", "blog"},
+		{"visitor_token=x", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/ws/chat?"+tt.query, nil)
+		if got := AppID(r); got != tt.want {
+			t.Errorf("AppID(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"kefu-server/controllers"
 	"kefu-server/middleware"
+	"kefu-server/models"
 )
 
 func SetupRouter() *gin.Engine {
@@ -17,12 +18,30 @@ func SetupRouter() *gin.Engine {
 	appController := &controllers.AppController{}
 	visitorController := &controllers.VisitorController{}
 	agentController := &controllers.AgentController{}
+	mfaController := &controllers.MFAController{}
+	oidcController := &controllers.OIDCController{}
+	apiKeyController := &controllers.APIKeyController{}
+	auditController := &controllers.AuditController{}
+	workspaceController := &controllers.WorkspaceController{}
+	sessionController := &controllers.SessionController{}
 	// API 路由组
 	api := r.Group("/api/v1")
 	{
 		// 不需要认证的路由
+		api.POST("/login/challenge", userController.LoginChallenge)
 		api.POST("/login", userController.Login)
+		api.POST("/login/mfa", mfaController.LoginVerify)
+		api.POST("/login/mfa/setup", mfaController.LoginSetup)
+		api.POST("/token/refresh", userController.RefreshToken)
+		api.POST("/password/reset", userController.CompletePasswordReset)
+		api.GET("/login/options", oidcController.LoginOptions)
+		api.GET("/oidc/login", oidcController.Login)
+		api.GET("/oidc/callback", oidcController.Callback)
+		api.POST("/oidc/exchange", oidcController.Exchange)
+		api.GET("/jwks", userController.GetJWKS)
 		api.GET("/config", appController.GetConfig)
+		api.POST("/prechat", visitorController.SubmitPreChat)
+		api.POST("/visitor/token", visitorController.IssueToken)
 
 		// 需要认证的路由
 		auth := api.Group("/")
@@ -30,24 +49,105 @@ func SetupRouter() *gin.Engine {
 		{
 			// 用户管理路由
 			auth.POST("/logout", userController.Logout)
+			auth.POST("/ws/ticket", middleware.RequirePermission(models.PermChat), agentController.WSTicket)
 			user := auth.Group("/user")
 			{
 				user.GET("/info", userController.GetUserInfo)
+				user.POST("/password", userController.ChangePassword)
+				user.PUT("/presence", middleware.RequirePermission(models.PermChat), agentController.SetPresence)
+				user.POST("/2fa/setup", mfaController.Setup)
+				user.POST("/2fa/confirm", mfaController.Confirm)
+				user.POST("/2fa/disable", mfaController.Disable)
+			}
+
+			// 客服管理路由
+			users := auth.Group("/users")
+			{
+				users.GET("/list", middleware.RequirePermission(models.PermUserRead), userController.ListUsers)
+				users.POST("/create", middleware.RequirePermission(models.PermUserWrite), userController.CreateUser)
+				users.PUT("/update", middleware.RequirePermission(models.PermUserWrite), userController.UpdateUser)
+				users.PUT("/active", middleware.RequirePermission(models.PermUserWrite), userController.SetUserActive)
+				users.DELETE("/delete", middleware.RequirePermission(models.PermUserWrite), userController.DeleteUser)
+				users.PUT("/apps", middleware.RequirePermission(models.PermUserWrite), userController.SetUserApps)
+				users.PUT("/password/reset", middleware.RequirePermission(models.PermUserWrite), userController.ResetPassword)
+				users.PUT("/2fa/reset", middleware.RequirePermission(models.PermSecurityManage), mfaController.ResetUser)
+			}
+
+			// 系统设置路由
+			settings := auth.Group("/settings", middleware.RequirePermission(models.PermSecurityManage))
+			{
+				settings.GET("/2fa", mfaController.GetPolicy)
+				settings.PUT("/2fa", mfaController.SetPolicy)
+				settings.GET("/password", userController.GetPasswordPolicy)
+				settings.PUT("/password", userController.SetPasswordPolicy)
+				settings.GET("/audit", auditController.GetRetention)
+				settings.PUT("/audit", middleware.RequirePermission(models.PermWorkspaceManage), auditController.SetRetention)
+			}
+
+			// 登录锁定管理路由
+			lockouts := auth.Group("/lockouts", middleware.RequirePermission(models.PermSecurityManage))
+			{
+				lockouts.GET("/list", userController.ListLockouts)
+				lockouts.DELETE("/clear", userController.ClearLockout)
 			}
 
 			// App 管理路由
 			app := auth.Group("/apps")
 			{
-				app.GET("/list", appController.GetApps)
-				app.POST("/create", appController.CreateApp)
-				app.PUT("/update", appController.UpdateApp)
-				app.DELETE("/delete", appController.DeleteApp)
+				app.GET("/list", middleware.RequirePermission(models.PermAppRead), appController.GetApps)
+				app.POST("/create", middleware.RequirePermission(models.PermAppWrite), appController.CreateApp)
+				app.PUT("/update", middleware.RequirePermission(models.PermAppWrite), appController.UpdateApp)
+				app.DELETE("/delete", middleware.RequirePermission(models.PermAppWrite), appController.DeleteApp)
+				app.PUT("/restore", middleware.RequirePermission(models.PermAppWrite), appController.RestoreApp)
+				app.POST("/purge", middleware.RequirePermission(models.PermAppWrite), appController.PurgeApp)
+				app.GET("/purge/status", middleware.RequirePermission(models.PermAppWrite), appController.GetPurgeStatus)
+				app.GET("/retention/status", middleware.RequirePermission(models.PermAppRead), appController.GetRetentionStatus)
+				app.GET("/identity", middleware.RequirePermission(models.PermAppWrite), appController.GetIdentitySecret)
+				app.POST("/identity/rotate", middleware.RequirePermission(models.PermAppWrite), appController.RotateIdentitySecret)
+				app.GET("/export", middleware.RequirePermission(models.PermAppRead), appController.ExportApp)
+				app.POST("/import", middleware.RequirePermission(models.PermAppWrite), appController.ImportApp)
+				app.GET("/templates/list", middleware.RequirePermission(models.PermAppRead), appController.ListTemplates)
+				app.POST("/templates/create", middleware.RequirePermission(models.PermAppWrite), appController.CreateTemplate)
+				app.PUT("/templates/update", middleware.RequirePermission(models.PermAppWrite), appController.UpdateTemplate)
+				app.DELETE("/templates/delete", middleware.RequirePermission(models.PermAppWrite), appController.DeleteTemplate)
+			}
+
+			// 会话与聊天记录
+			sessions := auth.Group("/sessions", middleware.RequirePermission(models.PermSessionRead))
+			{
+				sessions.GET("/list", sessionController.ListSessions)
+				sessions.GET("/messages", sessionController.ListMessages)
+				sessions.POST("/send", middleware.RequirePermission(models.PermMessageSend), sessionController.SendMessage)
+			}
+
+			// 客服在线状态
+			auth.GET("/presence/list", middleware.RequirePermission(models.PermUserRead), agentController.ListPresence)
+
+			// 审计日志
+			auth.GET("/audit", middleware.RequirePermission(models.PermAuditRead), auditController.ListLogs)
+
+			// API Key 管理路由
+			apiKeys := auth.Group("/apikeys", middleware.RequirePermission(models.PermSecurityManage))
+			{
+				apiKeys.GET("/list", apiKeyController.ListKeys)
+				apiKeys.POST("/create", apiKeyController.CreateKey)
+				apiKeys.POST("/rotate", apiKeyController.RotateKey)
+				apiKeys.DELETE("/revoke", apiKeyController.RevokeKey)
+			}
+
+			// 工作区管理路由（超级管理员）
+			workspaces := auth.Group("/workspaces", middleware.RequirePermission(models.PermWorkspaceManage))
+			{
+				workspaces.GET("/list", workspaceController.ListWorkspaces)
+				workspaces.POST("/create", workspaceController.CreateWorkspace)
+				workspaces.PUT("/update", workspaceController.UpdateWorkspace)
+				workspaces.DELETE("/delete", workspaceController.DeleteWorkspace)
 			}
 		}
 	}
 
 	r.GET("/ws/chat", visitorController.WSHandler)
-	r.GET("/ws/agent", middleware.AuthMiddleware(), agentController.WSHandler)
+	r.GET("/ws/agent", middleware.WSAuthMiddleware(), middleware.RequirePermission(models.PermChat), agentController.WSHandler)
 
 	return r
 }
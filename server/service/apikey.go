@@ -0,0 +1,155 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"kefu-server/models"
+	"kefu-server/store"
+	"kefu-server/utils"
+	"kefu-server/utils/logger"
+)
+
+const (
+	apiKeyPrefix        = "kf_"
+	apiKeyTouchInterval = time.Minute // 最近使用时间的最小更新间隔，避免每个请求都写库
+)
+
+var (
+	ErrAPIKeyInvalid = errors.New("api key invalid or revoked")
+)
+
+type APIKeyService struct {
+}
+
+var (
+	instAPIKeyService *APIKeyService
+)
+
+func GetAPIKeyService() *APIKeyService {
+	if instAPIKeyService == nil {
+		instAPIKeyService = &APIKeyService{}
+	}
+	return instAPIKeyService
+}
+
+// generateAPIKey 生成密钥明文及其摘要
+func generateAPIKey() (string, string) {
+	raw := apiKeyPrefix + utils.GenerateSecureToken(24)
+	return raw, hashAPIKey(raw)
+}
+
+func hashAPIKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateKey 创建 API Key，返回记录和仅此一次可见的明文
+func (as *APIKeyService) CreateKey(appID, name string, perms []models.Permission, createdBy string) (*models.APIKey, string, error) {
+	raw, hash := generateAPIKey()
+	key := models.APIKey{
+		Name:        name,
+		AppID:       appID,
+		Prefix:      raw[:len(apiKeyPrefix)+8],
+		KeyHash:     hash,
+		Permissions: perms,
+		CreatedBy:   createdBy,
+	}
+	if err := store.DB.Create(&key).Error; err != nil {
+		logger.Errorf("create api key failed: %v", err)
+		return nil, "", fmt.Errorf("create api key failed: %v", err)
+	}
+	return &key, raw, nil
+}
+
+// ListKeys 获取工作区的 API Key 列表（包括已吊销的），appID 为空则返回工作区内全部
+func (as *APIKeyService) ListKeys(workspaceID uint, appID string) ([]models.APIKey, error) {
+	apps := store.DB.Unscoped().Model(&models.App{}).Select("app_id").Scopes(models.InWorkspace(workspaceID))
+	query := store.DB.Model(&models.APIKey{}).Where("app_id IN (?)", apps)
+	if appID != "" {
+		query = query.Where("app_id = ?", appID)
+	}
+	var keys []models.APIKey
+	if err := query.Order("created_at DESC").Find(&keys).Error; err != nil {
+		logger.Errorf("list api keys failed: %v", err)
+		return nil, fmt.Errorf("list api keys failed: %v", err)
+	}
+	return keys, nil
+}
+
+func (as *APIKeyService) GetKey(id uint) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := store.DB.First(&key, id).Error; err != nil {
+		logger.Errorf("api key does not exist: %d", id)
+		return nil, fmt.Errorf("api key does not exist: %d", id)
+	}
+	return &key, nil
+}
+
+// RotateKey 为未吊销的 API Key 生成新密钥，旧密钥立即失效
+func (as *APIKeyService) RotateKey(id uint) (*models.APIKey, string, error) {
+	key, err := as.GetKey(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if key.RevokedAt != nil {
+		return nil, "", ErrAPIKeyInvalid
+	}
+
+	raw, hash := generateAPIKey()
+	now := time.Now()
+	updates := map[string]interface{}{
+		"key_hash":   hash,
+		"prefix":     raw[:len(apiKeyPrefix)+8],
+		"rotated_at": now,
+	}
+	if err := store.DB.Model(key).Updates(updates).Error; err != nil {
+		logger.Errorf("rotate api key %d failed: %v", id, err)
+		return nil, "", fmt.Errorf("rotate api key failed: %v", err)
+	}
+	key, err = as.GetKey(id)
+	return key, raw, err
+}
+
+// RevokeKey 吊销 API Key（保留记录以便审计）
+func (as *APIKeyService) RevokeKey(id uint) error {
+	key, err := as.GetKey(id)
+	if err != nil {
+		return err
+	}
+	if key.RevokedAt != nil {
+		return nil
+	}
+	if err := store.DB.Model(key).Update("revoked_at", time.Now()).Error; err != nil {
+		logger.Errorf("revoke api key %d failed: %v", id, err)
+		return fmt.Errorf("revoke api key failed: %v", err)
+	}
+	return nil
+}
+
+// Authenticate 校验请求携带的 API Key，所属业务必须存在且启用，并记录最近使用时间
+func (as *APIKeyService) Authenticate(raw, ip string) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := store.DB.Where("key_hash = ?", hashAPIKey(raw)).First(&key).Error; err != nil {
+		return nil, ErrAPIKeyInvalid
+	}
+	if key.RevokedAt != nil {
+		logger.Errorf("revoked api key used: %s", key.Prefix)
+		return nil, ErrAPIKeyInvalid
+	}
+	if models.GetApp(key.AppID) == nil {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval || key.LastUsedIP != ip {
+		updates := map[string]interface{}{"last_used_at": now, "last_used_ip": ip}
+		if err := store.DB.Model(&key).UpdateColumns(updates).Error; err != nil {
+			logger.Errorf("update api key last used failed: %v", err)
+		}
+	}
+	return &key, nil
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"kefu-server/models"
+	"kefu-server/store"
+	"kefu-server/utils/logger"
+)
+
+const auditPurgeInterval = 6 * time.Hour
+
+// 快照中不参与比较的字段
+var auditIgnoredFields = map[string]bool{"CreatedAt": true, "UpdatedAt": true, "DeletedAt": true}
+
+// AuditEntry 一条待记录的审计事件
+type AuditEntry struct {
+	Actor      string
+	ActorType  string
+	Action     string
+	TargetType string
+	TargetID   string
+	Before     interface{} // 变更前的对象，创建时为 nil
+	After      interface{} // 变更后的对象，删除时为 nil
+	IP         string
+	UserAgent  string
+
+	WorkspaceID uint // 操作所在的工作区
+}
+
+// AuditFilter 审计日志查询条件
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   string
+	From       *time.Time
+	To         *time.Time
+}
+
+type AuditService struct {
+}
+
+var (
+	instAuditService *AuditService
+)
+
+func GetAuditService() *AuditService {
+	if instAuditService == nil {
+		instAuditService = &AuditService{}
+	}
+	return instAuditService
+}
+
+// Record 记录审计日志，失败只写日志不影响业务操作
+func (as *AuditService) Record(entry AuditEntry) {
+	before, beforeMap := auditSnapshot(entry.Before)
+	after, afterMap := auditSnapshot(entry.After)
+
+	log := models.AuditLog{
+		Actor:      entry.Actor,
+		ActorType:  entry.ActorType,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		Before:     before,
+		After:      after,
+		Diff:       auditDiff(beforeMap, afterMap),
+		IP:         entry.IP,
+		UserAgent:  truncate(entry.UserAgent, 255),
+
+		WorkspaceID: entry.WorkspaceID,
+	}
+	if err := store.DB.Create(&log).Error; err != nil {
+		logger.Errorf("record audit log failed: %v, action: %s, target: %s", err, entry.Action, entry.TargetID)
+	}
+}
+
+// auditSnapshot 将对象序列化为 json 快照（json:"-" 的敏感字段不会出现）
+func auditSnapshot(v interface{}) (string, map[string]interface{}) {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return "", nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		logger.Errorf("marshal audit snapshot failed: %v", err)
+		return "", nil
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		// 非对象（如字符串数组）整体作为一个值比较
+		m = map[string]interface{}{"value": json.RawMessage(data)}
+	}
+	for field := range auditIgnoredFields {
+		delete(m, field)
+	}
+	data, _ = json.Marshal(m)
+	return string(data), m
+}
+
+// auditDiff 对比前后快照，返回变化字段
+func auditDiff(before, after map[string]interface{}) string {
+	diff := map[string]map[string]interface{}{}
+	for k, v := range after {
+		if old, ok := before[k]; !ok || !reflect.DeepEqual(old, v) {
+			diff[k] = map[string]interface{}{"from": before[k], "to": v}
+		}
+	}
+	for k, v := range before {
+		if _, ok := after[k]; !ok {
+			diff[k] = map[string]interface{}{"from": v, "to": nil}
+		}
+	}
+	if len(diff) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(diff)
+	return string(data)
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// ListLogs 分页查询工作区的审计日志，按时间倒序
+func (as *AuditService) ListLogs(workspaceID uint, filter AuditFilter, page, pageSize int) ([]models.AuditLog, int64, error) {
+	query := store.DB.Model(&models.AuditLog{}).Scopes(models.InWorkspace(workspaceID))
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query = query.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		logger.Errorf("count audit logs failed: %v", err)
+		return nil, 0, fmt.Errorf("count audit logs failed: %v", err)
+	}
+
+	var logs []models.AuditLog
+	offset := (page - 1) * pageSize
+	if err := query.Offset(offset).Limit(pageSize).Order("id DESC").Find(&logs).Error; err != nil {
+		logger.Errorf("list audit logs failed: %v", err)
+		return nil, 0, fmt.Errorf("list audit logs failed: %v", err)
+	}
+	return logs, total, nil
+}
+
+// RetentionDays 审计日志保留天数，0 表示永久保留
+func (as *AuditService) RetentionDays() int {
+	value := models.GetSetting(models.SettingAuditRetentionDays)
+	if value == "" {
+		return models.DefaultAuditRetentionDays
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		return models.DefaultAuditRetentionDays
+	}
+	return days
+}
+
+// SetRetentionDays 设置审计日志保留天数
+func (as *AuditService) SetRetentionDays(days int) error {
+	return models.SetSetting(models.SettingAuditRetentionDays, strconv.Itoa(days))
+}
+
+// Purge 删除超过保留期的审计日志
+func (as *AuditService) Purge() (int64, error) {
+	days := as.RetentionDays()
+	if days == 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+	result := store.DB.Where("created_at < ?", cutoff).Delete(&models.AuditLog{})
+	if result.Error != nil {
+		logger.Errorf("purge audit logs failed: %v", result.Error)
+		return 0, result.Error
+	}
+	if result.RowsAffected > 0 {
+		logger.Infof("purged %d audit logs older than %d days", result.RowsAffected, days)
+	}
+	return result.RowsAffected, nil
+}
+
+// StartRetentionJob 定期清理过期审计日志
+func (as *AuditService) StartRetentionJob(ctx context.Context) {
+	go func() {
+		as.Purge()
+		ticker := time.NewTicker(auditPurgeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				as.Purge()
+			}
+		}
+	}()
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"kefu-server/store"
+	"kefu-server/utils/logger"
+)
+
+// 登录失败计数与锁定
+// lf:user:{username} 按用户名统计
+// lf:ip:{ip}         按客户端 IP 统计
+// 连续失败超过阈值后锁定，锁定时长按 2 的幂次递增，上限 maxLockDuration
+
+const (
+	LockoutTypeUser = "user"
+	LockoutTypeIP   = "ip"
+
+	userFailureThreshold = 5  // 同一用户名允许的连续失败次数
+	ipFailureThreshold   = 20 // 同一 IP 允许的连续失败次数
+	baseLockDuration     = time.Minute
+	maxLockDuration      = time.Hour
+	failureRecordTTL     = 24 * time.Hour // 无新失败时计数自动清零
+	lockoutMaxConflict   = 5              // 与清除操作事务冲突时的最大重试次数
+)
+
+// Lockout 登录失败记录
+type Lockout struct {
+	Type        string `json:"type"`
+	Key         string `json:"key"`
+	Failures    int    `json:"failures"`
+	LastFailure int64  `json:"last_failure"`
+	LockedUntil int64  `json:"locked_until"`
+}
+
+// Locked 是否处于锁定中
+func (l *Lockout) Locked(now time.Time) bool {
+	return l.LockedUntil > now.Unix()
+}
+
+type LockoutService struct {
+	kv *badger.DB
+	mu sync.Mutex // 串行化本进程内的失败计数，事务冲突只会来自清除操作
+}
+
+var (
+	instLockoutService *LockoutService
+)
+
+func GetLockoutService() *LockoutService {
+	if instLockoutService != nil {
+		return instLockoutService
+	}
+
+	if kv := store.GetStore(); kv == nil { // 单例
+		logger.Errorf("kv is not initialized")
+		return nil
+	} else {
+		instLockoutService = &LockoutService{kv: kv}
+		return instLockoutService
+	}
+}
+
+func lockoutKey(typ, key string) string {
+	return "lf:" + typ + ":" + key
+}
+
+func (ls *LockoutService) get(typ, key string) (*Lockout, error) {
+	val, err := store.GetValue(lockoutKey(typ, key))
+	if err == badger.ErrKeyNotFound {
+		return &Lockout{Type: typ, Key: key}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var l Lockout
+	if err := json.Unmarshal(val, &l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// CheckLocked 检查用户名或 IP 是否被锁定，返回剩余锁定时间
+func (ls *LockoutService) CheckLocked(username, ip string) (time.Duration, error) {
+	now := time.Now()
+	var remaining time.Duration
+	for _, t := range [][2]string{{LockoutTypeUser, username}, {LockoutTypeIP, ip}} {
+		l, err := ls.get(t[0], t[1])
+		if err != nil {
+			logger.Errorf("get lockout %s:%s failed: %v", t[0], t[1], err)
+			return 0, err
+		}
+		if l.Locked(now) {
+			if d := time.Unix(l.LockedUntil, 0).Sub(now); d > remaining {
+				remaining = d
+			}
+		}
+	}
+	return remaining, nil
+}
+
+// RecordFailure 记录一次登录失败，超过阈值时按指数退避锁定
+func (ls *LockoutService) RecordFailure(username, ip string) {
+	ls.recordFailure(LockoutTypeUser, username, userFailureThreshold)
+	ls.recordFailure(LockoutTypeIP, ip, ipFailureThreshold)
+}
+
+// recordFailure 在同一事务内读取、累加并写回失败计数，并发失败冲突时重试，避免相互覆盖导致少计
+func (ls *LockoutService) recordFailure(typ, key string, threshold int) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	for attempt := 0; ; attempt++ {
+		err := ls.kv.Update(func(txn *badger.Txn) error {
+			l := &Lockout{Type: typ, Key: key}
+			item, err := txn.Get([]byte(lockoutKey(typ, key)))
+			if err == nil {
+				err = item.Value(func(val []byte) error {
+					return json.Unmarshal(val, l)
+				})
+			}
+			if err != nil && err != badger.ErrKeyNotFound {
+				return err
+			}
+
+			now := time.Now()
+			l.Failures++
+			l.LastFailure = now.Unix()
+			if l.Failures >= threshold {
+				lock := baseLockDuration << min(l.Failures-threshold, 10)
+				if lock > maxLockDuration {
+					lock = maxLockDuration
+				}
+				l.LockedUntil = now.Add(lock).Unix()
+				logger.Warnf("login locked for %s %s: %d failures, %s", typ, key, l.Failures, lock)
+			}
+
+			data, _ := json.Marshal(l)
+			return txn.SetEntry(badger.NewEntry([]byte(lockoutKey(typ, key)), data).WithTTL(failureRecordTTL))
+		})
+		if errors.Is(err, badger.ErrConflict) && attempt < lockoutMaxConflict {
+			continue
+		}
+		if err != nil {
+			logger.Errorf("record lockout %s:%s failed: %v", typ, key, err)
+		}
+		return
+	}
+}
+
+// RecordSuccess 登录成功后清除该用户名的失败计数
+func (ls *LockoutService) RecordSuccess(username string) {
+	if err := store.DeleteKey(lockoutKey(LockoutTypeUser, username)); err != nil {
+		logger.Errorf("clear lockout for user %s failed: %v", username, err)
+	}
+}
+
+// ListLockouts 列出失败记录，lockedOnly 为 true 时只返回锁定中的记录
+func (ls *LockoutService) ListLockouts(lockedOnly bool) ([]*Lockout, error) {
+	now := time.Now()
+	lockouts := []*Lockout{}
+	err := store.ScanPrefix("lf:", func(key string, value []byte) error {
+		var l Lockout
+		if err := json.Unmarshal(value, &l); err != nil {
+			return nil
+		}
+		if lockedOnly && !l.Locked(now) {
+			return nil
+		}
+		lockouts = append(lockouts, &l)
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("list lockouts failed: %v", err)
+		return nil, err
+	}
+	return lockouts, nil
+}
+
+// ClearLockout 解除锁定并清零失败计数
+func (ls *LockoutService) ClearLockout(typ, key string) error {
+	if typ != LockoutTypeUser && typ != LockoutTypeIP {
+		return fmt.Errorf("invalid lockout type: %s", typ)
+	}
+	if strings.TrimSpace(key) == "" {
+		return fmt.Errorf("lockout key is empty")
+	}
+	if err := store.DeleteKey(lockoutKey(typ, key)); err != nil {
+		logger.Errorf("clear lockout %s:%s failed: %v", typ, key, err)
+		return err
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -53,12 +54,11 @@ func (m *MessageService) SaveMessage(visitorID, appID string, sessionSeq uint32,
 	msg.MsgID = msgID
 	data, _ := json.Marshal(msg)
 
-	// 设置 TTL：30 天
-	ttl := 30 * 24 * time.Hour
+	// 按业务保留策略自发送时间起过期
+	expiresAt := models.GetRetention(appID).ExpiresAt(time.Unix(msg.Timestamp, 0))
 
 	err = m.kv.Update(func(txn *badger.Txn) error {
-		entry := badger.NewEntry([]byte(msgID), data).WithTTL(ttl)
-		return txn.SetEntry(entry)
+		return txn.SetEntry(retentionEntry(msgID, data, expiresAt))
 	})
 	if err != nil {
 		logger.Errorf("badger.Update %v ", err)
@@ -81,40 +81,46 @@ func (m *MessageService) GetMessage(msgID string) (*models.Message, error) {
 		logger.Errorf("read msg %s failed %v ", msgID, err)
 		return nil, err
 	}
+	msg.UpgradeLegacy()
 	return &msg, err
 }
 
-// GetMessagesBySession 获取某会话的消息列表（按时间正序）
-func (m *MessageService) GetMessagesBySession(sessionID string, limit int) ([]*models.Message, error) {
+// GetMessagesBySession 获取某会话的消息列表（按时间正序），before 为消息 id 时只返回其之前的消息
+func (m *MessageService) GetMessagesBySession(sessionID, before string, limit int) ([]*models.Message, error) {
 	if limit <= 0 || limit > 100 { // 防止滥用
 		limit = 50
 	}
 
-	// 1. 解析 sessionID: s:{visitor}:{app}:{session_seq}
-	parts := strings.Split(sessionID, ":")
-	if len(parts) != 4 || parts[0] != "s" {
+	// 1. 会话 id 为 m:{visitor}:{app}:{session_seq}，其消息键以 "{会话 id}:" 为前缀
+	if visitorID, _, _ := models.ParseSessionID(sessionID); visitorID == "" {
 		return nil, fmt.Errorf("invalid sessionID format: %s", sessionID)
 	}
-	visitorID := parts[1]
-	appID := parts[2]
-	sessionSeqStr := parts[3]
-
-	// 2. 构造消息 key 前缀: m:{visitor}:{app}:{session_seq}:
-	msgPrefix := fmt.Sprintf("m:%s:%s:%s:", visitorID, appID, sessionSeqStr)
+	msgPrefix := []byte(sessionID + ":")
+	if before != "" && !strings.HasPrefix(before, string(msgPrefix)) {
+		return nil, fmt.Errorf("message %s is not in session %s", before, sessionID)
+	}
 
 	var msgs []*models.Message
 
 	err := m.kv.View(func(txn *badger.Txn) error {
 		opts := badger.IteratorOptions{
-			Prefix:  []byte(msgPrefix),
+			Prefix:  msgPrefix,
 			Reverse: true, // 从最新消息开始扫
 		}
 		it := txn.NewIterator(opts)
 		defer it.Close()
 
+		// 反向遍历需从前缀之后（或游标消息）开始
+		start := append(msgPrefix, 0xFF)
+		if before != "" {
+			start = []byte(before)
+		}
 		count := 0
-		for it.Rewind(); it.Valid() && count < limit; it.Next() {
+		for it.Seek(start); it.Valid() && count < limit; it.Next() {
 			item := it.Item()
+			if before != "" && string(item.Key()) == before {
+				continue
+			}
 			val, err := item.ValueCopy(nil)
 			if err != nil {
 				continue
@@ -123,6 +129,7 @@ func (m *MessageService) GetMessagesBySession(sessionID string, limit int) ([]*m
 			if err := json.Unmarshal(val, &msg); err != nil {
 				continue
 			}
+			msg.UpgradeLegacy()
 			msgs = append(msgs, &msg)
 			count++
 		}
@@ -134,10 +141,8 @@ func (m *MessageService) GetMessagesBySession(sessionID string, limit int) ([]*m
 		return nil, err
 	}
 
-	// 3. 反转，使结果为时间正序（旧 → 新）
-	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
-		msgs[i], msgs[j] = msgs[j], msgs[i]
-	}
+	// 2. 反转，使结果为时间正序（旧 → 新）
+	slices.Reverse(msgs)
 
 	return msgs, nil
 }
@@ -0,0 +1,277 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"kefu-server/models"
+	"kefu-server/store"
+	"kefu-server/utils"
+	"kefu-server/utils/logger"
+)
+
+// 两步验证（TOTP）
+// mfa:{token}             登录第二步的挑战，记录用户 ID 和已失败次数，TTL 为 MFAChallengeTTL
+// totp:{user_id}:{step}   已使用过的时间步，防止同一验证码被重复使用
+
+const (
+	MFAChallengeTTL    = 5 * time.Minute
+	mfaMaxAttempts     = 5 // 单个挑战允许的错误次数
+	mfaMaxConflict     = 5 // 并发失败事务冲突时的最大重试次数
+	totpIssuer         = "kefu"
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+var (
+	ErrMFAChallengeInvalid = errors.New("mfa challenge invalid or expired")
+	ErrMFACodeInvalid      = errors.New("mfa code invalid")
+	ErrMFANotPending       = errors.New("mfa setup not started")
+)
+
+// MFAChallenge 登录第二步挑战
+type MFAChallenge struct {
+	UserID   uint `json:"user_id"`
+	Attempts int  `json:"attempts"`
+}
+
+type MFAService struct {
+	kv *badger.DB
+}
+
+var (
+	instMFAService *MFAService
+)
+
+func GetMFAService() *MFAService {
+	if instMFAService != nil {
+		return instMFAService
+	}
+
+	if kv := store.GetStore(); kv == nil { // 单例
+		logger.Errorf("kv is not initialized")
+		return nil
+	} else {
+		instMFAService = &MFAService{kv: kv}
+		return instMFAService
+	}
+}
+
+// RequiredRoles 获取工作区强制两步验证的角色
+func (ms *MFAService) RequiredRoles(workspaceID uint) []string {
+	roles := []string{}
+	if value := models.GetWorkspaceSetting(workspaceID, models.SettingRequire2FARoles); value != "" {
+		json.Unmarshal([]byte(value), &roles)
+	}
+	return roles
+}
+
+// SetRequiredRoles 设置工作区强制两步验证的角色
+func (ms *MFAService) SetRequiredRoles(workspaceID uint, roles []string) error {
+	data, _ := json.Marshal(roles)
+	return models.SetWorkspaceSetting(workspaceID, models.SettingRequire2FARoles, string(data))
+}
+
+// IsRequired 该用户登录是否需要两步验证（已启用，或其角色被强制要求）
+func (ms *MFAService) IsRequired(user *models.User) bool {
+	return user.TOTPEnabled || slices.Contains(ms.RequiredRoles(user.WorkspaceID), user.Role)
+}
+
+// BeginSetup 生成待确认的 TOTP 密钥，返回密钥和 otpauth URI
+func (ms *MFAService) BeginSetup(user *models.User) (string, string, error) {
+	secret := utils.GenerateTOTPSecret()
+	if err := store.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("totp_secret", secret).Error; err != nil {
+		logger.Errorf("save totp secret for user %s failed: %v", user.Username, err)
+		return "", "", err
+	}
+	user.TOTPSecret = secret
+	return secret, utils.TOTPProvisioningURI(totpIssuer, user.Username, secret), nil
+}
+
+// ConfirmSetup 用验证码确认待启用的密钥，启用两步验证并返回一次性恢复码
+func (ms *MFAService) ConfirmSetup(user *models.User, code string) ([]string, error) {
+	if user.TOTPEnabled || user.TOTPSecret == "" {
+		return nil, ErrMFANotPending
+	}
+	if !ms.verifyTOTP(user, code) {
+		return nil, ErrMFACodeInvalid
+	}
+
+	codes, hashes := generateRecoveryCodes()
+	data, _ := json.Marshal(hashes)
+	updates := map[string]interface{}{
+		"totp_enabled":   true,
+		"recovery_codes": string(data),
+	}
+	if err := store.DB.Model(&models.User{}).Where("id = ?", user.ID).Updates(updates).Error; err != nil {
+		logger.Errorf("enable totp for user %s failed: %v", user.Username, err)
+		return nil, err
+	}
+	user.TOTPEnabled = true
+	user.RecoveryCodes = string(data)
+	logger.Infof("totp enabled for user %s", user.Username)
+	return codes, nil
+}
+
+// Verify 校验验证码或恢复码（恢复码使用后作废）
+func (ms *MFAService) Verify(user *models.User, code string) bool {
+	if !user.TOTPEnabled {
+		return false
+	}
+	code = strings.TrimSpace(code)
+	if ms.verifyTOTP(user, code) {
+		return true
+	}
+	return ms.useRecoveryCode(user, code)
+}
+
+// Disable 关闭两步验证并清除密钥和恢复码
+func (ms *MFAService) Disable(user *models.User) error {
+	updates := map[string]interface{}{
+		"totp_enabled":   false,
+		"totp_secret":    "",
+		"recovery_codes": "",
+	}
+	if err := store.DB.Model(&models.User{}).Where("id = ?", user.ID).Updates(updates).Error; err != nil {
+		logger.Errorf("disable totp for user %s failed: %v", user.Username, err)
+		return err
+	}
+	logger.Infof("totp disabled for user %s", user.Username)
+	return nil
+}
+
+// verifyTOTP 校验 TOTP 验证码，同一时间步的验证码只能使用一次
+func (ms *MFAService) verifyTOTP(user *models.User, code string) bool {
+	step, ok := utils.VerifyTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false
+	}
+	fresh, err := store.SetIfAbsent(fmt.Sprintf("totp:%d:%d", user.ID, step), []byte("1"), 3*time.Minute)
+	if err != nil {
+		logger.Errorf("record totp step failed: %v", err)
+		return false
+	}
+	return fresh
+}
+
+func (ms *MFAService) useRecoveryCode(user *models.User, code string) bool {
+	var hashes []string
+	if err := json.Unmarshal([]byte(user.RecoveryCodes), &hashes); err != nil {
+		return false
+	}
+	hash := hashRecoveryCode(code)
+	idx := slices.Index(hashes, hash)
+	if idx < 0 {
+		return false
+	}
+
+	// 仅当恢复码列表仍是读取时的内容才写回，并发使用同一恢复码时只有一个请求成功
+	hashes = slices.Delete(hashes, idx, idx+1)
+	data, _ := json.Marshal(hashes)
+	result := store.DB.Model(&models.User{}).
+		Where("id = ? AND recovery_codes = ?", user.ID, user.RecoveryCodes).
+		Update("recovery_codes", string(data))
+	if result.Error != nil {
+		logger.Errorf("consume recovery code for user %s failed: %v", user.Username, result.Error)
+		return false
+	}
+	if result.RowsAffected == 0 {
+		logger.Warnf("recovery codes of user %s changed concurrently", user.Username)
+		return false
+	}
+	user.RecoveryCodes = string(data)
+	logger.Warnf("recovery code used by user %s, %d remaining", user.Username, len(hashes))
+	return true
+}
+
+// generateRecoveryCodes 生成恢复码及其摘要
+func generateRecoveryCodes() ([]string, []string) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := utils.GenerateSecureToken(recoveryCodeLength / 2)
+		code := raw[:recoveryCodeLength/2] + "-" + raw[recoveryCodeLength/2:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueChallenge 密码校验通过后签发登录第二步挑战
+func (ms *MFAService) IssueChallenge(userID uint) (string, error) {
+	token := utils.GenerateSecureToken(32)
+	data, _ := json.Marshal(MFAChallenge{UserID: userID})
+	if err := store.SetWithTTL("mfa:"+token, data, MFAChallengeTTL); err != nil {
+		logger.Errorf("save mfa challenge failed: %v", err)
+		return "", err
+	}
+	return token, nil
+}
+
+// GetChallenge 读取登录第二步挑战
+func (ms *MFAService) GetChallenge(token string) (*MFAChallenge, error) {
+	val, err := store.GetValue("mfa:" + token)
+	if err != nil {
+		return nil, ErrMFAChallengeInvalid
+	}
+	var challenge MFAChallenge
+	if err := json.Unmarshal(val, &challenge); err != nil {
+		return nil, ErrMFAChallengeInvalid
+	}
+	return &challenge, nil
+}
+
+// FailChallenge 记录一次验证失败，超过次数后挑战作废
+// 在同一事务内读取、累加并写回失败次数，并发失败冲突时重试，避免相互覆盖导致少计
+func (ms *MFAService) FailChallenge(token string) {
+	key := []byte("mfa:" + token)
+	for attempt := 0; ; attempt++ {
+		err := ms.kv.Update(func(txn *badger.Txn) error {
+			item, err := txn.Get(key)
+			if err != nil {
+				return err
+			}
+			var challenge MFAChallenge
+			if err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &challenge)
+			}); err != nil {
+				return err
+			}
+
+			challenge.Attempts++
+			if challenge.Attempts >= mfaMaxAttempts {
+				return txn.Delete(key)
+			}
+			data, _ := json.Marshal(challenge)
+			entry := badger.NewEntry(key, data)
+			entry.ExpiresAt = item.ExpiresAt()
+			return txn.SetEntry(entry)
+		})
+		if errors.Is(err, badger.ErrConflict) && attempt < mfaMaxConflict {
+			continue
+		}
+		if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			logger.Errorf("update mfa challenge failed: %v", err)
+		}
+		return
+	}
+}
+
+// CompleteChallenge 验证通过后作废挑战
+func (ms *MFAService) CompleteChallenge(token string) {
+	store.DeleteKey("mfa:" + token)
+}
@@ -0,0 +1,531 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/golang-jwt/jwt/v5"
+
+	"kefu-server/config"
+	"kefu-server/models"
+	"kefu-server/store"
+	"kefu-server/utils"
+	"kefu-server/utils/logger"
+)
+
+// OpenID Connect 单点登录（授权码 + PKCE）
+// oidc:state:{state}  发起登录时生成的 state，记录 nonce 和 code_verifier，TTL 为 OIDCStateTTL
+// oidc:code:{code}    回调完成后交给前端的一次性登录码，换取本系统令牌，TTL 为 oidcLoginCodeTTL
+
+const (
+	OIDCStateTTL     = 10 * time.Minute
+	oidcLoginCodeTTL = time.Minute
+	oidcHTTPTimeout  = 10 * time.Second
+	oidcJWKSMinAge   = time.Minute // kid 未命中时重新拉取 JWKS 的最小间隔
+)
+
+var (
+	ErrOIDCStateInvalid = errors.New("oidc state invalid or expired")
+	ErrOIDCTokenInvalid = errors.New("oidc id token invalid")
+	ErrOIDCRoleUnmapped = errors.New("oidc user has no mapped role")
+	ErrOIDCUserConflict = errors.New("username already used by a local account")
+	ErrOIDCUserDisabled = errors.New("user is disabled")
+	ErrOIDCCodeInvalid  = errors.New("oidc login code invalid or expired")
+)
+
+// 多个 IdP 角色映射到不同本地角色时，取排在前面的
+var oidcRolePriority = []string{models.RoleAdmin, models.RoleSupervisor, models.RoleAgent, models.RoleAnalyst}
+
+// OIDCState 发起登录时保存的校验信息
+type OIDCState struct {
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcTokenResponse struct {
+	IDToken     string `json:"id_token"`
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+}
+
+type OIDCService struct {
+	kv     *badger.DB
+	cfg    config.OIDCConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey
+	keysAt    time.Time
+}
+
+var (
+	instOIDCService *OIDCService
+)
+
+// GetOIDCService 未启用单点登录时返回 nil
+func GetOIDCService() *OIDCService {
+	if instOIDCService != nil {
+		return instOIDCService
+	}
+	if config.AppConfig == nil || !config.AppConfig.OIDC.Enabled {
+		return nil
+	}
+
+	if kv := store.GetStore(); kv == nil { // 单例
+		logger.Errorf("kv is not initialized")
+		return nil
+	} else {
+		instOIDCService = &OIDCService{
+			kv:     kv,
+			cfg:    config.AppConfig.OIDC,
+			client: &http.Client{Timeout: oidcHTTPTimeout},
+		}
+		return instOIDCService
+	}
+}
+
+// OIDCStateBinding state 的摘要，发起登录时写入浏览器 Cookie，回调时据此确认是同一浏览器发起的登录
+func OIDCStateBinding(state string) string {
+	sum := sha256.Sum256([]byte("oidc-state:" + state))
+	return hex.EncodeToString(sum[:])
+}
+
+// AuthURL 生成 state、nonce 和 PKCE code_verifier，返回 IdP 授权地址和 state 的绑定值（见 OIDCStateBinding）
+func (oc *OIDCService) AuthURL(ctx context.Context) (string, string, error) {
+	disc, err := oc.getDiscovery(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state := utils.GenerateSecureToken(16)
+	st := OIDCState{
+		Nonce:    utils.GenerateSecureToken(16),
+		Verifier: utils.GenerateSecureToken(32), // 64 位十六进制，符合 RFC 7636 长度要求
+	}
+	data, _ := json.Marshal(st)
+	if err := store.SetWithTTL("oidc:state:"+state, data, OIDCStateTTL); err != nil {
+		logger.Errorf("save oidc state failed: %v", err)
+		return "", "", err
+	}
+
+	challenge := sha256.Sum256([]byte(st.Verifier))
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", oc.cfg.ClientID)
+	q.Set("redirect_uri", oc.cfg.RedirectURL)
+	q.Set("scope", strings.Join(oc.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", st.Nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(disc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return disc.AuthorizationEndpoint + sep + q.Encode(), OIDCStateBinding(state), nil
+}
+
+// Callback 处理 IdP 回调：校验 state 及其浏览器绑定、用授权码换取 ID Token 并校验，返回对应的本地用户
+// binding 须与发起登录时的 OIDCStateBinding 一致，防止攻击者把自己的授权回调交给受害者完成（登录 CSRF）
+func (oc *OIDCService) Callback(ctx context.Context, code, state, binding string) (*models.User, error) {
+	if subtle.ConstantTimeCompare([]byte(binding), []byte(OIDCStateBinding(state))) != 1 {
+		return nil, ErrOIDCStateInvalid
+	}
+	val, err := store.TakeValue("oidc:state:" + state)
+	if err != nil {
+		return nil, ErrOIDCStateInvalid
+	}
+	var st OIDCState
+	if err := json.Unmarshal(val, &st); err != nil {
+		return nil, ErrOIDCStateInvalid
+	}
+
+	tokens, err := oc.exchangeCode(ctx, code, st.Verifier)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := oc.verifyIDToken(ctx, tokens.IDToken, st.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	return oc.provisionUser(claims)
+}
+
+// IssueLoginCode 签发一次性登录码，前端凭此换取令牌（避免令牌出现在跳转地址中）
+func (oc *OIDCService) IssueLoginCode(userID uint) (string, error) {
+	code := utils.GenerateSecureToken(32)
+	if err := store.SetWithTTL("oidc:code:"+code, []byte(fmt.Sprint(userID)), oidcLoginCodeTTL); err != nil {
+		logger.Errorf("save oidc login code failed: %v", err)
+		return "", err
+	}
+	return code, nil
+}
+
+// TakeLoginCode 消费一次性登录码，返回用户 ID
+func (oc *OIDCService) TakeLoginCode(code string) (uint, error) {
+	val, err := store.TakeValue("oidc:code:" + code)
+	if err != nil {
+		return 0, ErrOIDCCodeInvalid
+	}
+	var userID uint
+	if _, err := fmt.Sscan(string(val), &userID); err != nil {
+		return 0, ErrOIDCCodeInvalid
+	}
+	return userID, nil
+}
+
+// FrontendURL 登录完成后跳转的前端地址
+func (oc *OIDCService) FrontendURL() string {
+	return oc.cfg.FrontendURL
+}
+
+func (oc *OIDCService) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	if oc.discovery != nil {
+		return oc.discovery, nil
+	}
+
+	var disc oidcDiscovery
+	wellKnown := strings.TrimSuffix(oc.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := oc.getJSON(ctx, wellKnown, &disc); err != nil {
+		logger.Errorf("oidc discovery failed: %v", err)
+		return nil, err
+	}
+	if disc.Issuer != oc.cfg.Issuer {
+		logger.Errorf("oidc issuer mismatch: configured %s, discovered %s", oc.cfg.Issuer, disc.Issuer)
+		return nil, fmt.Errorf("oidc issuer mismatch")
+	}
+	if disc.AuthorizationEndpoint == "" || disc.TokenEndpoint == "" || disc.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery document incomplete")
+	}
+	oc.discovery = &disc
+	return oc.discovery, nil
+}
+
+func (oc *OIDCService) exchangeCode(ctx context.Context, code, verifier string) (*oidcTokenResponse, error) {
+	disc, err := oc.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", oc.cfg.RedirectURL)
+	form.Set("client_id", oc.cfg.ClientID)
+	form.Set("code_verifier", verifier)
+	if oc.cfg.ClientSecret != "" {
+		form.Set("client_secret", oc.cfg.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, disc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := oc.client.Do(req)
+	if err != nil {
+		logger.Errorf("oidc token request failed: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		logger.Errorf("oidc token endpoint returned %d: %s", resp.StatusCode, body)
+		return nil, fmt.Errorf("oidc token endpoint returned %d", resp.StatusCode)
+	}
+
+	var tokens oidcTokenResponse
+	if err := json.Unmarshal(body, &tokens); err != nil || tokens.IDToken == "" {
+		logger.Errorf("oidc token response has no id_token")
+		return nil, ErrOIDCTokenInvalid
+	}
+	return &tokens, nil
+}
+
+func (oc *OIDCService) verifyIDToken(ctx context.Context, raw, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return oc.getKey(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "EdDSA"}),
+		jwt.WithIssuer(oc.cfg.Issuer),
+		jwt.WithAudience(oc.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30*time.Second),
+	)
+	if err != nil {
+		logger.Errorf("oidc id token verification failed: %v", err)
+		return nil, ErrOIDCTokenInvalid
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		logger.Errorf("oidc id token nonce mismatch")
+		return nil, ErrOIDCTokenInvalid
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		logger.Errorf("oidc id token has no sub")
+		return nil, ErrOIDCTokenInvalid
+	}
+	return claims, nil
+}
+
+// getKey 按 kid 查找 IdP 公钥，未命中时重新拉取 JWKS（支持 IdP 轮换密钥）
+func (oc *OIDCService) getKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	disc, err := oc.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	if key, ok := oc.lookupKey(kid); ok {
+		return key, nil
+	}
+	if time.Since(oc.keysAt) < oidcJWKSMinAge {
+		return nil, fmt.Errorf("unknown key id: %s", kid)
+	}
+
+	var jwks struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := oc.getJSON(ctx, disc.JWKSURI, &jwks); err != nil {
+		logger.Errorf("fetch oidc jwks failed: %v", err)
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, raw := range jwks.Keys {
+		k, key, err := parseJWK(raw)
+		if err != nil {
+			logger.Warnf("skip oidc jwk: %v", err)
+			continue
+		}
+		keys[k] = key
+	}
+	oc.keys = keys
+	oc.keysAt = time.Now()
+
+	if key, ok := oc.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id: %s", kid)
+}
+
+// lookupKey 令牌未带 kid 且 JWKS 只有一个密钥时直接使用该密钥
+func (oc *OIDCService) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(oc.keys) == 1 {
+		for _, key := range oc.keys {
+			return key, true
+		}
+	}
+	key, ok := oc.keys[kid]
+	return key, ok
+}
+
+func (oc *OIDCService) getJSON(ctx context.Context, target string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := oc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", target, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// provisionUser 将 IdP 身份映射到本地用户，首次登录时自动创建（JIT），之后每次登录同步角色
+func (oc *OIDCService) provisionUser(claims jwt.MapClaims) (*models.User, error) {
+	sub, _ := claims["sub"].(string)
+	externalID := oc.cfg.Issuer + "|" + sub
+
+	role := oc.mapRole(claims[oc.cfg.RoleClaim])
+	if role == "" {
+		logger.Errorf("oidc user %s has no mapped role", externalID)
+		return nil, ErrOIDCRoleUnmapped
+	}
+
+	var user models.User
+	err := store.DB.Where("external_id = ?", externalID).First(&user).Error
+	if err == nil {
+		if !user.Active {
+			return nil, ErrOIDCUserDisabled
+		}
+		if user.Role != role {
+			if err := store.DB.Model(&user).Update("role", role).Error; err != nil {
+				logger.Errorf("sync oidc user role failed: %v", err)
+				return nil, err
+			}
+			user.Role = role
+			// 与 UserService.UpdateUser 一致：角色变化后旧令牌中的角色已失效，强制重新登录
+			if err := GetUserService().revokeUser(&user); err != nil {
+				logger.Errorf("failed to revoke oidc user %s: %v", user.Username, err)
+				return nil, err
+			}
+			logger.Infof("oidc user %s role synced to %s", user.Username, role)
+		}
+		return &user, nil
+	}
+
+	username, _ := claims[oc.cfg.UsernameClaim].(string)
+	if username == "" || len(username) > 50 {
+		logger.Errorf("oidc claim %s missing or too long for %s", oc.cfg.UsernameClaim, externalID)
+		return nil, ErrOIDCTokenInvalid
+	}
+	// 同名本地账号不自动关联，避免 IdP 侧的同名用户接管本地账号
+	if _, err := GetUserService().GetUser(username); err == nil {
+		logger.Errorf("oidc username %s conflicts with an existing account", username)
+		return nil, ErrOIDCUserConflict
+	}
+
+	user = models.User{
+		Username:   username,
+		Role:       role,
+		Active:     true,
+		Apps:       `["all"]`,
+		ExternalID: externalID,
+		AuthSource: models.AuthSourceOIDC,
+
+		WorkspaceID: models.DefaultWorkspaceID, // 单点登录账号归入缺省工作区
+	}
+	if picture, ok := claims["picture"].(string); ok && len(picture) <= 255 {
+		user.Avatar = picture
+	}
+	// 单点登录用户不使用本地密码，写入随机值
+	user.SetPassword(utils.GenerateSecureToken(32))
+	if err := store.DB.Create(&user).Error; err != nil {
+		logger.Errorf("create oidc user %s failed: %v", username, err)
+		return nil, err
+	}
+	logger.Infof("oidc user provisioned: %s (%s), role %s", username, externalID, role)
+	return &user, nil
+}
+
+// mapRole 根据角色声明（字符串或字符串数组）映射本地角色
+func (oc *OIDCService) mapRole(claim interface{}) string {
+	var values []string
+	switch v := claim.(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	best := -1
+	for _, value := range values {
+		if idx := slices.Index(oidcRolePriority, oc.cfg.RoleMapping[value]); idx >= 0 && (best < 0 || idx < best) {
+			best = idx
+		}
+	}
+	if best >= 0 {
+		return oidcRolePriority[best]
+	}
+	if models.IsValidRole(oc.cfg.DefaultRole) {
+		return oc.cfg.DefaultRole
+	}
+	return ""
+}
+
+// parseJWK 解析 JWKS 中的单个公钥（RSA、EC、OKP/Ed25519）
+func parseJWK(raw json.RawMessage) (string, crypto.PublicKey, error) {
+	var jwk struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return "", nil, err
+	}
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return "", nil, fmt.Errorf("key %s is not a signing key", jwk.Kid)
+	}
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return "", nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return "", nil, err
+		}
+		return jwk.Kid, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return "", nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return "", nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return "", nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return "", nil, fmt.Errorf("invalid ec key %s", jwk.Kid)
+		}
+		return jwk.Kid, key, nil
+	case "OKP":
+		x, err := decode(jwk.X)
+		if err != nil || jwk.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return "", nil, fmt.Errorf("invalid okp key %s", jwk.Kid)
+		}
+		return jwk.Kid, ed25519.PublicKey(x), nil
+	}
+	return "", nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
+}
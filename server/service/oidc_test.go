@@ -0,0 +1,303 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"kefu-server/config"
+	"kefu-server/models"
+	"kefu-server/store"
+	"kefu-server/utils"
+)
+
+// testIdP 本地替身 IdP：提供发现文档、JWKS 和令牌端点，授权码由测试直接登记
+type testIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]idpGrant
+}
+
+// idpGrant 授权码对应的 PKCE challenge 和要签发的 ID Token 声明
+type idpGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{key: key, codes: make(map[string]idpGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		idp.mu.Lock()
+		grant, ok := idp.codes[r.PostForm.Get("code")]
+		delete(idp.codes, r.PostForm.Get("code"))
+		idp.mu.Unlock()
+
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || r.PostForm.Get("grant_type") != "authorization_code" ||
+			r.PostForm.Get("client_id") != "kefu" || r.PostForm.Get("client_secret") != "secret" ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.claims)
+		token.Header["kid"] = "k1"
+		idToken, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "access_token": "at", "token_type": "Bearer"})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// authorize 模拟用户在 IdP 登录并同意授权，返回回调带回的 code 和 state
+func (idp *testIdP) authorize(t *testing.T, authURL string, claims jwt.MapClaims) (code, state string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("auth url without PKCE: %s", authURL)
+	}
+
+	full := jwt.MapClaims{
+		"iss":   idp.URL,
+		"aud":   "kefu",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": q.Get("nonce"),
+	}
+	for k, v := range claims {
+		full[k] = v
+	}
+	code = utils.GenerateSecureToken(8)
+	idp.mu.Lock()
+	idp.codes[code] = idpGrant{challenge: q.Get("code_challenge"), claims: full}
+	idp.mu.Unlock()
+	return code, q.Get("state")
+}
+
+func setupOIDCTest(t *testing.T) (*OIDCService, *testIdP) {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := store.InitDB(filepath.Join(dir, "kefu.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Workspace{}); err != nil {
+		t.Fatal(err)
+	}
+	kv, err := store.InitStore(filepath.Join(dir, "kv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	instTokenService = nil
+	t.Cleanup(func() {
+		kv.Close()
+		store.KV = nil
+		instTokenService = nil
+	})
+
+	idp := newTestIdP(t)
+	oc := &OIDCService{
+		kv: kv,
+		cfg: config.OIDCConfig{
+			Enabled:       true,
+			Issuer:        idp.URL,
+			ClientID:      "kefu",
+			ClientSecret:  "secret",
+			RedirectURL:   "http://kefu.test/api/v1/oidc/callback",
+			Scopes:        []string{"openid", "profile"},
+			UsernameClaim: "preferred_username",
+			RoleClaim:     "groups",
+			RoleMapping:   map[string]string{"kefu-admins": models.RoleAdmin, "kefu-agents": models.RoleAgent},
+		},
+		client: idp.Client(),
+	}
+	return oc, idp
+}
+
+// login 走完一次授权码流程
+func (idp *testIdP) login(t *testing.T, oc *OIDCService, claims jwt.MapClaims) (*models.User, error) {
+	t.Helper()
+	ctx := context.Background()
+	authURL, binding, err := oc.AuthURL(ctx)
+	if err != nil {
+		t.Fatalf("AuthURL error: %v", err)
+	}
+	code, state := idp.authorize(t, authURL, claims)
+	return oc.Callback(ctx, code, state, binding)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	oc, idp := setupOIDCTest(t)
+	if err := store.DB.Create(&models.User{Username: "local", Role: models.RoleAgent, Active: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		wantRole string // 为空表示应当失败
+		wantErr  error
+	}{
+		{"provision agent", jwt.MapClaims{"sub": "u1", "preferred_username": "alice", "groups": []any{"kefu-agents"}}, models.RoleAgent, nil},
+		{"existing user", jwt.MapClaims{"sub": "u1", "preferred_username": "alice", "groups": []any{"kefu-agents"}}, models.RoleAgent, nil},
+		{"highest mapped role", jwt.MapClaims{"sub": "u2", "preferred_username": "bob", "groups": []any{"kefu-agents", "kefu-admins"}}, models.RoleAdmin, nil},
+		{"role claim as string", jwt.MapClaims{"sub": "u3", "preferred_username": "carol", "groups": "other kefu-agents"}, models.RoleAgent, nil},
+		{"unmapped role", jwt.MapClaims{"sub": "u4", "preferred_username": "dave", "groups": []any{"staff"}}, "", ErrOIDCRoleUnmapped},
+		{"local username taken", jwt.MapClaims{"sub": "u5", "preferred_username": "local", "groups": []any{"kefu-agents"}}, "", ErrOIDCUserConflict},
+		{"missing username", jwt.MapClaims{"sub": "u6", "groups": []any{"kefu-agents"}}, "", ErrOIDCTokenInvalid},
+		{"missing sub", jwt.MapClaims{"preferred_username": "erin", "groups": []any{"kefu-agents"}}, "", ErrOIDCTokenInvalid},
+		{"wrong audience", jwt.MapClaims{"sub": "u7", "preferred_username": "frank", "groups": []any{"kefu-agents"}, "aud": "other"}, "", ErrOIDCTokenInvalid},
+		{"wrong issuer", jwt.MapClaims{"sub": "u7", "preferred_username": "frank", "groups": []any{"kefu-agents"}, "iss": "https://evil.test"}, "", ErrOIDCTokenInvalid},
+		{"nonce mismatch", jwt.MapClaims{"sub": "u7", "preferred_username": "frank", "groups": []any{"kefu-agents"}, "nonce": "replayed"}, "", ErrOIDCTokenInvalid},
+		{"expired", jwt.MapClaims{"sub": "u7", "preferred_username": "frank", "groups": []any{"kefu-agents"}, "exp": time.Now().Add(-time.Hour).Unix()}, "", ErrOIDCTokenInvalid},
+	}
+	for _, tt := range tests {
+		user, err := idp.login(t, oc, tt.claims)
+		if tt.wantRole == "" {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error: %v", tt.name, err)
+			continue
+		}
+		if user.Role != tt.wantRole || user.AuthSource != models.AuthSourceOIDC || user.ExternalID != idp.URL+"|"+tt.claims["sub"].(string) {
+			t.Errorf("%s: user = %s/%s/%s, want role %s from oidc", tt.name, user.Username, user.Role, user.ExternalID, tt.wantRole)
+		}
+	}
+
+	var count int64
+	store.DB.Model(&models.User{}).Where("username = ?", "alice").Count(&count)
+	if count != 1 {
+		t.Errorf("alice provisioned %d times, want 1", count)
+	}
+}
+
+func TestOIDCCallbackRejectsBadState(t *testing.T) {
+	oc, idp := setupOIDCTest(t)
+	ctx := context.Background()
+	claims := jwt.MapClaims{"sub": "u1", "preferred_username": "alice", "groups": []any{"kefu-agents"}}
+
+	authURL, binding, err := oc.AuthURL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := idp.authorize(t, authURL, claims)
+	if _, err := oc.Callback(ctx, code, "forged", binding); !errors.Is(err, ErrOIDCStateInvalid) {
+		t.Errorf("forged state: error = %v, want %v", err, ErrOIDCStateInvalid)
+	}
+	// 回调须来自发起登录的浏览器（Cookie 中的绑定值），否则不消费 state
+	if _, err := oc.Callback(ctx, code, state, ""); !errors.Is(err, ErrOIDCStateInvalid) {
+		t.Errorf("missing binding: error = %v, want %v", err, ErrOIDCStateInvalid)
+	}
+	if _, err := oc.Callback(ctx, code, state, OIDCStateBinding("other")); !errors.Is(err, ErrOIDCStateInvalid) {
+		t.Errorf("binding of another state: error = %v, want %v", err, ErrOIDCStateInvalid)
+	}
+	if _, err := oc.Callback(ctx, code, state, binding); err != nil {
+		t.Fatalf("valid callback error: %v", err)
+	}
+	// state 只能使用一次
+	if _, err := oc.Callback(ctx, code, state, binding); !errors.Is(err, ErrOIDCStateInvalid) {
+		t.Errorf("replayed state: error = %v, want %v", err, ErrOIDCStateInvalid)
+	}
+
+	// 授权码与另一次登录的 code_verifier 不匹配时 IdP 拒绝换取令牌
+	first, _, err := oc.AuthURL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, binding, err := oc.AuthURL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ = idp.authorize(t, first, claims)
+	_, state = idp.authorize(t, second, claims)
+	if _, err := oc.Callback(ctx, code, state, binding); err == nil {
+		t.Error("callback accepted a code bound to another PKCE challenge")
+	}
+}
+
+func TestOIDCRoleSyncRevokesTokens(t *testing.T) {
+	oc, idp := setupOIDCTest(t)
+	agent := jwt.MapClaims{"sub": "u1", "preferred_username": "alice", "groups": []any{"kefu-agents"}}
+	user, err := idp.login(t, oc, agent)
+	if err != nil {
+		t.Fatalf("first login error: %v", err)
+	}
+
+	issued := &utils.Claims{UserID: user.ID, RegisteredClaims: jwt.RegisteredClaims{
+		ID:       "jti-1",
+		IssuedAt: jwt.NewNumericDate(time.Now().Add(-time.Second)),
+	}}
+	ts := GetTokenService()
+	if ts.IsRevoked(issued) {
+		t.Fatal("token revoked before role change")
+	}
+
+	// 角色未变化时不吊销
+	if _, err := idp.login(t, oc, agent); err != nil {
+		t.Fatalf("second login error: %v", err)
+	}
+	if ts.IsRevoked(issued) {
+		t.Error("token revoked although the role did not change")
+	}
+
+	user, err = idp.login(t, oc, jwt.MapClaims{"sub": "u1", "preferred_username": "alice", "groups": []any{"kefu-admins"}})
+	if err != nil {
+		t.Fatalf("role change login error: %v", err)
+	}
+	if user.Role != models.RoleAdmin {
+		t.Errorf("role = %s, want %s", user.Role, models.RoleAdmin)
+	}
+	if !ts.IsRevoked(issued) {
+		t.Error("token issued before the role change is still valid")
+	}
+}
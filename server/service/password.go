@@ -0,0 +1,152 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"kefu-server/models"
+	"kefu-server/store"
+	"kefu-server/utils"
+	"kefu-server/utils/logger"
+)
+
+// 密码重置令牌
+// pr:{sha256(token)}  一次性重置令牌，记录用户 ID，TTL 为 PasswordResetTTL 或 PasswordChangeTTL
+
+const (
+	PasswordResetTTL  = 24 * time.Hour   // 管理员重置后交给用户的令牌
+	PasswordChangeTTL = 10 * time.Minute // 登录时被要求修改密码，用于提交新密码的令牌
+)
+
+var (
+	ErrPasswordReused       = errors.New("password was used recently")
+	ErrPasswordResetInvalid = errors.New("password reset token invalid or expired")
+)
+
+// ValidateNewPassword 按密码策略校验新密码，并检查是否与最近使用过的密码相同
+func (us *UserService) ValidateNewPassword(user *models.User, password string) error {
+	policy := models.GetPasswordPolicy(user.WorkspaceID)
+	if err := policy.Validate(user.Username, password); err != nil {
+		return err
+	}
+	if policy.HistoryCount == 0 {
+		return nil
+	}
+
+	prehash := utils.PrehashPassword(password)
+	hashes := append([]string{user.Password}, passwordHistory(user)...)
+	for i := 0; i < policy.HistoryCount && i < len(hashes); i++ {
+		if utils.MatchPassword(prehash, hashes[i]) {
+			return ErrPasswordReused
+		}
+	}
+	return nil
+}
+
+func passwordHistory(user *models.User) []string {
+	var history []string
+	if user.PasswordHistory != "" {
+		json.Unmarshal([]byte(user.PasswordHistory), &history)
+	}
+	return history
+}
+
+// ChangePassword 设置新密码（调用方负责策略校验），旧密码计入历史，并吊销该用户已签发的令牌
+func (us *UserService) ChangePassword(user *models.User, password string, mustChange bool) error {
+	return us.replacePassword(user, utils.NewPasswordVerifier(utils.PrehashPassword(password)), mustChange)
+}
+
+// UpgradePassword 将旧的 argon2id 哈希替换为同一密码的登录验证器，不计入历史也不吊销令牌
+func (us *UserService) UpgradePassword(user *models.User, verifier string) error {
+	if err := store.DB.Model(&models.User{}).Where("id = ? AND password = ?", user.ID, user.Password).
+		Update("password", verifier).Error; err != nil {
+		logger.Errorf("upgrade password for user %s failed: %v", user.Username, err)
+		return fmt.Errorf("upgrade password failed: %v", err)
+	}
+	user.Password = verifier
+	return nil
+}
+
+func (us *UserService) replacePassword(user *models.User, hash string, mustChange bool) error {
+	history := passwordHistory(user)
+	if user.Password != "" {
+		history = append([]string{user.Password}, history...)
+	}
+	if len(history) > models.MaxPasswordHistory {
+		history = history[:models.MaxPasswordHistory]
+	}
+	data, _ := json.Marshal(history)
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"password":             hash,
+		"password_history":     string(data),
+		"must_change_password": mustChange,
+		"password_changed_at":  now,
+	}
+	if err := store.DB.Model(&models.User{}).Where("id = ?", user.ID).Updates(updates).Error; err != nil {
+		logger.Errorf("change password for user %s failed: %v", user.Username, err)
+		return fmt.Errorf("change password failed: %v", err)
+	}
+	user.Password = hash
+	user.PasswordHistory = string(data)
+	user.MustChangePassword = mustChange
+	user.PasswordChangedAt = &now
+
+	// 修改密码后旧令牌全部失效
+	ts := GetTokenService()
+	if ts == nil {
+		return fmt.Errorf("token service not initialized")
+	}
+	return ts.RevokeUserTokens(user.ID)
+}
+
+// ResetPassword 管理员重置密码：旧密码立即失效，返回用户设置新密码所需的一次性令牌
+func (us *UserService) ResetPassword(user *models.User) (string, error) {
+	// 写入无人知晓的随机密码，用户只能凭重置令牌设置新密码
+	if err := us.replacePassword(user, utils.NewPasswordVerifier(utils.GenerateSecureToken(32)), true); err != nil {
+		return "", err
+	}
+	return us.IssuePasswordToken(user.ID, PasswordResetTTL)
+}
+
+// IssuePasswordToken 签发一次性密码重置令牌
+func (us *UserService) IssuePasswordToken(userID uint, ttl time.Duration) (string, error) {
+	token := utils.GenerateSecureToken(32)
+	if err := store.SetWithTTL(passwordTokenKey(token), []byte(fmt.Sprint(userID)), ttl); err != nil {
+		logger.Errorf("save password reset token failed: %v", err)
+		return "", err
+	}
+	return token, nil
+}
+
+// LookupPasswordToken 读取重置令牌对应的用户（不消费，新密码不符合策略时可重试）
+func (us *UserService) LookupPasswordToken(token string) (*models.User, error) {
+	val, err := store.GetValue(passwordTokenKey(token))
+	if err != nil {
+		return nil, ErrPasswordResetInvalid
+	}
+	var userID uint
+	if _, err := fmt.Sscan(string(val), &userID); err != nil {
+		return nil, ErrPasswordResetInvalid
+	}
+	user, err := us.GetUserByID(userID)
+	if err != nil || !user.Active {
+		return nil, ErrPasswordResetInvalid
+	}
+	return user, nil
+}
+
+// ConsumePasswordToken 新密码设置成功后作废重置令牌
+func (us *UserService) ConsumePasswordToken(token string) {
+	store.DeleteKey(passwordTokenKey(token))
+}
+
+func passwordTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "pr:" + hex.EncodeToString(sum[:])
+}
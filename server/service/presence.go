@@ -0,0 +1,192 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"kefu-server/config"
+	"kefu-server/models"
+	"kefu-server/store"
+	"kefu-server/utils/logger"
+)
+
+// 客服在线状态
+// 在线状态由 /ws/agent 连接决定：连接建立时离线客服自动置为在席，
+// 全部连接断开后经过宽限期（admin.presence_grace）仍未重连则置为离线，
+// 避免关闭浏览器的客服仍被分配会话。连接期间客服可手动切换在席、离席、忙碌。
+
+var ErrAgentOffline = errors.New("agent is not connected")
+
+// Presence 客服在线状态
+type Presence struct {
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	Avatar    string `json:"avatar"`
+	Status    string `json:"status"`    // offline / online / away / busy
+	Connected bool   `json:"connected"` // 是否有客服 WebSocket 连接
+}
+
+type PresenceService struct {
+	mu      sync.Mutex
+	conns   map[string]int         // username => 连接数
+	pending map[string]*time.Timer // username => 断开后的离线计时
+}
+
+var (
+	instPresenceService *PresenceService
+
+	// 在线状态变化时的回调（由控制器层注册，用于通知主管）
+	presenceHooks []func(username string, status int)
+)
+
+// OnPresenceChange 注册在线状态变化时的回调
+func OnPresenceChange(fn func(username string, status int)) {
+	presenceHooks = append(presenceHooks, fn)
+}
+
+func GetPresenceService() *PresenceService {
+	if instPresenceService == nil {
+		instPresenceService = &PresenceService{
+			conns:   map[string]int{},
+			pending: map[string]*time.Timer{},
+		}
+	}
+	return instPresenceService
+}
+
+// ResetAll 启动时尚无任何连接，将全部客服置为离线
+func (ps *PresenceService) ResetAll() error {
+	if err := store.DB.Model(&models.User{}).Where("status <> ?", models.UserStatusOffline).
+		Update("status", models.UserStatusOffline).Error; err != nil {
+		logger.Errorf("reset user status failed: %v", err)
+		return fmt.Errorf("reset user status failed: %v", err)
+	}
+	return nil
+}
+
+// Connect 客服建立连接，离线的客服置为在席，返回当前状态及是否发生变化
+func (ps *PresenceService) Connect(username string) (int, bool, error) {
+	ps.mu.Lock()
+	ps.conns[username]++
+	if timer, ok := ps.pending[username]; ok {
+		timer.Stop()
+		delete(ps.pending, username)
+	}
+	user, err := GetUserService().GetUser(username)
+	if err != nil {
+		ps.mu.Unlock()
+		return models.UserStatusOffline, false, err
+	}
+	status, changed := user.Status, false
+	if status == models.UserStatusOffline {
+		status = models.UserStatusOnline
+		if err := GetUserService().SetUserStatus(username, status); err != nil {
+			ps.mu.Unlock()
+			return models.UserStatusOffline, false, err
+		}
+		changed = true
+	}
+	ps.mu.Unlock()
+
+	if changed {
+		ps.notify(username, status)
+	}
+	return status, changed, nil
+}
+
+// Disconnect 客服断开连接，最后一个连接断开后开始离线计时
+func (ps *PresenceService) Disconnect(username string) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.conns[username] > 1 {
+		ps.conns[username]--
+		return
+	}
+	delete(ps.conns, username)
+
+	var timer *time.Timer
+	timer = time.AfterFunc(config.AppConfig.Admin.PresenceGrace, func() {
+		ps.expire(username, timer)
+	})
+	ps.pending[username] = timer
+}
+
+// expire 宽限期结束仍未重连，置为离线
+func (ps *PresenceService) expire(username string, timer *time.Timer) {
+	ps.mu.Lock()
+	if ps.pending[username] != timer || ps.conns[username] > 0 {
+		ps.mu.Unlock()
+		return
+	}
+	delete(ps.pending, username)
+	err := GetUserService().SetUserStatus(username, models.UserStatusOffline)
+	ps.mu.Unlock()
+
+	if err != nil {
+		logger.Errorf("set agent %s offline failed: %v", username, err)
+		return
+	}
+	logger.Infof("agent %s offline after disconnect", username)
+	ps.notify(username, models.UserStatusOffline)
+}
+
+// SetStatus 客服手动切换状态，除离线外要求客服已连接
+func (ps *PresenceService) SetStatus(username string, status int) error {
+	ps.mu.Lock()
+	if status != models.UserStatusOffline && ps.conns[username] == 0 {
+		ps.mu.Unlock()
+		return ErrAgentOffline
+	}
+	err := GetUserService().SetUserStatus(username, status)
+	ps.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	ps.notify(username, status)
+	return nil
+}
+
+// ListPresence 工作区内可接待访客的客服及其在线状态，appID 非空时只返回接待该业务的客服（含负责全部业务的客服）
+func (ps *PresenceService) ListPresence(workspaceID uint, appID string) ([]Presence, error) {
+	var roles []string
+	for role := range models.RolePermissions {
+		if models.HasPermission(role, models.PermChat) {
+			roles = append(roles, role)
+		}
+	}
+
+	query := store.DB.Model(&models.User{}).Scopes(models.InWorkspace(workspaceID)).Where("active = ? AND role IN ?", true, roles)
+	var users []models.User
+	if err := query.Order("username").Find(&users).Error; err != nil {
+		logger.Errorf("list presence failed: %v", err)
+		return nil, fmt.Errorf("list presence failed: %v", err)
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	list := make([]Presence, 0, len(users))
+	for _, user := range users {
+		// 与分配客服的规则一致，负责全部业务的客服也接待该业务
+		if appID != "" && !user.ServesApp(appID) {
+			continue
+		}
+		list = append(list, Presence{
+			Username:  user.Username,
+			Role:      user.Role,
+			Avatar:    user.Avatar,
+			Status:    models.UserStatusName(user.Status),
+			Connected: ps.conns[user.Username] > 0,
+		})
+	}
+	return list, nil
+}
+
+func (ps *PresenceService) notify(username string, status int) {
+	for _, fn := range presenceHooks {
+		fn(username, status)
+	}
+}
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"kefu-server/models"
+	"kefu-server/store"
+	"kefu-server/utils/logger"
+)
+
+// 已归档业务的数据清除
+// 会话和消息的键以 visitor_id 开头，无法按业务前缀删除，需遍历 s: / m: 后按 app_id 段匹配：
+// 会话 s:{visitor_id}:{app_id}:{session_seq} 或 m:{visitor_id}:{app_id}:{session_seq}
+// 消息 m:{visitor_id}:{app_id}:{session_seq}:{msg_seq}
+// 清除进度保存在 purge:{app_id}，全部删除后永久删除业务记录
+
+const (
+	PurgeStateRunning     = "running"
+	PurgeStateDone        = "done"
+	PurgeStateFailed      = "failed"
+	PurgeStateInterrupted = "interrupted" // 服务重启导致中断，可重新发起
+
+	purgeBatchSize = 1000
+	purgeRecordTTL = 30 * 24 * time.Hour
+)
+
+var ErrPurgeRunning = errors.New("purge already running")
+
+// PurgeJob 清除任务进度
+type PurgeJob struct {
+	AppID           string `json:"app_id"`
+	State           string `json:"state"`
+	Total           int    `json:"total"` // 待删除的键数，扫描完成后确定
+	DeletedSessions int    `json:"deleted_sessions"`
+	DeletedMessages int    `json:"deleted_messages"`
+	Error           string `json:"error,omitempty"`
+	StartedBy       string `json:"started_by"`
+	StartedAt       int64  `json:"started_at"`
+	FinishedAt      int64  `json:"finished_at,omitempty"`
+	WorkspaceID     uint   `json:"workspace_id"` // 业务记录删除后仍可按工作区查询进度
+}
+
+type PurgeService struct {
+	kv *badger.DB
+
+	mu      sync.Mutex
+	running map[string]*PurgeJob // app_id => 进行中的任务
+}
+
+var (
+	instPurgeService *PurgeService
+)
+
+func GetPurgeService() *PurgeService {
+	if instPurgeService != nil {
+		return instPurgeService
+	}
+
+	if kv := store.GetStore(); kv == nil { // 单例
+		logger.Errorf("kv is not initialized")
+		return nil
+	} else {
+		instPurgeService = &PurgeService{kv: kv, running: map[string]*PurgeJob{}}
+		return instPurgeService
+	}
+}
+
+func purgeKey(appID string) string {
+	return "purge:" + appID
+}
+
+// StartPurge 后台清除业务的全部会话和消息，调用方需确认业务已归档
+func (ps *PurgeService) StartPurge(workspaceID uint, appID, actor string) (*PurgeJob, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if _, ok := ps.running[appID]; ok {
+		return nil, ErrPurgeRunning
+	}
+	job := &PurgeJob{
+		AppID:     appID,
+		State:     PurgeStateRunning,
+		StartedBy: actor,
+		StartedAt: time.Now().Unix(),
+
+		WorkspaceID: workspaceID,
+	}
+	if err := ps.save(job); err != nil {
+		return nil, err
+	}
+	ps.running[appID] = job
+
+	snapshot := *job
+	go ps.run(job)
+	return &snapshot, nil
+}
+
+// GetJob 查询清除进度，没有记录时返回 nil
+func (ps *PurgeService) GetJob(appID string) (*PurgeJob, error) {
+	ps.mu.Lock()
+	if job, ok := ps.running[appID]; ok {
+		snapshot := *job
+		ps.mu.Unlock()
+		return &snapshot, nil
+	}
+	ps.mu.Unlock()
+
+	data, err := store.GetValue(purgeKey(appID))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		logger.Errorf("get purge job %s failed: %v", appID, err)
+		return nil, err
+	}
+	var job PurgeJob
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, err
+	}
+	// 记录为进行中但本进程没有该任务，说明服务重启时被中断
+	if job.State == PurgeStateRunning {
+		job.State = PurgeStateInterrupted
+	}
+	return &job, nil
+}
+
+func (ps *PurgeService) run(job *PurgeJob) {
+	err := ps.purge(job)
+
+	ps.mu.Lock()
+	job.FinishedAt = time.Now().Unix()
+	if err != nil {
+		job.State = PurgeStateFailed
+		job.Error = err.Error()
+		logger.Errorf("purge app %s failed: %v", job.AppID, err)
+	} else {
+		job.State = PurgeStateDone
+		logger.Infof("purge app %s done: %d sessions, %d messages", job.AppID, job.DeletedSessions, job.DeletedMessages)
+	}
+	if err := ps.save(job); err != nil {
+		logger.Errorf("save purge job %s failed: %v", job.AppID, err)
+	}
+	delete(ps.running, job.AppID)
+	ps.mu.Unlock()
+}
+
+func (ps *PurgeService) purge(job *PurgeJob) error {
+	sessions, messages, err := ps.scan(job.AppID)
+	if err != nil {
+		return err
+	}
+	ps.mu.Lock()
+	job.Total = len(sessions) + len(messages)
+	ps.mu.Unlock()
+
+	if err := ps.deleteKeys(job, sessions, &job.DeletedSessions); err != nil {
+		return err
+	}
+	if err := ps.deleteKeys(job, messages, &job.DeletedMessages); err != nil {
+		return err
+	}
+
+	// 数据清除完成后永久删除业务记录
+	if err := store.DB.Unscoped().Where("app_id = ? AND deleted_at IS NOT NULL", job.AppID).
+		Delete(&models.App{}).Error; err != nil {
+		return fmt.Errorf("delete app record: %v", err)
+	}
+	models.InvalidateRetention(job.AppID)
+	return nil
+}
+
+// scan 找出属于业务的全部会话和消息键
+func (ps *PurgeService) scan(appID string) (sessions, messages [][]byte, err error) {
+	err = ps.kv.View(func(txn *badger.Txn) error {
+		for _, prefix := range []string{"s:", "m:"} {
+			it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefix)})
+			for it.Rewind(); it.Valid(); it.Next() {
+				key := it.Item().KeyCopy(nil)
+				parts := strings.Split(string(key), ":")
+				if len(parts) < 4 || parts[2] != appID {
+					continue
+				}
+				if len(parts) == 5 && parts[0] == "m" {
+					messages = append(messages, key)
+				} else {
+					sessions = append(sessions, key)
+				}
+			}
+			it.Close()
+		}
+		return nil
+	})
+	return sessions, messages, err
+}
+
+// deleteKeys 分批删除并在每批后更新进度
+func (ps *PurgeService) deleteKeys(job *PurgeJob, keys [][]byte, counter *int) error {
+	for start := 0; start < len(keys); start += purgeBatchSize {
+		batch := keys[start:min(start+purgeBatchSize, len(keys))]
+		if err := ps.kv.Update(func(txn *badger.Txn) error {
+			for _, key := range batch {
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		ps.mu.Lock()
+		*counter += len(batch)
+		err := ps.save(job)
+		ps.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ps *PurgeService) save(job *PurgeJob) error {
+	data, _ := json.Marshal(job)
+	return store.SetWithTTL(purgeKey(job.AppID), data, purgeRecordTTL)
+}
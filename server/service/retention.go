@@ -0,0 +1,342 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"kefu-server/models"
+	"kefu-server/store"
+	"kefu-server/utils/logger"
+)
+
+// 业务保留策略变更后，对已有会话和消息重新计算过期时间
+// 与清除任务一样需遍历 m: 后按 app_id 段匹配，每批处理后把游标保存在 retention:{app_id}，
+// 服务重启后从游标继续；处理中再次修改策略时从头按新策略执行
+
+const (
+	RetentionStateRunning = "running"
+	RetentionStateDone    = "done"
+	RetentionStateFailed  = "failed"
+
+	retentionBatchSize = 1000
+	retentionRecordTTL = 30 * 24 * time.Hour
+)
+
+// RetentionJob 重新应用保留策略的任务进度
+type RetentionJob struct {
+	AppID      string                 `json:"app_id"`
+	Policy     models.RetentionPolicy `json:"policy"`
+	State      string                 `json:"state"`
+	Cursor     string                 `json:"cursor"`  // 最后处理的键，恢复时从其后继续
+	Scanned    int                    `json:"scanned"` // 已遍历的键数（含其他业务）
+	Updated    int                    `json:"updated"` // 已更新过期时间的会话和消息
+	Deleted    int                    `json:"deleted"` // 按新策略已过期而删除的会话和消息
+	Error      string                 `json:"error,omitempty"`
+	StartedBy  string                 `json:"started_by"`
+	StartedAt  int64                  `json:"started_at"`
+	FinishedAt int64                  `json:"finished_at,omitempty"`
+}
+
+type RetentionService struct {
+	kv *badger.DB
+
+	mu     sync.Mutex
+	jobs   map[string]*RetentionJob // app_id => 最新的任务
+	active map[string]bool          // app_id => 是否有后台协程在处理
+}
+
+var (
+	instRetentionService *RetentionService
+)
+
+func GetRetentionService() *RetentionService {
+	if instRetentionService != nil {
+		return instRetentionService
+	}
+
+	if kv := store.GetStore(); kv == nil { // 单例
+		logger.Errorf("kv is not initialized")
+		return nil
+	} else {
+		instRetentionService = &RetentionService{kv: kv, jobs: map[string]*RetentionJob{}, active: map[string]bool{}}
+		return instRetentionService
+	}
+}
+
+func retentionKey(appID string) string {
+	return "retention:" + appID
+}
+
+// retentionEntry 按保留策略设置过期时间，零值表示永久保留
+func retentionEntry(key string, data []byte, expiresAt time.Time) *badger.Entry {
+	entry := badger.NewEntry([]byte(key), data)
+	if !expiresAt.IsZero() {
+		entry.ExpiresAt = uint64(expiresAt.Unix())
+	}
+	return entry
+}
+
+// Apply 按新策略重新计算业务已有数据的过期时间，进行中的任务改为从头按新策略执行
+func (rs *RetentionService) Apply(appID string, policy models.RetentionPolicy, actor string) (*RetentionJob, error) {
+	policy.Normalize()
+	job := &RetentionJob{
+		AppID:     appID,
+		Policy:    policy,
+		State:     RetentionStateRunning,
+		StartedBy: actor,
+		StartedAt: time.Now().Unix(),
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if err := rs.save(job); err != nil {
+		logger.Errorf("save retention job %s failed: %v", appID, err)
+		return nil, err
+	}
+	rs.start(job)
+
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// Resume 服务启动时继续未完成或失败的任务，期间策略被修改过则从头执行
+func (rs *RetentionService) Resume() error {
+	var pending []RetentionJob
+	err := store.ScanPrefix("retention:", func(key string, value []byte) error {
+		var job RetentionJob
+		if err := json.Unmarshal(value, &job); err != nil {
+			logger.Errorf("invalid retention job %s: %v", key, err)
+			return nil
+		}
+		if job.State != RetentionStateDone {
+			pending = append(pending, job)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for i := range pending {
+		job := &pending[i]
+		if policy := models.GetRetention(job.AppID); policy != job.Policy {
+			job.Policy, job.Cursor = policy, ""
+			job.Scanned, job.Updated, job.Deleted = 0, 0, 0
+		}
+		job.State, job.Error, job.FinishedAt = RetentionStateRunning, "", 0
+		logger.Infof("resume retention job %s from %q", job.AppID, job.Cursor)
+		rs.start(job)
+	}
+	return nil
+}
+
+// GetJob 查询任务进度，没有记录时返回 nil
+func (rs *RetentionService) GetJob(appID string) (*RetentionJob, error) {
+	rs.mu.Lock()
+	if job, ok := rs.jobs[appID]; ok {
+		snapshot := *job
+		rs.mu.Unlock()
+		return &snapshot, nil
+	}
+	rs.mu.Unlock()
+
+	data, err := store.GetValue(retentionKey(appID))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		logger.Errorf("get retention job %s failed: %v", appID, err)
+		return nil, err
+	}
+	var job RetentionJob
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// start 登记任务，每个业务只有一个后台协程，调用方需持有锁
+func (rs *RetentionService) start(job *RetentionJob) {
+	rs.jobs[job.AppID] = job
+	if !rs.active[job.AppID] {
+		rs.active[job.AppID] = true
+		go rs.run(job.AppID)
+	}
+}
+
+// run 逐批处理，每批开始前取最新的任务，批次完成时任务已被替换则丢弃本批进度
+func (rs *RetentionService) run(appID string) {
+	for {
+		rs.mu.Lock()
+		job := rs.jobs[appID]
+		batch := *job
+		rs.mu.Unlock()
+
+		done, err := rs.applyBatch(&batch)
+
+		rs.mu.Lock()
+		if rs.jobs[appID] != job { // 策略再次修改，按新任务重新开始
+			rs.mu.Unlock()
+			continue
+		}
+		*job = batch
+		if err != nil {
+			job.State = RetentionStateFailed
+			job.Error = err.Error()
+			logger.Errorf("apply retention to app %s failed: %v", appID, err)
+		} else if done {
+			job.State = RetentionStateDone
+			logger.Infof("apply retention to app %s done: %d updated, %d deleted", appID, job.Updated, job.Deleted)
+		}
+		if done || err != nil {
+			job.FinishedAt = time.Now().Unix()
+		}
+		if err := rs.save(job); err != nil {
+			logger.Errorf("save retention job %s failed: %v", appID, err)
+		}
+		if job.State != RetentionStateRunning {
+			delete(rs.jobs, appID)
+			delete(rs.active, appID)
+			rs.mu.Unlock()
+			return
+		}
+		rs.mu.Unlock()
+	}
+}
+
+// applyBatch 从游标之后处理一批键，遍历结束时返回 done
+// 先只读遍历找出需要改写的键，再逐键在小事务内改写，避免长事务与会话和消息写入冲突
+func (rs *RetentionService) applyBatch(job *RetentionJob) (bool, error) {
+	progress := *job
+	var keys [][]byte
+	var done bool
+	if err := rs.kv.View(func(txn *badger.Txn) error {
+		var err error
+		keys, done, err = scanRetention(txn, &progress)
+		return err
+	}); err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		var deleted, updated bool
+		err := rs.kv.Update(func(txn *badger.Txn) error {
+			var err error
+			deleted, updated, err = applyRetention(txn, key, progress.Policy, now)
+			return err
+		})
+		if errors.Is(err, badger.ErrConflict) {
+			// 期间被会话或消息写入改写，写入时已按当前策略设置过期时间
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if deleted {
+			progress.Deleted++
+		} else if updated {
+			progress.Updated++
+		}
+	}
+	*job = progress
+	return done, nil
+}
+
+// scanRetention 遍历游标之后的一批键，返回业务中过期时间与新策略不符的键
+func scanRetention(txn *badger.Txn, job *RetentionJob) ([][]byte, bool, error) {
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte("m:")})
+	defer it.Close()
+
+	var keys [][]byte
+	scanned := 0
+	for it.Seek([]byte(job.Cursor)); it.Valid(); it.Next() {
+		item := it.Item()
+		key := string(item.Key())
+		if key == job.Cursor {
+			continue
+		}
+		if scanned == retentionBatchSize {
+			return keys, false, nil
+		}
+		scanned++
+		job.Scanned++
+		job.Cursor = key
+
+		parts := strings.Split(key, ":")
+		if len(parts) < 4 || parts[2] != job.AppID {
+			continue
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, false, err
+		}
+		if _, ok := retentionExpiry(parts, value, item.ExpiresAt(), job.Policy); ok {
+			keys = append(keys, item.KeyCopy(nil))
+		}
+	}
+	return keys, true, nil
+}
+
+// applyRetention 在事务内按新策略改写单个键，键已不存在或无需改写时不做处理
+func applyRetention(txn *badger.Txn, key []byte, policy models.RetentionPolicy, now time.Time) (deleted, updated bool, err error) {
+	item, err := txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, err
+	}
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return false, false, err
+	}
+	expiresAt, ok := retentionExpiry(strings.Split(string(key), ":"), value, item.ExpiresAt(), policy)
+	if !ok {
+		return false, false, nil
+	}
+	if !expiresAt.IsZero() && !expiresAt.After(now) {
+		return true, false, txn.Delete(key)
+	}
+	return false, true, txn.SetEntry(retentionEntry(string(key), value, expiresAt))
+}
+
+// retentionExpiry 按新策略计算过期时间，与当前过期时间相同时返回 false
+func retentionExpiry(parts []string, value []byte, current uint64, policy models.RetentionPolicy) (time.Time, bool) {
+	from, ok := retentionStart(parts, value)
+	if !ok {
+		return time.Time{}, false
+	}
+	expiresAt := policy.ExpiresAt(from)
+	var want uint64
+	if !expiresAt.IsZero() {
+		want = uint64(expiresAt.Unix())
+	}
+	return expiresAt, want != current
+}
+
+// retentionStart 过期时间的起算点：消息为发送时间，会话为最后活跃时间
+func retentionStart(parts []string, value []byte) (time.Time, bool) {
+	if len(parts) == 5 {
+		var msg models.Message
+		if err := json.Unmarshal(value, &msg); err != nil || msg.Timestamp == 0 {
+			return time.Time{}, false
+		}
+		return time.Unix(msg.Timestamp, 0), true
+	}
+	var session models.Session
+	if err := json.Unmarshal(value, &session); err != nil || session.LastActiveAt() == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(session.LastActiveAt(), 0), true
+}
+
+func (rs *RetentionService) save(job *RetentionJob) error {
+	data, _ := json.Marshal(job)
+	return store.SetWithTTL(retentionKey(job.AppID), data, retentionRecordTTL)
+}
@@ -1,8 +1,11 @@
 package service
 
 import (
+	"cmp"
 	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
@@ -13,8 +16,8 @@ import (
 )
 
 // 1. 会话存储
-// s:{visitor_id}:{app_id}:{session_seq} 为 会话 id
-// s:alice:shop123:1234
+// m:{visitor_id}:{app_id}:{session_seq} 为 会话 id（见 models.GetSessionID）
+// m:alice:shop123:1234
 // │   │     │       │
 // │   │     │       └─ session seq
 // │   │     └─ app_id
@@ -47,8 +50,9 @@ func GetSessionService() *SessionService {
 }
 
 // GetLatestSession 获取访客最新会话（按 session_seq 最大）
+// 会话键由 models.GetSessionID 生成，与消息键共用 m:{visitor_id}:{app_id}: 前缀，遍历时跳过消息
 func (s *SessionService) GetLatestSession(visitorID, appID string) (*models.Session, error) {
-	prefix := fmt.Sprintf("s:%s:%s:", visitorID, appID)
+	prefix := fmt.Sprintf("m:%s:%s:", visitorID, appID)
 	var latestSession *models.Session
 
 	err := s.kv.View(func(txn *badger.Txn) error {
@@ -58,8 +62,12 @@ func (s *SessionService) GetLatestSession(visitorID, appID string) (*models.Sess
 		})
 		defer it.Close()
 
-		for it.Rewind(); it.Valid(); it.Next() {
+		// 反向遍历需从前缀之后开始
+		for it.Seek(append([]byte(prefix), 0xFF)); it.Valid(); it.Next() {
 			item := it.Item()
+			if strings.Count(string(item.Key()), ":") != 3 {
+				continue // 消息键 m:{visitor_id}:{app_id}:{session_seq}:{msg_seq}
+			}
 			val, err := item.ValueCopy(nil)
 			if err != nil {
 				continue
@@ -104,8 +112,10 @@ func (s *SessionService) CreateSession(visitorID, appID string) (*models.Session
 
 	data, _ := json.Marshal(session)
 
+	// 会话按业务保留策略自最后活跃时间起过期
+	expiresAt := models.GetRetention(appID).ExpiresAt(time.Unix(now, 0))
 	err = s.kv.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte(sid), data)
+		return txn.SetEntry(retentionEntry(sid, data, expiresAt))
 	})
 	if err != nil {
 		logger.Errorf("save session %s failed: %v", sid, err)
@@ -119,14 +129,8 @@ func (s *SessionService) CreateSession(visitorID, appID string) (*models.Session
 func (s *SessionService) GetOrCreateSession(visitorID, appID string) (*models.Session, error) {
 	session, err := s.GetLatestSession(visitorID, appID)
 	if err == nil {
-		// 计算最后活跃时间
-		lastActive := session.LastVisitorMsgTime
-		if session.LastAgentReplyTime > lastActive {
-			lastActive = session.LastAgentReplyTime
-		}
-
 		// 如果会话未关闭，但已超时 → 自动关闭并新建
-		if !session.Closed && time.Since(time.Unix(lastActive, 0)) > SessionTimeout {
+		if !session.Closed && time.Since(time.Unix(session.LastActiveAt(), 0)) > SessionTimeout {
 			// 自动关闭旧会话
 			session.Close()
 			s.SaveSession(session) // 持久化关闭状态
@@ -153,8 +157,9 @@ func (s *SessionService) SaveSession(session *models.Session) error {
 	}
 	data, _ := json.Marshal(session)
 
+	expiresAt := models.GetRetention(session.AppID()).ExpiresAt(time.Unix(session.LastActiveAt(), 0))
 	err := s.kv.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte(session.SID), data)
+		return txn.SetEntry(retentionEntry(session.SID, data, expiresAt))
 	})
 	if err != nil {
 		logger.Errorf("save session %s failed: %v", session.SID, err)
@@ -162,6 +167,19 @@ func (s *SessionService) SaveSession(session *models.Session) error {
 	return err
 }
 
+// GetWorkspaceSession 获取工作区内的会话，所属业务不在该工作区的会话视为不存在
+func (s *SessionService) GetWorkspaceSession(workspaceID uint, sessionID string) (*models.Session, error) {
+	session, err := s.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if appWorkspace, ok := models.GetAppWorkspace(session.AppID()); !ok || appWorkspace != workspaceID {
+		logger.Errorf("session %s is not in workspace %d", sessionID, workspaceID)
+		return nil, fmt.Errorf("session not found: %s", sessionID)
+	}
+	return session, nil
+}
+
 // 获取会话内容
 func (s *SessionService) GetSession(sessionID string) (*models.Session, error) {
 	var session *models.Session
@@ -199,3 +217,58 @@ func (s *SessionService) GetSession(sessionID string) (*models.Session, error) {
 	}
 	return session, nil
 }
+
+// SessionFilter 会话列表查询条件
+type SessionFilter struct {
+	AppIDs []string // 只列出这些业务的会话
+	Status string   // 为空时不限状态，见 models.SessionStatus*
+	Offset int
+	Limit  int
+}
+
+// ListSessions 按最后活跃时间倒序列出会话，返回当前页和总数
+// 会话与消息共用 m: 前缀，只按键识别会话键并读取其值
+func (s *SessionService) ListSessions(filter SessionFilter) ([]*models.Session, int, error) {
+	var sessions []*models.Session
+	err := s.kv.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte("m:")})
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			key := string(item.Key())
+			if strings.Count(key, ":") != 3 {
+				continue // 消息键
+			}
+			if _, appID, _ := models.ParseSessionID(key); !slices.Contains(filter.AppIDs, appID) {
+				continue
+			}
+			var sess models.Session
+			if err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &sess)
+			}); err != nil {
+				continue
+			}
+			if filter.Status != "" && sess.Status() != filter.Status {
+				continue
+			}
+			sessions = append(sessions, &sess)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("ListSessions failed: %v", err)
+		return nil, 0, err
+	}
+
+	slices.SortFunc(sessions, func(a, b *models.Session) int {
+		return cmp.Compare(b.LastActiveAt(), a.LastActiveAt())
+	})
+	total := len(sessions)
+	start := min(max(filter.Offset, 0), total)
+	end := total
+	if filter.Limit > 0 {
+		end = min(start+filter.Limit, total)
+	}
+	return sessions[start:end], total, nil
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+
+	"kefu-server/store"
+	"kefu-server/utils"
+	"kefu-server/utils/logger"
+)
+
+// 令牌吊销列表
+// rv:jti:{jti}       单个令牌被吊销，TTL 为令牌剩余有效期
+// rv:user:{user_id}  该时间点（unix 毫秒）及之前签发的该用户令牌全部失效，TTL 为令牌最长有效期
+//
+// 刷新令牌（轮换使用，同一次登录派生出的刷新令牌属于同一 family）
+// rt:{sha256(token)} 刷新令牌记录，TTL 为刷新令牌有效期
+// rf:{family}        family 已被吊销（检测到刷新令牌被重用或登出）
+//
+// WebSocket 票据（浏览器无法在握手时设置 Authorization 头）
+// wst:{ticket}       一次性票据，记录签发时的令牌声明，TTL 为 WSTicketTTL
+
+// RefreshToken 刷新令牌记录
+type RefreshToken struct {
+	UserID   uint   `json:"user_id"`
+	Family   string `json:"family"`
+	IssuedAt int64  `json:"issued_at"` // unix 毫秒
+	Used     bool   `json:"used"`      // 已轮换，再次使用即视为重用
+}
+
+// WSTicketTTL WebSocket 票据有效期
+const WSTicketTTL = 30 * time.Second
+
+var (
+	ErrWSTicketInvalid     = errors.New("websocket ticket invalid or expired")
+	ErrRefreshTokenInvalid = errors.New("refresh token invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+type TokenService struct {
+	kv *badger.DB
+}
+
+var (
+	instTokenService *TokenService
+)
+
+func GetTokenService() *TokenService {
+	if instTokenService != nil {
+		return instTokenService
+	}
+
+	if kv := store.GetStore(); kv == nil { // 单例
+		logger.Errorf("kv is not initialized")
+		return nil
+	} else {
+		instTokenService = &TokenService{kv: kv}
+		return instTokenService
+	}
+}
+
+// RevokeToken 吊销单个令牌
+func (ts *TokenService) RevokeToken(claims *utils.Claims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return fmt.Errorf("token has no jti or expiration")
+	}
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl <= 0 {
+		return nil // 已过期，无需吊销
+	}
+	if err := store.SetWithTTL("rv:jti:"+claims.ID, []byte("1"), ttl); err != nil {
+		logger.Errorf("revoke token %s failed: %v", claims.ID, err)
+		return err
+	}
+	return nil
+}
+
+// RevokeUserTokens 吊销某用户此前签发的全部令牌
+func (ts *TokenService) RevokeUserTokens(userID uint) error {
+	key := fmt.Sprintf("rv:user:%d", userID)
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if err := store.SetWithTTL(key, []byte(now), max(utils.AccessTokenTTL, utils.RefreshTokenTTL)); err != nil {
+		logger.Errorf("revoke tokens of user %d failed: %v", userID, err)
+		return err
+	}
+	return nil
+}
+
+// IsRevoked 判断令牌是否已被吊销（存储异常时按已吊销处理）
+func (ts *TokenService) IsRevoked(claims *utils.Claims) bool {
+	if claims.ID == "" {
+		return true // 没有 jti 的旧令牌无法吊销，直接拒绝
+	}
+
+	if _, err := store.GetValue("rv:jti:" + claims.ID); err == nil {
+		return true
+	} else if err != badger.ErrKeyNotFound {
+		logger.Errorf("check token revocation failed: %v", err)
+		return true
+	}
+
+	if claims.IssuedAt == nil {
+		return true
+	}
+	return ts.isUserRevoked(claims.UserID, claims.IssuedAt.UnixMilli())
+}
+
+// toMillis 兼容以 unix 秒记录的旧数据
+func toMillis(ts int64) int64 {
+	if ts < 1e12 {
+		return ts * 1000
+	}
+	return ts
+}
+
+// isUserRevoked 判断某用户在 issuedAt（unix 毫秒）签发的令牌是否已被整体吊销
+func (ts *TokenService) isUserRevoked(userID uint, issuedAt int64) bool {
+	val, err := store.GetValue(fmt.Sprintf("rv:user:%d", userID))
+	if err == badger.ErrKeyNotFound {
+		return false
+	}
+	if err != nil {
+		logger.Errorf("check user token revocation failed: %v", err)
+		return true
+	}
+	cutoff, _ := strconv.ParseInt(string(val), 10, 64)
+	issuedAt, cutoff = toMillis(issuedAt), toMillis(cutoff)
+	return issuedAt <= cutoff
+}
+
+func refreshTokenKey(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return "rt:" + hex.EncodeToString(hash[:])
+}
+
+// saveRefreshToken 生成并保存一个属于 family 的刷新令牌
+func (ts *TokenService) saveRefreshToken(userID uint, family string) (string, error) {
+	token := utils.GenerateSecureToken(32)
+	record := RefreshToken{
+		UserID:   userID,
+		Family:   family,
+		IssuedAt: time.Now().UnixMilli(),
+	}
+	data, _ := json.Marshal(record)
+	if err := store.SetWithTTL(refreshTokenKey(token), data, utils.RefreshTokenTTL); err != nil {
+		logger.Errorf("save refresh token failed: %v", err)
+		return "", err
+	}
+	return token, nil
+}
+
+// IssueRefreshToken 登录时签发新的刷新令牌（新 family）
+func (ts *TokenService) IssueRefreshToken(userID uint) (string, error) {
+	return ts.saveRefreshToken(userID, utils.GenerateSecureToken(16))
+}
+
+// RotateRefreshToken 使用刷新令牌换取新的刷新令牌，旧令牌标记为已使用。
+// 已使用的令牌被再次提交时吊销整个 family。
+func (ts *TokenService) RotateRefreshToken(token string) (uint, string, error) {
+	key := refreshTokenKey(token)
+	var record RefreshToken
+	var expiresAt uint64
+
+	err := ts.kv.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(val, &record); err != nil {
+			return err
+		}
+		if record.Used {
+			return ErrRefreshTokenReused
+		}
+
+		// 标记为已使用，保留到原过期时间用于重用检测
+		expiresAt = item.ExpiresAt()
+		record.Used = true
+		data, _ := json.Marshal(record)
+		entry := badger.NewEntry([]byte(key), data)
+		entry.ExpiresAt = expiresAt
+		return txn.SetEntry(entry)
+	})
+	if err == ErrRefreshTokenReused {
+		logger.Warnf("refresh token reuse detected, revoking family %s of user %d", record.Family, record.UserID)
+		ts.revokeFamily(record.Family)
+		return 0, "", ErrRefreshTokenReused
+	}
+	if err != nil {
+		if err != badger.ErrKeyNotFound {
+			logger.Errorf("rotate refresh token failed: %v", err)
+		}
+		return 0, "", ErrRefreshTokenInvalid
+	}
+
+	if ts.isFamilyRevoked(record.Family) || ts.isUserRevoked(record.UserID, record.IssuedAt) {
+		return 0, "", ErrRefreshTokenInvalid
+	}
+
+	newToken, err := ts.saveRefreshToken(record.UserID, record.Family)
+	if err != nil {
+		return 0, "", err
+	}
+	return record.UserID, newToken, nil
+}
+
+// RevokeRefreshToken 吊销刷新令牌所属的整个 family（用于登出）
+func (ts *TokenService) RevokeRefreshToken(token string) error {
+	val, err := store.GetValue(refreshTokenKey(token))
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var record RefreshToken
+	if err := json.Unmarshal(val, &record); err != nil {
+		return err
+	}
+	return ts.revokeFamily(record.Family)
+}
+
+func (ts *TokenService) revokeFamily(family string) error {
+	if err := store.SetWithTTL("rf:"+family, []byte("1"), utils.RefreshTokenTTL); err != nil {
+		logger.Errorf("revoke refresh token family %s failed: %v", family, err)
+		return err
+	}
+	return nil
+}
+
+func (ts *TokenService) isFamilyRevoked(family string) bool {
+	_, err := store.GetValue("rf:" + family)
+	if err == badger.ErrKeyNotFound {
+		return false
+	}
+	if err != nil {
+		logger.Errorf("check refresh token family failed: %v", err)
+	}
+	return true
+}
+
+// IssueWSTicket 为已认证的访问令牌签发一次性 WebSocket 票据
+func (ts *TokenService) IssueWSTicket(claims *utils.Claims) (string, error) {
+	ticket := utils.GenerateSecureToken(32)
+	data, _ := json.Marshal(claims)
+	if err := store.SetWithTTL("wst:"+ticket, data, WSTicketTTL); err != nil {
+		logger.Errorf("save ws ticket failed: %v", err)
+		return "", err
+	}
+	return ticket, nil
+}
+
+// TakeWSTicket 消费 WebSocket 票据，签发票据的令牌已被吊销时同样视为无效
+func (ts *TokenService) TakeWSTicket(ticket string) (*utils.Claims, error) {
+	val, err := store.TakeValue("wst:" + ticket)
+	if err != nil {
+		return nil, ErrWSTicketInvalid
+	}
+	var claims utils.Claims
+	if err := json.Unmarshal(val, &claims); err != nil {
+		return nil, ErrWSTicketInvalid
+	}
+	if ts.IsRevoked(&claims) {
+		return nil, ErrWSTicketInvalid
+	}
+	return &claims, nil
+}
@@ -1,10 +1,12 @@
 package service
 
 import (
+	"encoding/json"
 	"fmt"
-	"strings"
+	"slices"
 
 	"github.com/golang-infrastructure/go-shuffle"
+	"gorm.io/gorm"
 
 	"kefu-server/models"
 	"kefu-server/store"
@@ -16,8 +18,31 @@ type UserService struct {
 
 var (
 	instUserService *UserService
+
+	// 用户被禁用或删除时的回调（由控制器层注册，用于断开在线连接）
+	userDisabledHooks []func(username string)
 )
 
+// OnUserDisabled 注册用户被禁用或删除时的回调
+func OnUserDisabled(fn func(username string)) {
+	userDisabledHooks = append(userDisabledHooks, fn)
+}
+
+// revokeUser 吊销用户全部令牌并通知回调
+func (us *UserService) revokeUser(user *models.User) error {
+	ts := GetTokenService()
+	if ts == nil {
+		return fmt.Errorf("token service not initialized")
+	}
+	if err := ts.RevokeUserTokens(user.ID); err != nil {
+		return err
+	}
+	for _, fn := range userDisabledHooks {
+		fn(user.Username)
+	}
+	return nil
+}
+
 func GetUserService() *UserService {
 	if instUserService == nil {
 		instUserService = &UserService{}
@@ -34,14 +59,17 @@ func (us *UserService) GetUser(username string) (*models.User, error) {
 	return &user, nil
 }
 
-func (us *UserService) CreateUser(username, password, avatar, role string, active bool) (*models.User, error) {
+// CreateUser 在工作区内创建用户，用户名全局唯一
+func (us *UserService) CreateUser(workspaceID uint, username, password, avatar, role string, active bool) (*models.User, error) {
 	user := models.User{
-		Username: username,
-		Password: password,
-		Role:     role,
-		Active:   active,
-		Avatar:   avatar,
+		WorkspaceID:        workspaceID,
+		Username:           username,
+		Role:               role,
+		Active:             active,
+		Avatar:             avatar,
+		MustChangePassword: true, // 管理员设置的初始密码，首次登录必须修改
 	}
+	user.SetPassword(password)
 	if err := store.DB.Create(&user).Error; err != nil {
 		logger.Errorf("create user failed: %s", username)
 		return nil, fmt.Errorf("create user failed: %s", username)
@@ -50,17 +78,33 @@ func (us *UserService) CreateUser(username, password, avatar, role string, activ
 }
 
 func (us *UserService) UpdateUser(username, password, avatar, role string, active bool) (*models.User, error) {
+	old, err := us.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+
 	user := models.User{
 		Username: username,
-		Password: password,
 		Role:     role,
 		Active:   active,
 		Avatar:   avatar,
 	}
+	// 密码为空则不修改
+	if password != "" {
+		user.SetPassword(password)
+	}
 	if err := store.DB.Model(&models.User{}).Where("username = ?", username).Updates(&user).Error; err != nil {
 		logger.Errorf("update user failed: %s", username)
 		return nil, fmt.Errorf("update user failed: %s", username)
 	}
+
+	// 角色变化后旧令牌中的角色已失效，强制重新登录
+	if role != "" && role != old.Role {
+		if err := us.revokeUser(old); err != nil {
+			logger.Errorf("failed to revoke user %s: %v", username, err)
+			return nil, fmt.Errorf("failed to revoke user: %v", err)
+		}
+	}
 	return us.GetUser(username)
 
 }
@@ -74,13 +118,114 @@ func (us *UserService) SetUserStatus(username string, status int) error {
 }
 
 func (us *UserService) SetUserActive(username string, active bool) error {
+	user, err := us.GetUser(username)
+	if err != nil {
+		return err
+	}
 	if err := store.DB.Model(&models.User{}).Where("username = ?", username).Update("active", active).Error; err != nil {
 		logger.Errorf("failed to set user active: %v, username: %s, active: %t", err, username, active)
 		return fmt.Errorf("failed to set user active: %v", err)
 	}
+
+	// 禁用后吊销其全部令牌并断开在线连接
+	if !active {
+		if err := us.revokeUser(user); err != nil {
+			logger.Errorf("failed to revoke user %s: %v", username, err)
+			return fmt.Errorf("failed to revoke user: %v", err)
+		}
+	}
+	return nil
+}
+
+// ListUsers 分页查询工作区的用户列表
+func (us *UserService) ListUsers(workspaceID uint, page, pageSize int, keyword, role, appID string, active *bool) ([]models.User, int64, error) {
+	query := store.DB.Model(&models.User{}).Scopes(models.InWorkspace(workspaceID))
+
+	// 关键词搜索
+	if keyword != "" {
+		query = query.Where("username LIKE ?", "%"+keyword+"%")
+	}
+
+	// 角色筛选
+	if role != "" {
+		query = query.Where("role = ?", role)
+	}
+
+	// 业务筛选（Apps 为 json 字符串数组）
+	if appID != "" {
+		query = query.Where("apps LIKE ?", "%\""+appID+"\"%")
+	}
+
+	// 激活状态筛选
+	if active != nil {
+		query = query.Where("active = ?", *active)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		logger.Errorf("count users failed: %v", err)
+		return nil, 0, fmt.Errorf("count users failed: %v", err)
+	}
+
+	var users []models.User
+	offset := (page - 1) * pageSize
+	if err := query.Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&users).Error; err != nil {
+		logger.Errorf("list users failed: %v", err)
+		return nil, 0, fmt.Errorf("list users failed: %v", err)
+	}
+	return users, total, nil
+}
+
+// DeleteUser 删除用户（物理删除，释放用户名）
+func (us *UserService) DeleteUser(username string) error {
+	user, err := us.GetUser(username)
+	if err != nil {
+		return err
+	}
+	if err := store.DB.Unscoped().Where("username = ?", username).Delete(&models.User{}).Error; err != nil {
+		logger.Errorf("failed to delete user: %v, username: %s", err, username)
+		return fmt.Errorf("failed to delete user: %v", err)
+	}
+
+	// 吊销其全部令牌并断开在线连接
+	if err := us.revokeUser(user); err != nil {
+		logger.Errorf("failed to revoke user %s: %v", username, err)
+		return fmt.Errorf("failed to revoke user: %v", err)
+	}
+	return nil
+}
+
+// SetUserApps 设置客服负责的业务
+func (us *UserService) SetUserApps(username string, apps []string) error {
+	data, _ := json.Marshal(apps)
+	if err := store.DB.Model(&models.User{}).Where("username = ?", username).Update("apps", string(data)).Error; err != nil {
+		logger.Errorf("failed to set user apps: %v, username: %s", err, username)
+		return fmt.Errorf("failed to set user apps: %v", err)
+	}
 	return nil
 }
 
+// CountActiveAdmins 统计工作区内处于激活状态的管理员数量（含超级管理员）
+func (us *UserService) CountActiveAdmins(workspaceID uint) (int64, error) {
+	var count int64
+	if err := store.DB.Model(&models.User{}).Scopes(models.InWorkspace(workspaceID)).
+		Where("role IN ? AND active = ?", []string{models.RoleAdmin, models.RoleSuperAdmin}, true).Count(&count).Error; err != nil {
+		logger.Errorf("count admins failed: %v", err)
+		return 0, fmt.Errorf("count admins failed: %v", err)
+	}
+	return count, nil
+}
+
+// CountActiveSuperAdmins 统计整个实例处于激活状态的超级管理员数量
+func (us *UserService) CountActiveSuperAdmins() (int64, error) {
+	var count int64
+	if err := store.DB.Model(&models.User{}).Where("role = ? AND active = ?", models.RoleSuperAdmin, true).Count(&count).Error; err != nil {
+		logger.Errorf("count super admins failed: %v", err)
+		return 0, fmt.Errorf("count super admins failed: %v", err)
+	}
+	return count, nil
+}
+
 func (us *UserService) GetUserByID(id uint) (*models.User, error) {
 	var user models.User
 	if err := store.DB.First(&user, id).Error; err != nil {
@@ -90,14 +235,18 @@ func (us *UserService) GetUserByID(id uint) (*models.User, error) {
 	return &user, nil
 }
 
-// 查找一个能处理此业务的客服
+// 查找一个能处理此业务的客服，只在业务所属的工作区内查找
 func (us *UserService) FindAgent(appID string) (*models.User, error) {
 	var users []models.User
-	// 将 appID 转换为小写
-	lowerAppID := strings.ToLower(appID)
+	workspaceID, ok := models.GetAppWorkspace(appID)
+	if !ok {
+		logger.Errorf("app not found: %s", appID)
+		return nil, fmt.Errorf("app not found")
+	}
 
-	// 先获取所有角色为 agent、状态为在席（1）、激活状态为 true 的用户
-	if err := store.DB.Where("role = ? AND status = ? AND active = ?", "agent", 1, true).Find(&users).Error; err != nil {
+	// 先获取工作区内所有角色为 agent、状态为在席（1）、激活状态为 true 的用户
+	if err := store.DB.Scopes(models.InWorkspace(workspaceID)).
+		Where("role = ? AND status = ? AND active = ?", models.RoleAgent, 1, true).Find(&users).Error; err != nil {
 		logger.Errorf("failed to get agents: %v", err)
 		return nil, fmt.Errorf("failed to get agents")
 	}
@@ -105,20 +254,16 @@ func (us *UserService) FindAgent(appID string) (*models.User, error) {
 	// 把 users 列表 顺序随机排序
 	shuffle.Shuffle(users)
 
-	// 优先查找精确匹配指定 appID 的客服
+	// 优先查找明确负责该业务的客服
 	for _, user := range users {
-		// 将 Apps 字段转换为小写
-		lowerApps := strings.ToLower(user.Apps)
-
-		// 检查是否精确匹配指定的 appID
-		if strings.Contains(lowerApps, fmt.Sprintf("\"%s\"", lowerAppID)) {
+		if apps, _ := user.AppScope(); slices.Contains(apps, appID) {
 			return &user, nil
 		}
 	}
 
-	// 如果没有找到精确匹配的客服，查找包含 "all" 的客服
+	// 如果没有找到明确负责的客服，查找负责全部业务的客服
 	for _, user := range users {
-		if strings.Contains(strings.ToLower(user.Apps), "all") {
+		if _, all := user.AppScope(); all {
 			return &user, nil
 		}
 	}
@@ -127,3 +272,64 @@ func (us *UserService) FindAgent(appID string) (*models.User, error) {
 	logger.Errorf("no available agent found for appID: %s", appID)
 	return nil, fmt.Errorf("no available agent found")
 }
+
+// AppAgents 工作区内接待该业务的用户，含负责全部业务的用户，与客服在线状态列表和分配规则一致
+// 管理员不限业务且不参与接待分配，不列出
+func (us *UserService) AppAgents(workspaceID uint, appID string) ([]string, error) {
+	var users []models.User
+	if err := store.DB.Scopes(models.InWorkspace(workspaceID)).Order("username").Find(&users).Error; err != nil {
+		logger.Errorf("failed to get agents of app %s: %v", appID, err)
+		return nil, fmt.Errorf("failed to get agents of app: %v", err)
+	}
+	agents := []string{}
+	for _, user := range users {
+		if !models.IsAdminRole(user.Role) && user.ServesApp(appID) {
+			agents = append(agents, user.Username)
+		}
+	}
+	return agents, nil
+}
+
+// SetAppAgents 将业务的明确负责人设置为工作区内的 usernames，不在列表中的用户移除该业务
+// 管理员和负责全部业务的用户保持不变，返回工作区内不存在的用户名
+func (us *UserService) SetAppAgents(workspaceID uint, appID string, usernames []string) ([]string, error) {
+	var missing []string
+	err := store.DB.Transaction(func(tx *gorm.DB) error {
+		var users []models.User
+		if err := tx.Scopes(models.InWorkspace(workspaceID)).Where("username IN ? OR apps LIKE ?", usernames, "%\""+appID+"\"%").Find(&users).Error; err != nil {
+			return err
+		}
+		found := map[string]bool{}
+		for _, user := range users {
+			found[user.Username] = true
+			apps, all := user.AppScope()
+			if all {
+				continue
+			}
+			has, want := slices.Contains(apps, appID), slices.Contains(usernames, user.Username)
+			if has == want {
+				continue
+			}
+			if want {
+				apps = append(apps, appID)
+			} else {
+				apps = slices.DeleteFunc(apps, func(app string) bool { return app == appID })
+			}
+			data, _ := json.Marshal(apps)
+			if err := tx.Model(&models.User{}).Where("id = ?", user.ID).Update("apps", string(data)).Error; err != nil {
+				return err
+			}
+		}
+		for _, username := range usernames {
+			if !found[username] {
+				missing = append(missing, username)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Errorf("failed to set agents of app %s: %v", appID, err)
+		return nil, fmt.Errorf("failed to set agents of app: %v", err)
+	}
+	return missing, nil
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"kefu-server/models"
+	"kefu-server/store"
+	"kefu-server/utils/logger"
+)
+
+type WorkspaceService struct {
+}
+
+var (
+	instWorkspaceService *WorkspaceService
+
+	ErrWorkspaceNotEmpty = errors.New("workspace still has apps or users")
+	ErrDefaultWorkspace  = errors.New("default workspace cannot be deleted")
+)
+
+// WorkspaceInfo 工作区及其业务、账号数量
+type WorkspaceInfo struct {
+	models.Workspace
+	AppCount  int64 `json:"app_count"`
+	UserCount int64 `json:"user_count"`
+}
+
+func GetWorkspaceService() *WorkspaceService {
+	if instWorkspaceService == nil {
+		instWorkspaceService = &WorkspaceService{}
+	}
+	return instWorkspaceService
+}
+
+// GetWorkspace 查找工作区，不存在时返回 nil
+func (ws *WorkspaceService) GetWorkspace(id uint) *models.Workspace {
+	var workspace models.Workspace
+	if err := store.DB.First(&workspace, id).Error; err != nil {
+		return nil
+	}
+	return &workspace
+}
+
+// ListWorkspaces 获取全部工作区
+func (ws *WorkspaceService) ListWorkspaces() ([]WorkspaceInfo, error) {
+	var workspaces []models.Workspace
+	if err := store.DB.Order("id").Find(&workspaces).Error; err != nil {
+		logger.Errorf("list workspaces failed: %v", err)
+		return nil, fmt.Errorf("list workspaces failed: %v", err)
+	}
+	list := make([]WorkspaceInfo, 0, len(workspaces))
+	for _, workspace := range workspaces {
+		info := WorkspaceInfo{Workspace: workspace}
+		store.DB.Model(&models.App{}).Scopes(models.InWorkspace(workspace.ID)).Count(&info.AppCount)
+		store.DB.Model(&models.User{}).Scopes(models.InWorkspace(workspace.ID)).Count(&info.UserCount)
+		list = append(list, info)
+	}
+	return list, nil
+}
+
+// CreateWorkspace 创建工作区
+func (ws *WorkspaceService) CreateWorkspace(name, slug string) (*models.Workspace, error) {
+	workspace := models.Workspace{Name: name, Slug: slug, Active: true}
+	if err := store.DB.Create(&workspace).Error; err != nil {
+		logger.Errorf("create workspace failed: %s, %v", slug, err)
+		return nil, fmt.Errorf("create workspace failed: %s", slug)
+	}
+	return &workspace, nil
+}
+
+// UpdateWorkspace 修改名称或启停状态，停用时吊销其账号的全部令牌（超级管理员除外）
+func (ws *WorkspaceService) UpdateWorkspace(id uint, name string, active bool) (*models.Workspace, error) {
+	workspace := ws.GetWorkspace(id)
+	if workspace == nil {
+		return nil, fmt.Errorf("workspace does not exist: %d", id)
+	}
+	if err := store.DB.Model(workspace).Updates(map[string]interface{}{"name": name, "active": active}).Error; err != nil {
+		logger.Errorf("update workspace %d failed: %v", id, err)
+		return nil, fmt.Errorf("update workspace failed: %v", err)
+	}
+
+	if !active {
+		var users []models.User
+		if err := store.DB.Scopes(models.InWorkspace(id)).Where("role <> ?", models.RoleSuperAdmin).Find(&users).Error; err != nil {
+			logger.Errorf("get users of workspace %d failed: %v", id, err)
+			return nil, fmt.Errorf("get users of workspace failed: %v", err)
+		}
+		for i := range users {
+			if err := GetUserService().revokeUser(&users[i]); err != nil {
+				logger.Errorf("failed to revoke user %s: %v", users[i].Username, err)
+				return nil, fmt.Errorf("failed to revoke user: %v", err)
+			}
+		}
+	}
+	return ws.GetWorkspace(id), nil
+}
+
+// DeleteWorkspace 删除空的工作区（含已归档的业务也视为非空）
+func (ws *WorkspaceService) DeleteWorkspace(id uint) error {
+	if id == models.DefaultWorkspaceID {
+		return ErrDefaultWorkspace
+	}
+	var apps, users int64
+	store.DB.Unscoped().Model(&models.App{}).Scopes(models.InWorkspace(id)).Count(&apps)
+	store.DB.Model(&models.User{}).Scopes(models.InWorkspace(id)).Count(&users)
+	if apps > 0 || users > 0 {
+		return ErrWorkspaceNotEmpty
+	}
+
+	err := store.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Scopes(models.InWorkspace(id)).Delete(&models.AppTemplate{}).Error; err != nil {
+			return err
+		}
+		if err := models.DeleteWorkspaceSettings(tx, id); err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&models.Workspace{}, id).Error
+	})
+	if err != nil {
+		logger.Errorf("delete workspace %d failed: %v", id, err)
+		return fmt.Errorf("delete workspace failed: %v", err)
+	}
+	return nil
+}
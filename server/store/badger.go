@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"kefu-server/utils/logger"
 	"strconv"
+	"time"
 
 	"github.com/dgraph-io/badger/v4"
 )
@@ -73,3 +74,110 @@ func NewMsgSeq() (uint32, error) {
 	}
 	return seq, nil
 }
+
+// SetWithTTL 写入带过期时间的键值（ttl <= 0 表示永久）
+func SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	if KV == nil {
+		return fmt.Errorf("store not initialized")
+	}
+	return KV.Update(func(txn *badger.Txn) error {
+		entry := badger.NewEntry([]byte(key), value)
+		if ttl > 0 {
+			entry = entry.WithTTL(ttl)
+		}
+		return txn.SetEntry(entry)
+	})
+}
+
+// GetValue 读取键值，键不存在时返回 badger.ErrKeyNotFound
+func GetValue(key string) ([]byte, error) {
+	if KV == nil {
+		return nil, fmt.Errorf("store not initialized")
+	}
+	var val []byte
+	err := KV.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		val, err = item.ValueCopy(nil)
+		return err
+	})
+	return val, err
+}
+
+// TakeValue 原子读取并删除键值（用于一次性凭据）
+func TakeValue(key string) ([]byte, error) {
+	if KV == nil {
+		return nil, fmt.Errorf("store not initialized")
+	}
+	var val []byte
+	err := KV.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		if val, err = item.ValueCopy(nil); err != nil {
+			return err
+		}
+		return txn.Delete([]byte(key))
+	})
+	return val, err
+}
+
+// DeleteKey 删除键值
+func DeleteKey(key string) error {
+	if KV == nil {
+		return fmt.Errorf("store not initialized")
+	}
+	return KV.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(key))
+	})
+}
+
+// SetIfAbsent 键不存在时写入，返回是否写入成功（用于一次性 nonce 等去重）
+func SetIfAbsent(key string, value []byte, ttl time.Duration) (bool, error) {
+	if KV == nil {
+		return false, fmt.Errorf("store not initialized")
+	}
+	written := false
+	err := KV.Update(func(txn *badger.Txn) error {
+		if _, err := txn.Get([]byte(key)); err == nil {
+			return nil
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+		entry := badger.NewEntry([]byte(key), value)
+		if ttl > 0 {
+			entry = entry.WithTTL(ttl)
+		}
+		written = true
+		return txn.SetEntry(entry)
+	})
+	if err != nil {
+		return false, err
+	}
+	return written, nil
+}
+
+// ScanPrefix 遍历指定前缀的全部键值
+func ScanPrefix(prefix string, fn func(key string, value []byte) error) error {
+	if KV == nil {
+		return fmt.Errorf("store not initialized")
+	}
+	return KV.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefix), PrefetchValues: true})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if err := fn(string(item.Key()), val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -1,47 +1,331 @@
 package utils
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 
+	"kefu-server/config"
 	"kefu-server/utils/logger"
 )
 
-const SecretKey = "crm-chat-secret-key-2026"
+// 令牌有效期，启动时由 InitJWT 根据配置设置
+var (
+	AccessTokenTTL          = 15 * time.Minute
+	RefreshTokenTTL         = 7 * 24 * time.Hour
+	VisitorTokenTTL         = 24 * time.Hour
+	VisitorTokenRenewWindow = 30 * 24 * time.Hour
+)
+
+// visitorAudience 访客令牌的 aud，员工令牌不设置 aud，两者不能互用
+const visitorAudience = "kefu-visitor"
+
+// jwtKey 一个签名/验证密钥
+type jwtKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	signKey   interface{} // 为 nil 表示仅用于验证
+	verifyKey interface{}
+}
+
+var (
+	jwtKeys    = map[string]*jwtKey{} // kid => key
+	signingKey *jwtKey
+)
 
 type Claims struct {
 	UserID   uint   `json:"user_id"`
 	UserName string `json:"user_name"`
 	Role     string `json:"role"`
+	// 所属工作区，升级前签发的令牌为 0，按缺省工作区处理
+	WorkspaceID uint `json:"workspace_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID uint, userName, role string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+// VisitorClaims 匿名访客令牌，sub 为 visitor_id
+type VisitorClaims struct {
+	AppID string `json:"app_id"`
+	jwt.RegisteredClaims
+}
+
+// InitJWT 根据配置设置令牌有效期并加载签名密钥
+func InitJWT(cfg config.AuthConfig) error {
+	AccessTokenTTL = cfg.AccessTokenTTL
+	RefreshTokenTTL = cfg.RefreshTokenTTL
+	VisitorTokenTTL = cfg.VisitorTokenTTL
+	VisitorTokenRenewWindow = cfg.VisitorTokenRenewWindow
+	// 签发时间精确到毫秒，吊销后立即重新登录签发的令牌不会被误判为已吊销
+	jwt.TimePrecision = time.Millisecond
+
+	keys := map[string]*jwtKey{}
+	if len(cfg.Keys) == 0 {
+		// 未配置密钥时使用本部署自动生成的密钥
+		key, err := loadOrCreateKeyFile(cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		keys[key.kid] = key
+		cfg.SigningKey = key.kid
+	}
+	for _, kc := range cfg.Keys {
+		key, err := loadKey(kc)
+		if err != nil {
+			return fmt.Errorf("load jwt key %q: %w", kc.Kid, err)
+		}
+		if _, ok := keys[key.kid]; ok {
+			return fmt.Errorf("duplicate jwt key id %q", key.kid)
+		}
+		keys[key.kid] = key
+	}
+
+	kid := cfg.SigningKey
+	if kid == "" {
+		kid = cfg.Keys[0].Kid
+	}
+	active, ok := keys[kid]
+	if !ok {
+		return fmt.Errorf("signing key %q not found", kid)
+	}
+	if active.signKey == nil {
+		return fmt.Errorf("signing key %q has no private key", kid)
+	}
+
+	jwtKeys = keys
+	signingKey = active
+	logger.Infof("jwt keys loaded: %d, signing with kid=%s alg=%s", len(keys), active.kid, active.method.Alg())
+	return nil
+}
+
+// loadKey 由配置加载单个密钥
+func loadKey(kc config.JWTKey) (*jwtKey, error) {
+	if kc.Kid == "" {
+		return nil, fmt.Errorf("kid is required")
+	}
+	key := &jwtKey{kid: kc.Kid}
+
+	switch kc.Alg {
+	case "HS256":
+		secret := []byte(kc.Secret)
+		if kc.SecretFile != "" {
+			data, err := os.ReadFile(filepath.Clean(kc.SecretFile))
+			if err != nil {
+				return nil, err
+			}
+			secret = []byte(strings.TrimSpace(string(data)))
+		}
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("HS256 secret must be at least 32 bytes")
+		}
+		key.method = jwt.SigningMethodHS256
+		key.signKey, key.verifyKey = secret, secret
+
+	case "RS256":
+		key.method = jwt.SigningMethodRS256
+		if kc.PrivateKeyFile != "" {
+			data, err := os.ReadFile(filepath.Clean(kc.PrivateKeyFile))
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey, key.verifyKey = priv, &priv.PublicKey
+		} else if kc.PublicKeyFile != "" {
+			data, err := os.ReadFile(filepath.Clean(kc.PublicKeyFile))
+			if err != nil {
+				return nil, err
+			}
+			if key.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(data); err != nil {
+				return nil, err
+			}
+		} else {
+			return nil, fmt.Errorf("RS256 key requires private_key_file or public_key_file")
+		}
+
+	case "EdDSA":
+		key.method = jwt.SigningMethodEdDSA
+		if kc.PrivateKeyFile != "" {
+			data, err := os.ReadFile(filepath.Clean(kc.PrivateKeyFile))
+			if err != nil {
+				return nil, err
+			}
+			priv, err := jwt.ParseEdPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey, key.verifyKey = priv, priv.(ed25519.PrivateKey).Public()
+		} else if kc.PublicKeyFile != "" {
+			data, err := os.ReadFile(filepath.Clean(kc.PublicKeyFile))
+			if err != nil {
+				return nil, err
+			}
+			if key.verifyKey, err = jwt.ParseEdPublicKeyFromPEM(data); err != nil {
+				return nil, err
+			}
+		} else {
+			return nil, fmt.Errorf("EdDSA key requires private_key_file or public_key_file")
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported alg %q", kc.Alg)
+	}
+	return key, nil
+}
+
+// loadOrCreateKeyFile 读取密钥文件，不存在时生成随机 HS256 密钥并保存
+func loadOrCreateKeyFile(path string) (*jwtKey, error) {
+	path = filepath.Clean(path)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		data = []byte(hex.EncodeToString(secret))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, err
+		}
+		logger.Warnf("generated new jwt signing key: %s", path)
+	} else if err != nil {
+		return nil, err
+	}
+
+	secret := []byte(strings.TrimSpace(string(data)))
+	// kid 由密钥摘要派生，密钥变化时旧令牌自然失效
+	sum := sha256.Sum256(secret)
+	return &jwtKey{
+		kid:       hex.EncodeToString(sum[:4]),
+		method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}, nil
+}
+
+func GenerateToken(userID uint, userName, role string, workspaceID uint) (string, error) {
+	if signingKey == nil {
+		return "", fmt.Errorf("jwt signing key not initialized")
+	}
+	now := time.Now()
 	claims := &Claims{
 		UserID:   userID,
 		UserName: userName,
 		Role:     role,
+
+		WorkspaceID: workspaceID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			ID:        GenerateSecureToken(16), // jti，用于吊销
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(SecretKey))
+	token := jwt.NewWithClaims(signingKey.method, claims)
+	token.Header["kid"] = signingKey.kid
+	return token.SignedString(signingKey.signKey)
+}
+
+// keyFunc 按 kid 选择验证密钥
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := jwtKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	// 防止算法混淆：令牌算法必须与密钥算法一致
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for kid %q", token.Method.Alg(), kid)
+	}
+	return key.verifyKey, nil
 }
 
 func ParseToken(tokenStr string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(SecretKey), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, keyFunc)
 	if err != nil {
 		logger.Error("ParseToken error:", err)
 		return nil, err
 	}
 	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		// 带 aud 的是访客令牌，不能作为员工令牌使用
+		if len(claims.Audience) > 0 {
+			return nil, fmt.Errorf("unexpected audience %v", claims.Audience)
+		}
 		return claims, nil
 	}
 	logger.Error("ParseToken error:", err)
 	return nil, err
 }
+
+// GenerateVisitorToken 为匿名访客签发令牌，绑定 visitor_id 和业务
+func GenerateVisitorToken(visitorID, appID string) (string, time.Time, error) {
+	if signingKey == nil {
+		return "", time.Time{}, fmt.Errorf("jwt signing key not initialized")
+	}
+	now := time.Now()
+	expiresAt := now.Add(VisitorTokenTTL)
+	claims := &VisitorClaims{
+		AppID: appID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   visitorID,
+			Audience:  jwt.ClaimStrings{visitorAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	token := jwt.NewWithClaims(signingKey.method, claims)
+	token.Header["kid"] = signingKey.kid
+	signed, err := token.SignedString(signingKey.signKey)
+	return signed, expiresAt, err
+}
+
+// ParseVisitorToken 校验访客令牌，leeway 为允许的过期时间（续期时传入续期窗口）
+func ParseVisitorToken(tokenStr string, leeway time.Duration) (*VisitorClaims, error) {
+	claims := &VisitorClaims{}
+	if _, err := jwt.ParseWithClaims(tokenStr, claims, keyFunc,
+		jwt.WithAudience(visitorAudience), jwt.WithExpirationRequired(), jwt.WithLeeway(leeway)); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" || claims.AppID == "" {
+		return nil, fmt.Errorf("visitor token missing subject or app")
+	}
+	return claims, nil
+}
+
+// PublicJWKS 以 JWK 格式导出非对称验证公钥，供其他服务验证令牌
+func PublicJWKS() []map[string]string {
+	jwks := []map[string]string{}
+	for _, key := range jwtKeys {
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, map[string]string{
+				"kty": "RSA",
+				"kid": key.kid,
+				"alg": key.method.Alg(),
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks = append(jwks, map[string]string{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"kid": key.kid,
+				"alg": key.method.Alg(),
+				"use": "sig",
+				"x":   base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	return jwks
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// 存储的是登录验证器而不是密码哈希：客户端以 PBKDF2-SHA256(预哈希, 盐) 派生 Ed25519 私钥，
+// 服务端只保存对应的公钥，登录时验证客户端对一次性挑战的签名。公钥与签名都不能用来登录，
+// 服务端既不保存也不接收与密码等价的数据。浏览器没有原生的 argon2id，密钥派生使用 WebCrypto 支持的 PBKDF2
+const (
+	VerifierIterations = 600000 // OWASP 对 PBKDF2-HMAC-SHA256 的推荐值
+	VerifierSaltLen    = 16
+
+	verifierPrefix = "$ed25519-pbkdf2-sha256$"
+)
+
+// 早期版本存储的 argon2id(预哈希)，账号下次登录时升级为验证器
+const legacyHashPrefix = "$argon2id$"
+
+// PasswordVerifier 解析后的登录验证器
+type PasswordVerifier struct {
+	Salt       []byte
+	Iterations int
+	PublicKey  ed25519.PublicKey
+}
+
+// PrehashPassword 计算密码的 SHA256 预哈希（与前端一致，密钥派生以预哈希为输入）
+func PrehashPassword(password string) string {
+	hash := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(hash[:])
+}
+
+// NewVerifierSalt 生成验证器的随机盐
+func NewVerifierSalt() []byte {
+	salt := make([]byte, VerifierSaltLen)
+	rand.Read(salt)
+	return salt
+}
+
+// NewPasswordVerifier 以随机盐由预哈希生成验证器，返回存储格式的字符串
+func NewPasswordVerifier(prehash string) string {
+	return DerivePasswordVerifier(prehash, NewVerifierSalt(), VerifierIterations).String()
+}
+
+// DerivePasswordVerifier 按指定的盐和迭代次数由预哈希派生验证器
+func DerivePasswordVerifier(prehash string, salt []byte, iterations int) *PasswordVerifier {
+	seed, _ := pbkdf2.Key(sha256.New, prehash, salt, iterations, ed25519.SeedSize)
+	return &PasswordVerifier{
+		Salt:       salt,
+		Iterations: iterations,
+		PublicKey:  ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey),
+	}
+}
+
+// String 存储格式：$ed25519-pbkdf2-sha256$i=600000$盐$公钥
+func (v *PasswordVerifier) String() string {
+	return fmt.Sprintf("%si=%d$%s$%s", verifierPrefix, v.Iterations,
+		base64.RawStdEncoding.EncodeToString(v.Salt),
+		base64.RawStdEncoding.EncodeToString(v.PublicKey))
+}
+
+// ParsePasswordVerifier 解析存储的验证器，旧哈希或格式错误时返回 nil
+func ParsePasswordVerifier(encoded string) *PasswordVerifier {
+	rest, ok := strings.CutPrefix(encoded, verifierPrefix)
+	if !ok {
+		return nil
+	}
+	parts := strings.Split(rest, "$")
+	if len(parts) != 3 {
+		return nil
+	}
+	var iterations int
+	if _, err := fmt.Sscanf(parts[0], "i=%d", &iterations); err != nil || iterations <= 0 {
+		return nil
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil
+	}
+	return &PasswordVerifier{Salt: salt, Iterations: iterations, PublicKey: key}
+}
+
+// VerifySignature 验证客户端用派生私钥对 message 的签名
+func (v *PasswordVerifier) VerifySignature(message, signature []byte) bool {
+	return len(signature) == ed25519.SignatureSize && ed25519.Verify(v.PublicKey, message, signature)
+}
+
+// MatchPassword 校验预哈希是否与存储的验证器或旧哈希对应，用于密码历史比对和旧哈希升级
+func MatchPassword(prehash, encoded string) bool {
+	if v := ParsePasswordVerifier(encoded); v != nil {
+		derived := DerivePasswordVerifier(prehash, v.Salt, v.Iterations)
+		return subtle.ConstantTimeCompare(derived.PublicKey, v.PublicKey) == 1
+	}
+	return verifyLegacyHash(prehash, encoded)
+}
+
+// verifyLegacyHash 校验预哈希是否与旧的 argon2id 哈希匹配
+func verifyLegacyHash(prehash, encoded string) bool {
+	parts := strings.Split(encoded, "$")
+	// ["", "argon2id", "v=19", "m=65536,t=3,p=2", salt, hash]
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+
+	key := argon2.IDKey([]byte(prehash), salt, time, memory, threads, uint32(len(expected)))
+	return subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+// IsPasswordHashed 判断存储的密码是否已是验证器或旧哈希（否则为早期的明文密码）
+func IsPasswordHashed(encoded string) bool {
+	return strings.HasPrefix(encoded, verifierPrefix) || IsLegacyPasswordHash(encoded)
+}
+
+// IsLegacyPasswordHash 判断存储的密码是否为待升级的旧 argon2id 哈希
+func IsLegacyPasswordHash(encoded string) bool {
+	return strings.HasPrefix(encoded, legacyHashPrefix)
+}
+
+// GenerateSecureToken 生成指定字节数的安全随机串（hex 编码）
+func GenerateSecureToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestPasswordVerifier(t *testing.T) {
+	prehash := PrehashPassword("12345678")
+	salt := []byte("0123456789abcdef")
+	verifier := DerivePasswordVerifier(prehash, salt, 1000)
+
+	parsed := ParsePasswordVerifier(verifier.String())
+	if parsed == nil || parsed.Iterations != 1000 || string(parsed.Salt) != string(salt) || !parsed.PublicKey.Equal(verifier.PublicKey) {
+		t.Fatalf("ParsePasswordVerifier(%s) = %+v", verifier, parsed)
+	}
+
+	// 客户端以同样的参数派生私钥签名
+	seed, _ := pbkdf2.Key(sha256.New, prehash, salt, 1000, ed25519.SeedSize)
+	message := []byte("kefu-login\nadmin\nchallenge\n1700000000000\nnonce")
+	signature := ed25519.Sign(ed25519.NewKeyFromSeed(seed), message)
+	if !parsed.VerifySignature(message, signature) {
+		t.Error("VerifySignature rejected a valid signature")
+	}
+	if parsed.VerifySignature([]byte("kefu-login\nadmin\nother"), signature) {
+		t.Error("VerifySignature accepted a signature over another message")
+	}
+	if parsed.VerifySignature(message, signature[:32]) {
+		t.Error("VerifySignature accepted a truncated signature")
+	}
+
+	if !MatchPassword(prehash, verifier.String()) {
+		t.Error("MatchPassword rejected the right password")
+	}
+	if MatchPassword(PrehashPassword("wrong"), verifier.String()) {
+		t.Error("MatchPassword accepted a wrong password")
+	}
+}
+
+func TestParsePasswordVerifierInvalid(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		"12345678",
+		"$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$aGFzaA",
+		"$ed25519-pbkdf2-sha256$i=0$c2FsdA$AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA",
+		"$ed25519-pbkdf2-sha256$i=1000$c2FsdA$c2hvcnQ",
+		"$ed25519-pbkdf2-sha256$i=1000$c2FsdA",
+	} {
+		if v := ParsePasswordVerifier(encoded); v != nil {
+			t.Errorf("ParsePasswordVerifier(%q) = %+v, want nil", encoded, v)
+		}
+	}
+}
@@ -14,6 +14,17 @@ const (
 	ErrCodeInvalidCredentials ErrorCode = 2001 // 登录相关错误
 	ErrCodeTokenExpired       ErrorCode = 2002
 	ErrCodeTokenInvalid       ErrorCode = 2003
+	ErrCodeLoginLocked        ErrorCode = 2004
+	ErrCodeMFACodeInvalid     ErrorCode = 2005
+	ErrCodeUserExists         ErrorCode = 3001 // 用户管理相关错误
+	ErrCodeLastAdmin          ErrorCode = 3002
+	ErrCodePasswordPolicy     ErrorCode = 3003
+	ErrCodePasswordReused     ErrorCode = 3004
+	ErrCodeAgentOffline       ErrorCode = 3005
+	ErrCodePurgeNotConfirmed  ErrorCode = 4001 // 业务管理相关错误
+	ErrCodePurgeRunning       ErrorCode = 4002
+	ErrCodeVisitorIdentity    ErrorCode = 4003
+	ErrCodeWorkspaceNotEmpty  ErrorCode = 4004
 )
 
 // ErrorMessages 错误码到错误消息的映射
@@ -28,4 +39,15 @@ var ErrorMessages = map[ErrorCode]string{
 	ErrCodeInvalidCredentials: "invalid username or password", // 登录相关错误
 	ErrCodeTokenExpired:       "token expired",
 	ErrCodeTokenInvalid:       "invalid token",
+	ErrCodeLoginLocked:        "too many failed login attempts, please try again later",
+	ErrCodeMFACodeInvalid:     "invalid verification code",
+	ErrCodeUserExists:         "username already exists", // 用户管理相关错误
+	ErrCodeLastAdmin:          "cannot remove the last admin",
+	ErrCodePasswordPolicy:     "password does not meet the password policy",
+	ErrCodePasswordReused:     "password was used recently",
+	ErrCodeAgentOffline:       "agent is not connected",
+	ErrCodePurgeNotConfirmed:  "purge must be confirmed with the app id", // 业务管理相关错误
+	ErrCodePurgeRunning:       "purge is already running for this app",
+	ErrCodeVisitorIdentity:    "visitor identity verification failed",
+	ErrCodeWorkspaceNotEmpty:  "workspace still has apps or users",
 }
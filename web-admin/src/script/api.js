@@ -25,6 +25,9 @@ class ApiService {
         if (store.token) {
           config.headers.Authorization = `Bearer ${store.token}`
         }
+        if (store.workspaceId) {
+          config.headers['X-Workspace-ID'] = store.workspaceId
+        }
         return config
       },
       error => {
@@ -37,7 +40,22 @@ class ApiService {
       response => {
         return response
       },
-      error => {
+      async error => {
+        // 访问令牌过期时使用刷新令牌换取新令牌后重试一次
+        const original = error.config
+        const store = useStore()
+        if (error.response?.status === 401 && store.refreshToken && original && !original._retried &&
+          !original.url.startsWith('/login') && !original.url.startsWith('/oidc') && original.url !== '/token/refresh') {
+          original._retried = true
+          try {
+            await this.refreshToken()
+            original.headers.Authorization = `Bearer ${store.token}`
+            return this.api(original)
+          } catch (e) {
+            store.clearUser()
+          }
+        }
+
         const errorData = error.response?.data || {}
         const errorMsg = errorData.msg || error.message || '请求失败'
         return Promise.reject(new Error(errorMsg))
@@ -45,9 +63,17 @@ class ApiService {
     )
   }
 
-  setToken(token) {
+  setToken(token, refreshToken) {
+    const store = useStore()
+    store.setTokens(token, refreshToken)
+  }
+
+  // 刷新访问令牌（刷新令牌同时轮换）
+  async refreshToken() {
     const store = useStore()
-    store.token = token
+    const { data } = await this.api.post('/token/refresh', { refresh_token: store.refreshToken })
+    this.setToken(data.data.token, data.data.refresh_token)
+    return data
   }
 
   removeToken() {
@@ -65,62 +91,131 @@ class ApiService {
     return hashHex
   }
 
-  // 生成签名（使用密码的1次hash作为密钥）
-  async generateSignature(username, passwordHash1, passwordHash2, timestamp, nonce) {
-    const crypto = window.crypto || window.msCrypto
-    const data = username + passwordHash2 + timestamp + nonce
-    const secretKey = passwordHash1 // 使用密码的1次hash作为密钥
-    
-    return new Promise((resolve) => {
-      crypto.subtle.importKey(
-        'raw',
-        new TextEncoder().encode(secretKey),
-        { name: 'HMAC', hash: { name: 'SHA-256' } },
-        false,
-        ['sign']
-      ).then(key => {
-        crypto.subtle.sign(
-          'HMAC',
-          key,
-          new TextEncoder().encode(data)
-        ).then(signature => {
-          const hexSignature = Array.from(new Uint8Array(signature))
-            .map(b => b.toString(16).padStart(2, '0'))
-            .join('')
-          resolve(hexSignature)
-        })
-      })
-    })
+  // 生成随机 nonce，服务端在时间戳有效期内拒绝重复的 nonce
+  generateNonce() {
+    const bytes = crypto.getRandomValues(new Uint8Array(16))
+    return Array.from(bytes).map(b => b.toString(16).padStart(2, '0')).join('')
   }
 
-  // 生成随机nonce
-  generateNonce() {
-    return Math.random().toString(36).substring(2, 15) + Math.random().toString(36).substring(2, 15)
+  // 由密码预哈希派生 Ed25519 登录私钥：PBKDF2-SHA256 得到 32 字节种子，包装为 PKCS#8 导入
+  async deriveLoginKey(prehash, saltHex, iterations) {
+    const salt = new Uint8Array(saltHex.match(/../g).map(h => parseInt(h, 16)))
+    const material = await crypto.subtle.importKey('raw', new TextEncoder().encode(prehash), 'PBKDF2', false, ['deriveBits'])
+    const seed = await crypto.subtle.deriveBits({ name: 'PBKDF2', hash: 'SHA-256', salt, iterations }, material, 256)
+    const pkcs8 = new Uint8Array([0x30, 0x2e, 0x02, 0x01, 0x00, 0x30, 0x05, 0x06, 0x03, 0x2b, 0x65, 0x70, 0x04, 0x22, 0x04, 0x20, ...new Uint8Array(seed)])
+    return crypto.subtle.importKey('pkcs8', pkcs8, { name: 'Ed25519' }, false, ['sign'])
   }
 
-  // 登录
-  async login(username, password) {
-    // 对密码进行1次hash
-    const passwordHash1 = await this.hashPassword(password)
-    // 对密码的1次hash再进行1次hash，得到密码的2次hash
-    const passwordHash2 = await this.hashPassword(passwordHash1)
+  // 证明持有密码：取一次性挑战，用派生的私钥签名，服务端只保存对应公钥
+  // 旧账号（legacy）还需附上预哈希，服务端据此升级为公钥，之后不再接受预哈希
+  async passwordProof(username, password) {
+    const prehash = await this.hashPassword(password)
+    const data = await this.api.post('/login/challenge', { username })
+    const { challenge, salt, iterations, legacy } = data.data.data
+    const key = await this.deriveLoginKey(prehash, salt, iterations)
     const timestamp = Date.now().toString()
     const nonce = this.generateNonce()
-    // 使用密码的1次hash作为签名密钥
-    const signature = await this.generateSignature(username, passwordHash1, passwordHash2, timestamp, nonce)
-    
-    const data = await this.api.post('/login', {
-      username,
-      password: passwordHash2, // 传递2次hash后的密码
+    const message = new TextEncoder().encode(['kefu-login', username, challenge, timestamp, nonce].join('\n'))
+    const signature = new Uint8Array(await crypto.subtle.sign('Ed25519', key, message))
+    return {
+      challenge,
       timestamp,
       nonce,
-      signature
-    })
+      signature: Array.from(signature).map(b => b.toString(16).padStart(2, '0')).join(''),
+      password: legacy ? prehash : undefined
+    }
+  }
+
+  // 登录
+  async login(username, password) {
+    const proof = await this.passwordProof(username, password)
+    const data = await this.api.post('/login', { username, ...proof })
     
-    this.setToken(data.data.token)
+    if (!data.data.data.mfa_required && !data.data.data.password_change_required) {
+      this.setToken(data.data.data.token, data.data.data.refresh_token)
+    }
+    return data
+  }
+
+  // 两步验证：登录过程中绑定验证器
+  async setupMfa(mfaToken) {
+    return this.api.post('/login/mfa/setup', { mfa_token: mfaToken })
+  }
+
+  // 两步验证：提交验证码完成登录
+  async verifyMfa(mfaToken, code) {
+    const data = await this.api.post('/login/mfa', { mfa_token: mfaToken, code })
+    if (!data.data.data.password_change_required) {
+      this.setToken(data.data.data.token, data.data.data.refresh_token)
+    }
+    return data
+  }
+
+  // 登录页可用的登录方式
+  async getLoginOptions() {
+    return this.api.get('/login/options')
+  }
+
+  // 单点登录：跳转到 IdP 的入口地址
+  oidcLoginUrl() {
+    return `${this.baseURL}/oidc/login`
+  }
+
+  // 单点登录：用回调带回的一次性登录码换取令牌
+  async oidcExchange(code) {
+    const data = await this.api.post('/oidc/exchange', { code })
+    this.setToken(data.data.data.token, data.data.data.refresh_token)
+    return data
+  }
+
+  // 获取客服 WebSocket 一次性票据，连接地址为 /ws/agent?ticket=xxx
+  async getWsTicket() {
+    return this.api.post('/ws/ticket')
+  }
+
+  // 切换自己的在线状态：online / away / busy / offline，除 offline 外需已连接客服 WebSocket
+  async setPresence(status) {
+    return this.api.put('/user/presence', { status })
+  }
+
+  // 获取客服在线状态列表
+  async listPresence(appId) {
+    return this.api.get('/presence/list', { params: { app_id: appId } })
+  }
+
+  // 会话列表：app_id / status / page / page_size
+  async listSessions(params) {
+    return this.api.get('/sessions/list', { params })
+  }
+
+  // 会话聊天记录，before 为消息 id 时向前翻页
+  async listSessionMessages(sessionId, before, limit) {
+    return this.api.get('/sessions/messages', { params: { session_id: sessionId, before, limit } })
+  }
+
+  // 以客服身份向会话的访客发送消息，message 为 { msg_type, content, url, name, size, duration }
+  async sendSessionMessage(sessionId, message) {
+    return this.api.post('/sessions/send', { session_id: sessionId, message })
+  }
+
+  // 修改自己的密码（以登录挑战的签名证明当前密码），成功后需重新登录
+  async changePassword(currentPassword, newPassword) {
+    const current = await this.passwordProof(useStore().user.name, currentPassword)
+    const data = await this.api.post('/user/password', { current, new_password: newPassword })
+    this.removeToken()
     return data
   }
 
+  // 凭一次性令牌设置新密码（管理员重置或登录时被要求修改密码）
+  async completePasswordReset(token, newPassword) {
+    return this.api.post('/password/reset', { token, new_password: newPassword })
+  }
+
+  // 管理员重置客服密码，返回一次性重置令牌
+  async resetUserPassword(username) {
+    return this.api.put('/users/password/reset', { username })
+  }
+
   // 获取用户信息
   async getUserInfo() {
     return this.api.get('/user/info')
@@ -128,7 +223,8 @@ class ApiService {
 
   // 登出
   async logout() {
-    await this.api.post('/logout')
+    const store = useStore()
+    await this.api.post('/logout', { refresh_token: store.refreshToken })
     this.removeToken()
   }
 
@@ -148,6 +244,136 @@ class ApiService {
   async deleteApp(appId) {
     return this.api.delete('/apps/delete', { params: { app_id: appId } })
   }
+
+  // 恢复已归档的应用（恢复后为停用状态）
+  async restoreApp(appId) {
+    return this.api.put('/apps/restore', null, { params: { app_id: appId } })
+  }
+
+  // 清除已归档应用的全部会话和消息，confirm 需再次填写 app_id
+  async purgeApp(appId, confirm) {
+    return this.api.post('/apps/purge', { app_id: appId, confirm })
+  }
+
+  // 查询清除进度
+  async getPurgeStatus(appId) {
+    return this.api.get('/apps/purge/status', { params: { app_id: appId } })
+  }
+
+  // 查询保留策略重新应用的进度
+  async getRetentionStatus(appId) {
+    return this.api.get('/apps/retention/status', { params: { app_id: appId } })
+  }
+
+  // 查看访客身份签名密钥
+  async getIdentitySecret(appId) {
+    return this.api.get('/apps/identity', { params: { app_id: appId } })
+  }
+
+  // 轮换访客身份签名密钥，旧密钥签发的 user_hash / identity_token 立即失效
+  async rotateIdentitySecret(appId) {
+    return this.api.post('/apps/identity/rotate', { app_id: appId })
+  }
+
+  // 导出应用配置，format 为 json 或 yaml，返回文件内容
+  async exportApp(appId, format = 'json') {
+    return this.api.get('/apps/export', { params: { app_id: appId, format }, responseType: 'text' })
+  }
+
+  // 导入应用配置，mode 为 create（新建）或 update（覆盖 appId 对应应用）
+  async importApp(content, mode = 'create', appId = '') {
+    return this.api.post('/apps/import', content, {
+      params: { mode, app_id: appId || undefined },
+      headers: { 'Content-Type': 'text/plain' }
+    })
+  }
+
+  // 应用模板
+  async listAppTemplates() {
+    return this.api.get('/apps/templates/list')
+  }
+
+  // data 中 config 与 from_app_id 二选一
+  async createAppTemplate(data) {
+    return this.api.post('/apps/templates/create', data)
+  }
+
+  async updateAppTemplate(data) {
+    return this.api.put('/apps/templates/update', data)
+  }
+
+  async deleteAppTemplate(name) {
+    return this.api.delete('/apps/templates/delete', { params: { name } })
+  }
+
+  // 审计日志
+  async listAuditLogs(params) {
+    return this.api.get('/audit', { params })
+  }
+
+  // API Key 管理（密钥明文仅在创建和轮换时返回一次）
+  async listApiKeys(appId) {
+    return this.api.get('/apikeys/list', { params: { app_id: appId } })
+  }
+
+  async createApiKey(data) {
+    return this.api.post('/apikeys/create', data)
+  }
+
+  async rotateApiKey(id) {
+    return this.api.post('/apikeys/rotate', { id })
+  }
+
+  async revokeApiKey(id) {
+    return this.api.delete('/apikeys/revoke', { params: { id } })
+  }
+
+  // 客服管理
+  async listUsers(params) {
+    return this.api.get('/users/list', { params })
+  }
+
+  async createUser(data) {
+    return this.api.post('/users/create', data)
+  }
+
+  async updateUser(data) {
+    return this.api.put('/users/update', data)
+  }
+
+  async setUserActive(username, active) {
+    return this.api.put('/users/active', { username, active })
+  }
+
+  async deleteUser(username) {
+    return this.api.delete('/users/delete', { params: { username } })
+  }
+
+  async setUserApps(username, apps) {
+    return this.api.put('/users/apps', { username, apps })
+  }
+
+  // 工作区管理（超级管理员），进入某个工作区后其余接口均作用于该工作区
+  async listWorkspaces() {
+    return this.api.get('/workspaces/list')
+  }
+
+  async createWorkspace(data) {
+    return this.api.post('/workspaces/create', data)
+  }
+
+  async updateWorkspace(data) {
+    return this.api.put('/workspaces/update', data)
+  }
+
+  async deleteWorkspace(id) {
+    return this.api.delete('/workspaces/delete', { params: { id } })
+  }
+
+  enterWorkspace(workspaceId) {
+    const store = useStore()
+    store.setWorkspace(workspaceId)
+  }
 }
 
 export default new ApiService()
@@ -1,16 +1,22 @@
 import { defineStore } from 'pinia'
 
 const TOKEN_KEY = 'token'
+const REFRESH_TOKEN_KEY = 'refresh_token'
 const USER_KEY = 'user'
+const WORKSPACE_KEY = 'workspace_id'
 
 export const useStore = defineStore('global', {
   state: () => ({
     token: localStorage.getItem(TOKEN_KEY) || null,
-    user: JSON.parse(localStorage.getItem(USER_KEY) || 'null')
+    refreshToken: localStorage.getItem(REFRESH_TOKEN_KEY) || null,
+    user: JSON.parse(localStorage.getItem(USER_KEY) || 'null'),
+    // 超级管理员当前进入的工作区，为空时使用账号所属工作区
+    workspaceId: localStorage.getItem(WORKSPACE_KEY) || null
   }),
   getters: {
     isAuthenticated: (state) => !!state.token,
-    isAdmin: (state) => state.user?.role === 'admin'
+    isAdmin: (state) => state.user?.role === 'admin' || state.user?.role === 'superadmin',
+    isSuperAdmin: (state) => state.user?.role === 'superadmin'
   },
   actions: {
     setUser(token, user) {
@@ -19,17 +25,39 @@ export const useStore = defineStore('global', {
       localStorage.setItem(TOKEN_KEY, token || '')
       localStorage.setItem(USER_KEY, JSON.stringify(user || null))
     },
+    setTokens(token, refreshToken) {
+      this.token = token
+      this.refreshToken = refreshToken
+      localStorage.setItem(TOKEN_KEY, token || '')
+      localStorage.setItem(REFRESH_TOKEN_KEY, refreshToken || '')
+    },
+    setWorkspace(workspaceId) {
+      this.workspaceId = workspaceId || null
+      if (workspaceId) {
+        localStorage.setItem(WORKSPACE_KEY, workspaceId)
+      } else {
+        localStorage.removeItem(WORKSPACE_KEY)
+      }
+    },
     clearUser() {
       this.token = null
+      this.refreshToken = null
       this.user = null
       localStorage.removeItem(TOKEN_KEY)
+      localStorage.removeItem(REFRESH_TOKEN_KEY)
       localStorage.removeItem(USER_KEY)
+      this.workspaceId = null
+      localStorage.removeItem(WORKSPACE_KEY)
     },
     reset() {
       this.token = null
+      this.refreshToken = null
       this.user = null
       localStorage.removeItem(TOKEN_KEY)
+      localStorage.removeItem(REFRESH_TOKEN_KEY)
       localStorage.removeItem(USER_KEY)
+      this.workspaceId = null
+      localStorage.removeItem(WORKSPACE_KEY)
     }
   }
 })
@@ -1,5 +1,7 @@
 import axios from "axios";
 
+const VISITOR_TOKEN_KEY = "zerospace_kefu_visitor_token";
+
 class Api {
   constructor() {
     this.baseURL = "http://localhost:5300";
@@ -41,6 +43,69 @@ class Api {
       throw new Error(error.response?.data?.msg || "获取配置失败");
     }
   }
+
+  // 申请匿名访客令牌，传入当前令牌时续期并保留原 visitor_id
+  async getVisitorToken(appId, visitorToken) {
+    try {
+      const response = await this.api.post("/api/v1/visitor/token", {
+        appid: appId,
+        visitor_token: visitorToken || undefined,
+      });
+      return response.data.data;
+    } catch (error) {
+      throw new Error(error.response?.data?.msg || "获取访客令牌失败");
+    }
+  }
+
+  // 返回本地保存的有效访客令牌，即将过期或已过期时自动续期
+  async ensureVisitorToken(appId) {
+    const key = `${VISITOR_TOKEN_KEY}_${appId}`;
+    let saved = null;
+    try {
+      saved = JSON.parse(localStorage.getItem(key) || "null");
+    } catch (e) {
+      saved = null;
+    }
+    // 提前一分钟续期，避免连接时恰好过期
+    if (saved?.visitor_token && saved.expires_at * 1000 - Date.now() > 60 * 1000) {
+      return saved;
+    }
+    const fresh = await this.getVisitorToken(appId, saved?.visitor_token);
+    localStorage.setItem(key, JSON.stringify(fresh));
+    return fresh;
+  }
+
+  // 保存服务端通过 visitor.token 推送的续期令牌 { visitor_id, visitor_token, expires_at }，
+  // 格式与 getVisitorToken 的返回值相同，下次连接时由 ensureVisitorToken 取用
+  saveVisitorToken(appId, token) {
+    if (!token?.visitor_id || !token?.visitor_token || !token?.expires_at) {
+      console.error("invalid visitor token:", token);
+      return;
+    }
+    const { visitor_id, visitor_token, expires_at } = token;
+    localStorage.setItem(
+      `${VISITOR_TOKEN_KEY}_${appId}`,
+      JSON.stringify({ visitor_id, visitor_token, expires_at })
+    );
+  }
+
+  // 提交咨询前表单（字段定义见 getConfig 返回的 pre_chat_form），也可在连接后发送 prechat.submit
+  // identity 为 { visitorToken }（匿名访客）、{ userHash } 或 { identityToken }
+  async submitPreChat(appId, visitorId, fields, identity = {}) {
+    try {
+      const response = await this.api.post("/api/v1/prechat", {
+        appid: appId,
+        visitor_id: visitorId,
+        visitor_token: identity.visitorToken,
+        user_hash: identity.userHash,
+        identity_token: identity.identityToken,
+        fields,
+      });
+      return response.data;
+    } catch (error) {
+      throw new Error(error.response?.data?.msg || "提交表单失败");
+    }
+  }
 }
 
 export default new Api();
\ No newline at end of file
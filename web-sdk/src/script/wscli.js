@@ -1,18 +1,30 @@
 // wscli.js
 
 /**
- * 消息类型协议（前后端约定）
+ * 协议版本，连接时以 WebSocket 子协议 kefu.v{版本} 协商，服务端以 hello 帧确认
+ */
+export const PROTOCOL_VERSION = 1;
+const SUBPROTOCOL = `kefu.v${PROTOCOL_VERSION}`;
+
+/**
+ * 消息类型协议（与服务端 protocol 包一致），每帧为 { type, id, session_id, payload }
  */
 export const MSG_TYPES = {
   // 客户端 → 服务端
   REQ_MESSAGE: "message.req",
   TYPING_START: "typing.start",
   SESSION_CLOSE: "session.close",
+  PRECHAT_SUBMIT: "prechat.submit", // payload 为 { 字段: 值 }
 
   // 服务端 → 客户端
+  HELLO: "hello", // 连接建立：{ version, session_id, visitor_id }
+  ERROR: "error", // 帧被拒绝：{ code, message, ref }，ref 为出错帧的 id
   RSP_MESSAGE: "message.rsp",
   SESSION_UPDATE: "session.update",
   TYPING_INDICATOR: "typing.start",
+  PRECHAT_ACK: "prechat.ack",
+  PRECHAT_REQUIRED: "prechat.required", // 需先提交咨询前表单才能分配客服
+  VISITOR_TOKEN: "visitor.token", // 续期后的访客令牌：{ visitor_id, visitor_token, expires_at }
 };
 
 /**
@@ -46,18 +58,44 @@ export class WSClient {
     this.onStatusChange = options.onStatusChange || (() => {});
     this.onError = options.onError || console.error;
     this.onConnected = options.onConnected || (() => {});
+    // 业务开启身份验证时由接入方服务端签发，二选一
+    this.userHash = options.userHash || "";
+    this.identityToken = options.identityToken || "";
+    // 匿名访客：每次连接前获取有效的访客令牌（见 api.ensureVisitorToken）；
+    // 令牌剩余有效期不足一半时服务端随连接推送新令牌，回调参数为 { visitor_id, visitor_token, expires_at }，
+    // 可直接交给 api.saveVisitorToken 保存
+    this.visitorTokenProvider = options.visitorTokenProvider || null;
+    this.onVisitorToken = options.onVisitorToken || (() => {});
 
     this.ws = null;
+    this.sessionId = "";
+    this.protocolVersion = 0;
+    this.frameSeq = 0;
     this.isConnected = false;
     this.reconnectAttempts = 0;
     this.maxReconnectAttempts = 5;
   }
 
-  connect() {
+  async connect() {
     if (this.ws?.readyState === WebSocket.OPEN) return;
 
-    const url = `${this.wsUrl}?appid=${encodeURIComponent(this.appid)}&visitor_id=${encodeURIComponent(this.visitorId)}`;
-    this.ws = new WebSocket(url);
+    let url = `${this.wsUrl}?appid=${encodeURIComponent(this.appid)}`;
+    if (this.identityToken) {
+      url += `&identity_token=${encodeURIComponent(this.identityToken)}`;
+    } else if (this.userHash) {
+      url += `&visitor_id=${encodeURIComponent(this.visitorId)}&user_hash=${encodeURIComponent(this.userHash)}`;
+    } else if (this.visitorTokenProvider) {
+      try {
+        const { visitor_id, visitor_token } = await this.visitorTokenProvider();
+        this.visitorId = visitor_id;
+        url += `&visitor_token=${encodeURIComponent(visitor_token)}`;
+      } catch (e) {
+        this.onError("get visitor token failed:", e);
+        this._reconnect();
+        return;
+      }
+    }
+    this.ws = new WebSocket(url, [SUBPROTOCOL]);
 
     this.ws.onopen = () => {
       this.isConnected = true;
@@ -89,6 +127,15 @@ export class WSClient {
 
   _handleIncoming(msg) {
     switch (msg.type) {
+      case MSG_TYPES.HELLO:
+        this.protocolVersion = msg.payload.version;
+        this.sessionId = msg.payload.session_id;
+        break;
+
+      case MSG_TYPES.ERROR:
+        this.onError("WS frame rejected:", msg.payload);
+        break;
+
       case MSG_TYPES.RSP_MESSAGE:
         this.onMessage({
           type: "message",
@@ -112,6 +159,20 @@ export class WSClient {
         this.onMessage({ type: "typing", from: msg.payload.from });
         break;
 
+      case MSG_TYPES.PRECHAT_REQUIRED:
+        this.onStatusChange("prechat-required");
+        break;
+
+      case MSG_TYPES.PRECHAT_ACK:
+        this.onStatusChange("prechat-accepted", msg.payload);
+        break;
+
+      case MSG_TYPES.VISITOR_TOKEN:
+        // 续期保留原 visitor_id，新令牌在下次连接时使用，当前连接不受影响
+        this.visitorId = msg.payload.visitor_id;
+        this.onVisitorToken(msg.payload);
+        break;
+
       default:
       // 忽略未知类型（未来兼容）
     }
@@ -165,9 +226,18 @@ export class WSClient {
     this._send(MSG_TYPES.SESSION_CLOSE);
   }
 
+  // 提交咨询前表单，fields 为 { 字段: 值 }
+  submitPreChat(fields) {
+    if (!this.isConnected) return;
+    this._send(MSG_TYPES.PRECHAT_SUBMIT, fields);
+  }
+
   // --- 内部方法 ---
-  _send(type, payload = {}) {
-    this.ws.send(JSON.stringify({ type, payload }));
+  // 返回帧 id，出错时服务端在错误帧的 ref 中带回
+  _send(type, payload) {
+    const id = String(++this.frameSeq);
+    this.ws.send(JSON.stringify(payload === undefined ? { type, id } : { type, id, payload }));
+    return id;
   }
 
   _reconnect() {